@@ -4,27 +4,51 @@ import (
     "database/sql"
     "fmt"
     "os"
-    _ "github.com/go-sql-driver/mysql"
-)
+    "time"
 
-var DB *sql.DB
+    "github.com/go-sql-driver/mysql"
+)
 
-// Init initializes the MariaDB connection. The DSN can be overridden by the
+// Open opens and verifies a MariaDB connection. The DSN can be overridden by the
 // MARIADB_DSN environment variable. A sample DSN: user:password@tcp(localhost:3306)/dbname
-func Init() error {
+//
+// Whatever the DSN says, DATETIME columns are scanned as time.Time and the
+// session time zone is UTC, so timestamps mean the same thing on every
+// connection.
+func Open() (*sql.DB, error) {
     dsn := "root:password@tcp(localhost:3306)/mydb"
     // Allow override via env var for flexibility
     if envDSN := os.Getenv("MARIADB_DSN"); envDSN != "" {
         dsn = envDSN
     }
-    var err error
-    DB, err = sql.Open("mysql", dsn)
+    dsn, err := normalizeDSN(dsn)
     if err != nil {
-        return fmt.Errorf("sql.Open: %w", err)
+        return nil, err
+    }
+    conn, err := sql.Open("mysql", dsn)
+    if err != nil {
+        return nil, fmt.Errorf("sql.Open: %w", err)
     }
     // Verify connection
-    if err = DB.Ping(); err != nil {
-        return fmt.Errorf("db ping: %w", err)
+    if err = conn.Ping(); err != nil {
+        conn.Close()
+        return nil, fmt.Errorf("db ping: %w", err)
+    }
+    return conn, nil
+}
+
+// normalizeDSN enables parseTime and pins both the driver's and the
+// session's time zone to UTC.
+func normalizeDSN(dsn string) (string, error) {
+    cfg, err := mysql.ParseDSN(dsn)
+    if err != nil {
+        return "", fmt.Errorf("parse DSN: %w", err)
+    }
+    cfg.ParseTime = true
+    cfg.Loc = time.UTC
+    if cfg.Params == nil {
+        cfg.Params = make(map[string]string)
     }
-    return nil
+    cfg.Params["time_zone"] = "'+00:00'"
+    return cfg.FormatDSN(), nil
 }
@@ -0,0 +1,28 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestNormalizeDSN(t *testing.T) {
+	dsn, err := normalizeDSN("user:pw@tcp(db:3306)/app?parseTime=false&loc=Local&charset=utf8mb4")
+	if err != nil {
+		t.Fatalf("normalizeDSN returned error: %v", err)
+	}
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		t.Fatalf("normalized DSN %q does not parse: %v", dsn, err)
+	}
+	if !cfg.ParseTime || cfg.Loc != time.UTC || cfg.Params["time_zone"] != "'+00:00'" {
+		t.Fatalf("expected parseTime and UTC settings, got %q", dsn)
+	}
+	if cfg.User != "user" || cfg.Addr != "db:3306" || cfg.DBName != "app" || cfg.Params["charset"] != "utf8mb4" {
+		t.Fatalf("expected other settings to be kept, got %q", dsn)
+	}
+	if _, err := normalizeDSN("not a dsn"); err == nil {
+		t.Fatalf("expected an error for an invalid DSN")
+	}
+}
@@ -0,0 +1,38 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations returned error: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatalf("expected embedded migrations")
+	}
+	for i, m := range migrations {
+		if m.Version != int64(i+1) {
+			t.Fatalf("expected version %d, got %d (%s)", i+1, m.Version, m.Name)
+		}
+		if len(splitStatements(m.Up)) == 0 || len(splitStatements(m.Down)) == 0 {
+			t.Fatalf("migration %d has an empty up or down script", m.Version)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- comment
+CREATE TABLE a (
+    id BIGINT
+);
+
+DROP TABLE b;
+SELECT 1`
+	want := []string{"CREATE TABLE a (\n    id BIGINT\n)", "DROP TABLE b", "SELECT 1"}
+	if got := splitStatements(script); !reflect.DeepEqual(got, want) {
+		t.Fatalf("splitStatements = %q, want %q", got, want)
+	}
+}
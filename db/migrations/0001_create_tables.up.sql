@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS items (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS collections (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS collection_items (
+    collection_id BIGINT NOT NULL,
+    item_id BIGINT NOT NULL,
+    PRIMARY KEY (collection_id, item_id),
+    KEY idx_collection_items_item_id (item_id)
+) ENGINE=InnoDB;
@@ -0,0 +1,2 @@
+ALTER TABLE collections DROP INDEX ft_collections_name_description;
+ALTER TABLE items DROP INDEX ft_items_name_description;
@@ -0,0 +1,2 @@
+ALTER TABLE items ADD FULLTEXT INDEX ft_items_name_description (name, description);
+ALTER TABLE collections ADD FULLTEXT INDEX ft_collections_name_description (name, description);
@@ -0,0 +1,3 @@
+ALTER TABLE collection_items
+    DROP FOREIGN KEY fk_collection_items_collection,
+    DROP FOREIGN KEY fk_collection_items_item;
@@ -0,0 +1,7 @@
+DELETE ci FROM collection_items ci
+    LEFT JOIN collections c ON c.id = ci.collection_id
+    LEFT JOIN items i ON i.id = ci.item_id
+    WHERE c.id IS NULL OR i.id IS NULL;
+ALTER TABLE collection_items
+    ADD CONSTRAINT fk_collection_items_collection FOREIGN KEY (collection_id) REFERENCES collections (id) ON DELETE CASCADE,
+    ADD CONSTRAINT fk_collection_items_item FOREIGN KEY (item_id) REFERENCES items (id) ON DELETE CASCADE;
@@ -0,0 +1,2 @@
+ALTER TABLE collections DROP COLUMN members_updated_at, DROP COLUMN updated_at;
+ALTER TABLE items DROP COLUMN updated_at;
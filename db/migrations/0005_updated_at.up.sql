@@ -0,0 +1,4 @@
+ALTER TABLE items ADD COLUMN updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE collections
+    ADD COLUMN updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ADD COLUMN members_updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP;
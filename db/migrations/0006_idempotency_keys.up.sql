@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    idempotency_key VARCHAR(255) NOT NULL PRIMARY KEY,
+    fingerprint CHAR(64) NOT NULL,
+    status_code INT NULL,
+    response_header TEXT NULL,
+    response_body MEDIUMBLOB NULL,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    KEY idx_idempotency_keys_expires_at (expires_at)
+) ENGINE=InnoDB;
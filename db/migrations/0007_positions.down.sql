@@ -0,0 +1,3 @@
+ALTER TABLE collection_items
+    DROP KEY idx_collection_items_position,
+    DROP COLUMN position;
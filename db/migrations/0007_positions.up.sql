@@ -0,0 +1,4 @@
+ALTER TABLE collection_items
+    ADD COLUMN position BIGINT NOT NULL DEFAULT 0,
+    ADD KEY idx_collection_items_position (collection_id, position);
+UPDATE collection_items SET position = item_id * 1024;
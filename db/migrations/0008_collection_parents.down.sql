@@ -0,0 +1,3 @@
+ALTER TABLE collections
+    DROP KEY idx_collections_parent_id,
+    DROP COLUMN parent_id;
@@ -0,0 +1,3 @@
+ALTER TABLE collections
+    ADD COLUMN parent_id BIGINT NULL,
+    ADD KEY idx_collections_parent_id (parent_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS collection_tags;
+DROP TABLE IF EXISTS item_tags;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS item_tags (
+    item_id BIGINT NOT NULL,
+    tag VARCHAR(64) NOT NULL,
+    PRIMARY KEY (item_id, tag),
+    KEY idx_item_tags_tag (tag)
+) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS collection_tags (
+    collection_id BIGINT NOT NULL,
+    tag VARCHAR(64) NOT NULL,
+    PRIMARY KEY (collection_id, tag),
+    KEY idx_collection_tags_tag (tag)
+) ENGINE=InnoDB;
@@ -0,0 +1,2 @@
+ALTER TABLE collections
+    ADD COLUMN rule TEXT NULL;
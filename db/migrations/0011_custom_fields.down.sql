@@ -0,0 +1,3 @@
+ALTER TABLE collections
+    DROP COLUMN field_schema;
+DROP TABLE IF EXISTS item_fields;
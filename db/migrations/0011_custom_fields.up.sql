@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS item_fields (
+    item_id BIGINT NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    type VARCHAR(8) NOT NULL,
+    value VARCHAR(1024) NOT NULL,
+    number DOUBLE NULL,
+    sort_key VARCHAR(1025) NOT NULL,
+    PRIMARY KEY (item_id, name)
+) ENGINE=InnoDB;
+
+ALTER TABLE collections
+    ADD COLUMN field_schema TEXT NULL;
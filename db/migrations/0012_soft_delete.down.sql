@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS deleted_collection_items;
+DELETE FROM collection_tags WHERE collection_id IN (SELECT id FROM collections WHERE deleted_at IS NOT NULL);
+DELETE FROM collections WHERE deleted_at IS NOT NULL;
+DELETE FROM item_fields WHERE item_id IN (SELECT id FROM items WHERE deleted_at IS NOT NULL);
+DELETE FROM item_tags WHERE item_id IN (SELECT id FROM items WHERE deleted_at IS NOT NULL);
+DELETE FROM items WHERE deleted_at IS NOT NULL;
+ALTER TABLE collections
+    DROP COLUMN deleted_at;
+ALTER TABLE items
+    DROP COLUMN deleted_at;
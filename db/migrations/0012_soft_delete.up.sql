@@ -0,0 +1,16 @@
+ALTER TABLE items
+    ADD COLUMN deleted_at DATETIME NULL,
+    ADD KEY idx_items_deleted_at (deleted_at);
+ALTER TABLE collections
+    ADD COLUMN deleted_at DATETIME NULL,
+    ADD KEY idx_collections_deleted_at (deleted_at);
+
+CREATE TABLE IF NOT EXISTS deleted_collection_items (
+    collection_id BIGINT NOT NULL,
+    item_id BIGINT NOT NULL,
+    position BIGINT NOT NULL,
+    PRIMARY KEY (collection_id, item_id),
+    KEY idx_deleted_collection_items_item_id (item_id),
+    CONSTRAINT fk_deleted_collection_items_collection FOREIGN KEY (collection_id) REFERENCES collections (id) ON DELETE CASCADE,
+    CONSTRAINT fk_deleted_collection_items_item FOREIGN KEY (item_id) REFERENCES items (id) ON DELETE CASCADE
+) ENGINE=InnoDB;
@@ -0,0 +1,3 @@
+ALTER TABLE collections
+    ADD COLUMN deleted_with BIGINT NULL;
+UPDATE collections SET deleted_with = id WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE idempotency_keys
+    MODIFY idempotency_key VARCHAR(255) NOT NULL;
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/mmontes11/opencode-test/db"
+	"github.com/mmontes11/opencode-test/store"
+)
+
+// runFsck implements the "fsck [-repair]" subcommand, which reports
+// memberships that reference missing collections or items, or live
+// memberships of deleted ones, and optionally repairs them.
+func runFsck(args []string) {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	repair := fs.Bool("repair", false, "delete the orphaned memberships that are found, or move those of deleted rows to the trash")
+	fs.Parse(args)
+
+	conn, err := db.Open()
+	if err != nil {
+		log.Fatalf("failed to initialize database: %v", err)
+	}
+	defer conn.Close()
+	ctx := context.Background()
+
+	orphans, err := store.FindOrphans(ctx, conn)
+	if err != nil {
+		log.Fatalf("fsck: %v", err)
+	}
+	for _, o := range orphans {
+		var problem string
+		switch {
+		case o.MissingCollection && o.MissingItem:
+			problem = "missing collection and item"
+		case o.MissingCollection:
+			problem = "missing collection"
+		case o.MissingItem:
+			problem = "missing item"
+		case o.DeletedCollection && o.DeletedItem:
+			problem = "collection and item in the trash"
+		case o.DeletedCollection:
+			problem = "collection in the trash"
+		default:
+			problem = "item in the trash"
+		}
+		kind := "membership"
+		if o.Trashed {
+			kind = "trashed membership"
+		}
+		fmt.Printf("orphan %s collection=%d item=%d: %s\n", kind, o.CollectionID, o.ItemID, problem)
+	}
+	fmt.Printf("%d orphaned memberships found\n", len(orphans))
+	if !*repair || len(orphans) == 0 {
+		return
+	}
+	n, err := store.RepairOrphans(ctx, conn)
+	if err != nil {
+		log.Fatalf("fsck repair: %v", err)
+	}
+	fmt.Printf("%d orphaned memberships repaired\n", n)
+}
@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/mmontes11/opencode-test/store"
+)
+
+// MaxBatchRequests is the largest number of sub-requests a batch may carry.
+const MaxBatchRequests = 100
+
+// BatchRequest is the payload of POST /batch.
+// Example: {"atomic": true, "requests": [{"method": "POST", "path": "/v1/items", "body": {"name": "a"}}]}
+type BatchRequest struct {
+	// Atomic runs every sub-request in one transaction, which is rolled
+	// back when a sub-request fails.
+	Atomic   bool              `json:"atomic"`
+	Requests []BatchSubRequest `json:"requests"`
+}
+
+// BatchSubRequest is one request of a batch. Path is absolute, including
+// the /v1 prefix and any query string.
+type BatchSubRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// BatchResponse is the response body of POST /batch. Results are in the
+// order of the sub-requests. Committed is only set in atomic mode; when it
+// is false, the results end with the sub-request that failed and none of
+// the writes were kept.
+type BatchResponse struct {
+	Committed *bool              `json:"committed,omitempty"`
+	Results   []BatchSubResponse `json:"results"`
+}
+
+// BatchSubResponse is the response to one sub-request. Headers holds the
+// first value of each response header.
+type BatchSubResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// errBatchFailed rolls back an atomic batch.
+var errBatchFailed = errors.New("batch sub-request failed")
+
+// BatchHandler returns the handler of POST /batch. Sub-requests are served
+// in order by the router that routes returns for a store: the Handler's
+// own store, or in atomic mode a transaction of it.
+func (h *Handler) BatchHandler(routes func(s store.Store) http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req BatchRequest
+		if err := decodeBody(r, &req); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if len(req.Requests) == 0 || len(req.Requests) > MaxBatchRequests {
+			writeError(w, r, newError(CodeValidationFailed, "requests must contain between 1 and %d sub-requests", MaxBatchRequests))
+			return
+		}
+		subs := make([]*http.Request, len(req.Requests))
+		for i, sub := range req.Requests {
+			var err error
+			if subs[i], err = newSubRequest(r, sub); err != nil {
+				writeError(w, r, newError(CodeValidationFailed, "requests[%d]: %v", i, err))
+				return
+			}
+		}
+
+		var resp BatchResponse
+		run := func(s store.Store) error {
+			router := routes(s)
+			resp.Results = resp.Results[:0]
+			for _, sub := range subs {
+				rec := &subResponseWriter{header: make(http.Header), status: http.StatusOK}
+				router.ServeHTTP(rec, sub)
+				resp.Results = append(resp.Results, rec.response())
+				if req.Atomic && rec.status >= 400 {
+					return errBatchFailed
+				}
+			}
+			return nil
+		}
+		if !req.Atomic {
+			run(h.store)
+			writeJSON(w, http.StatusOK, resp)
+			return
+		}
+		err := h.store.WithTx(r.Context(), run)
+		if err != nil && !errors.Is(err, errBatchFailed) {
+			writeError(w, r, err)
+			return
+		}
+		committed := err == nil
+		resp.Committed = &committed
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// newSubRequest builds the request for one sub-request of the batch r.
+func newSubRequest(r *http.Request, sub BatchSubRequest) (*http.Request, error) {
+	method := strings.ToUpper(sub.Method)
+	switch method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		return nil, errors.New("method must be GET, POST, PUT, PATCH or DELETE")
+	}
+	if !strings.HasPrefix(sub.Path, "/") {
+		return nil, errors.New("path must start with /")
+	}
+	if path, _, _ := strings.Cut(sub.Path, "?"); strings.TrimSuffix(path, "/") == strings.TrimSuffix(r.URL.Path, "/") {
+		return nil, errors.New("batches cannot be nested")
+	}
+	req, err := http.NewRequestWithContext(r.Context(), method, sub.Path, bytes.NewReader(sub.Body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range sub.Headers {
+		req.Header.Set(k, v)
+	}
+	if len(sub.Body) > 0 && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// subResponseWriter records the response to a sub-request.
+type subResponseWriter struct {
+	header      http.Header
+	status      int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (w *subResponseWriter) Header() http.Header { return w.header }
+
+func (w *subResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.status = status
+	}
+}
+
+func (w *subResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
+
+func (w *subResponseWriter) response() BatchSubResponse {
+	resp := BatchSubResponse{Status: w.status, Headers: make(map[string]string, len(w.header))}
+	for k := range w.header {
+		resp.Headers[k] = w.header.Get(k)
+	}
+	if body := bytes.TrimSpace(w.body.Bytes()); len(body) > 0 {
+		resp.Body = json.RawMessage(body)
+	}
+	return resp
+}
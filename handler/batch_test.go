@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mmontes11/opencode-test/search"
+	"github.com/mmontes11/opencode-test/store"
+)
+
+// batchRouter serves the item routes and /v1/batch from s, like the real
+// router does.
+func batchRouter(s store.Store) http.Handler {
+	h := New(s, search.NewMemory())
+	r := mux.NewRouter()
+	r.HandleFunc("/v1/items", h.CreateItemHandler).Methods("POST")
+	r.HandleFunc("/v1/items/{id}", h.GetItemHandler).Methods("GET")
+	r.HandleFunc("/v1/items/{id}", h.UpdateItemHandler).Methods("PUT")
+	r.HandleFunc("/v1/batch", h.BatchHandler(batchRouter)).Methods("POST")
+	return r
+}
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+	s := initDBForTest(t)
+	item, err := s.CreateItem(ctx, "batched", "")
+	if err != nil {
+		t.Fatalf("CreateItem: %v", err)
+	}
+	t.Cleanup(func() { s.DeleteItem(ctx, item.ID, 0) })
+	r := batchRouter(s)
+	path := fmt.Sprintf("/v1/items/%d", item.ID)
+
+	batch := func(body string) BatchResponse {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", "/v1/batch", bytes.NewBufferString(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("batch: expected 200, got %d: %s", w.Code, w.Body)
+		}
+		var resp BatchResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode batch response: %v", err)
+		}
+		return resp
+	}
+	name := func() string {
+		t.Helper()
+		got, err := s.GetItem(ctx, item.ID)
+		if err != nil {
+			t.Fatalf("GetItem: %v", err)
+		}
+		return got.Name
+	}
+
+	// Without atomic, every sub-request runs and keeps its effect.
+	resp := batch(fmt.Sprintf(`{"requests":[
+		{"method":"PUT","path":%q,"body":{"name":"first"}},
+		{"method":"PUT","path":%q,"headers":{"If-Match":"\"99\""},"body":{"name":"stale"}},
+		{"method":"GET","path":%q}]}`, path, path, path))
+	if resp.Committed != nil || len(resp.Results) != 3 {
+		t.Fatalf("non-atomic batch: got %+v", resp)
+	}
+	if resp.Results[0].Status != http.StatusOK || resp.Results[1].Status != http.StatusPreconditionFailed || resp.Results[2].Status != http.StatusOK {
+		t.Fatalf("non-atomic batch statuses: got %+v", resp.Results)
+	}
+	var got ItemResponse
+	if err := json.Unmarshal(resp.Results[2].Body, &got); err != nil || got.Name != "first" || resp.Results[2].Headers["Etag"] != etag(got.Version) {
+		t.Fatalf("GET sub-response: got %+v %v (%v)", got, resp.Results[2].Headers, err)
+	}
+
+	// An atomic batch rolls back every write when a sub-request fails.
+	resp = batch(fmt.Sprintf(`{"atomic":true,"requests":[
+		{"method":"PUT","path":%q,"body":{"name":"rolled back"}},
+		{"method":"PUT","path":%q,"body":{}},
+		{"method":"GET","path":%q}]}`, path, path, path))
+	if resp.Committed == nil || *resp.Committed || len(resp.Results) != 2 || resp.Results[1].Status != http.StatusBadRequest {
+		t.Fatalf("failed atomic batch: got %+v", resp)
+	}
+	if n := name(); n != "first" {
+		t.Fatalf("failed atomic batch left name %q, want %q", n, "first")
+	}
+
+	resp = batch(fmt.Sprintf(`{"atomic":true,"requests":[
+		{"method":"PUT","path":%q,"body":{"name":"second"}},
+		{"method":"GET","path":%q}]}`, path, path))
+	if resp.Committed == nil || !*resp.Committed || len(resp.Results) != 2 {
+		t.Fatalf("atomic batch: got %+v", resp)
+	}
+	if n := name(); n != "second" {
+		t.Fatalf("atomic batch left name %q, want %q", n, "second")
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/v1/batch", bytes.NewBufferString(`{"requests":[{"method":"POST","path":"/v1/batch"}]}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("nested batch: expected 400, got %d", w.Code)
+	}
+}
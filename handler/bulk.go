@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/mmontes11/opencode-test/store"
+)
+
+// MaxBulkItems is the largest number of item IDs a bulk membership request
+// may carry.
+const MaxBulkItems = 1000
+
+// BulkItemsRequest is the payload of the bulk membership endpoints.
+// Example: {"item_ids": [1, 2, 3]}
+type BulkItemsRequest struct {
+	ItemIDs []int64 `json:"item_ids"`
+}
+
+// MembershipResultResponse reports what a bulk membership request did to one
+// item. Status is one of added, already_present, removed, not_present,
+// missing_item or invalid_fields.
+type MembershipResultResponse struct {
+	ItemID int64  `json:"item_id"`
+	Status string `json:"status"`
+}
+
+// BulkItemsResponse is the response body of the bulk membership endpoints.
+type BulkItemsResponse struct {
+	Data []MembershipResultResponse `json:"data"`
+}
+
+// AddItemsToCollectionHandler handles POST /collections/{id}/items/bulk-add.
+func (h *Handler) AddItemsToCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	h.bulkMembers(w, r, h.store.AddItemsToCollection)
+}
+
+// RemoveItemsFromCollectionHandler handles
+// POST /collections/{id}/items/bulk-remove.
+func (h *Handler) RemoveItemsFromCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	h.bulkMembers(w, r, h.store.RemoveItemsFromCollection)
+}
+
+// ReplaceCollectionItemsHandler handles PUT /collections/{id}/items.
+func (h *Handler) ReplaceCollectionItemsHandler(w http.ResponseWriter, r *http.Request) {
+	h.bulkMembers(w, r, h.store.ReplaceCollectionItems)
+}
+
+// bulkMembers decodes a bulk membership request, applies it with op and
+// writes the per-item results.
+func (h *Handler) bulkMembers(w http.ResponseWriter, r *http.Request, op func(ctx context.Context, collectionID int64, itemIDs []int64) ([]store.MembershipResult, error)) {
+	colID, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var req BulkItemsRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.ItemIDs == nil {
+		writeError(w, r, newError(CodeValidationFailed, "item_ids is required"))
+		return
+	}
+	if len(req.ItemIDs) > MaxBulkItems {
+		writeError(w, r, newError(CodeValidationFailed, "item_ids may not contain more than %d IDs", MaxBulkItems))
+		return
+	}
+	results, err := op(r.Context(), colID, req.ItemIDs)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	resp := BulkItemsResponse{Data: make([]MembershipResultResponse, len(results))}
+	for i, res := range results {
+		resp.Data[i] = MembershipResultResponse{ItemID: res.ItemID, Status: string(res.Status)}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
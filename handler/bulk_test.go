@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mmontes11/opencode-test/search"
+)
+
+func TestBulkMembershipEndpoints(t *testing.T) {
+	ctx := context.Background()
+	s := initDBForTest(t)
+	col, err := s.CreateCollection(ctx, "bulk", "")
+	if err != nil {
+		t.Fatalf("CreateCollection: %v", err)
+	}
+	t.Cleanup(func() { s.DeleteCollection(ctx, col.ID, 0) })
+	item, err := s.CreateItem(ctx, "bulk item", "")
+	if err != nil {
+		t.Fatalf("CreateItem: %v", err)
+	}
+	t.Cleanup(func() { s.DeleteItem(ctx, item.ID, 0) })
+	h := New(s, search.NewMemory())
+	r := mux.NewRouter()
+	r.HandleFunc("/collections/{id}/items", h.ReplaceCollectionItemsHandler).Methods("PUT")
+	r.HandleFunc("/collections/{id}/items/bulk-add", h.AddItemsToCollectionHandler).Methods("POST")
+	r.HandleFunc("/collections/{id}/items/bulk-remove", h.RemoveItemsFromCollectionHandler).Methods("POST")
+
+	do := func(method, path, body string) (int, BulkItemsResponse) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		var resp BulkItemsResponse
+		if w.Code == http.StatusOK {
+			json.NewDecoder(w.Body).Decode(&resp)
+		}
+		return w.Code, resp
+	}
+	base := fmt.Sprintf("/collections/%d/items", col.ID)
+
+	code, resp := do("POST", base+"/bulk-add", fmt.Sprintf(`{"item_ids":[%d,%d]}`, item.ID, item.ID+1000000))
+	if code != http.StatusOK || len(resp.Data) != 2 || resp.Data[0].Status != "added" || resp.Data[1].Status != "missing_item" {
+		t.Fatalf("bulk-add: got %d %+v", code, resp)
+	}
+	code, resp = do("POST", base+"/bulk-remove", fmt.Sprintf(`{"item_ids":[%d]}`, item.ID))
+	if code != http.StatusOK || len(resp.Data) != 1 || resp.Data[0].Status != "removed" {
+		t.Fatalf("bulk-remove: got %d %+v", code, resp)
+	}
+	code, resp = do("PUT", base, fmt.Sprintf(`{"item_ids":[%d]}`, item.ID))
+	if code != http.StatusOK || len(resp.Data) != 1 || resp.Data[0].Status != "added" {
+		t.Fatalf("replace: got %d %+v", code, resp)
+	}
+	if code, _ := do("PUT", base, `{}`); code != http.StatusBadRequest {
+		t.Fatalf("replace without item_ids: expected 400, got %d", code)
+	}
+	if code, _ := do("POST", fmt.Sprintf("/collections/%d/items/bulk-add", col.ID+1000000), `{"item_ids":[1]}`); code != http.StatusNotFound {
+		t.Fatalf("bulk-add to missing collection: expected 404, got %d", code)
+	}
+}
@@ -1,190 +1,361 @@
 package handler
 
 import (
-	"database/sql"
-	"encoding/json"
-	"fmt"
 	"net/http"
-	"strconv"
+	"time"
 
-	"github.com/gorilla/mux"
-	"github.com/mmontes11/opencode-test/db"
 	"github.com/mmontes11/opencode-test/store"
+	"github.com/mmontes11/opencode-test/store/filter"
 )
 
 // CollectionRequest represents the expected payload for creating or updating a collection.
-// The fields mirror the database columns.
+// The fields mirror the database columns. A non-empty rule makes the
+// collection smart; a PUT without a rule leaves it unchanged.
 //
 // Example JSON payload:
 //
 //	{"name": "My collection", "description": "A collection of items"}
 type CollectionRequest struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Rule        *string `json:"rule,omitempty"`
 }
 
 // ItemInCollectionRequest represents the payload for adding an item to a collection.
-// Example: {"item_id": 42}
+// The item is appended unless the placement says otherwise.
+// Example: {"item_id": 42, "before": 7}
 type ItemInCollectionRequest struct {
 	ItemID int64 `json:"item_id"`
+	PlacementRequest
+}
+
+// PlacementRequest says where an item goes in a collection: at a zero-based
+// position, or before or after another member. At most one may be given.
+// Example: {"position": 0}
+type PlacementRequest struct {
+	Position *int  `json:"position,omitempty"`
+	Before   int64 `json:"before,omitempty"`
+	After    int64 `json:"after,omitempty"`
+}
+
+// placement converts the request to a store placement. It reports false if
+// no placement was given.
+func (p PlacementRequest) placement() (store.Placement, bool, error) {
+	given := 0
+	at := store.AtEnd
+	if p.Position != nil {
+		if *p.Position < 0 {
+			return at, false, newError(CodeValidationFailed, "position must not be negative")
+		}
+		given++
+		at.Index = *p.Position
+	}
+	if p.Before != 0 {
+		given++
+		at.Before = p.Before
+	}
+	if p.After != 0 {
+		given++
+		at.After = p.After
+	}
+	if given > 1 {
+		return at, false, newError(CodeValidationFailed, "only one of position, before and after may be given")
+	}
+	return at, given == 1, nil
 }
 
 // CreateCollectionHandler handles POST /collections.
-func CreateCollectionHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) CreateCollectionHandler(w http.ResponseWriter, r *http.Request) {
 	var req CollectionRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, r, err)
 		return
 	}
 	if req.Name == "" {
-		http.Error(w, "name is required", http.StatusBadRequest)
+		writeError(w, r, newError(CodeValidationFailed, "name is required"))
 		return
 	}
-	col, err := store.CreateCollection(db.DB, req.Name, req.Description)
+	var col *store.Collection
+	var err error
+	if req.Rule != nil && *req.Rule != "" {
+		col, err = h.store.CreateSmartCollection(r.Context(), req.Name, req.Description, *req.Rule)
+	} else {
+		col, err = h.store.CreateCollection(r.Context(), req.Name, req.Description)
+	}
 	if err != nil {
-		fmt.Printf("store.CreateCollection error: %v\n", err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	_ = json.NewEncoder(w).Encode(col)
+	setETag(w, col.Version)
+	writeJSON(w, http.StatusCreated, newCollectionResponse(col))
 }
 
 // GetCollectionHandler handles GET /collections/{id}.
-func GetCollectionHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	idStr, ok := vars["id"]
-	if !ok {
-		http.Error(w, "missing id", http.StatusBadRequest)
+func (h *Handler) GetCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	id, err := strconv.ParseInt(idStr, 10, 64)
+	col, err := h.store.GetCollection(r.Context(), id)
 	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	col, err := store.GetCollection(db.DB, id)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "collection not found", http.StatusNotFound)
-			return
-		}
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+	if notModified(w, r, etag(col.Version), col.UpdatedAt) {
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(col)
+	writeJSON(w, http.StatusOK, newCollectionResponse(col))
 }
 
 // ListCollectionHandler handles GET /collections.
-func ListCollectionHandler(w http.ResponseWriter, r *http.Request) {
-	cols, err := store.ListCollections(db.DB)
+func (h *Handler) ListCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r, filter.DefaultFields)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// The timestamp is read first, so that a change racing with the listing
+	// can only make Last-Modified older than the page.
+	lastModified, err := h.store.CollectionsLastModified(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	cols, next, err := h.store.ListCollections(r.Context(), opts)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(cols)
+	writePage(w, r, newCollectionResponses(cols), next, opts.Limit, lastModified)
 }
 
 // UpdateCollectionHandler handles PUT /collections/{id}.
-func UpdateCollectionHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.ParseInt(vars["id"], 10, 64)
+func (h *Handler) UpdateCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
 	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		writeError(w, r, err)
+		return
+	}
+	version, err := ifMatch(r)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 	var req CollectionRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, r, err)
 		return
 	}
 	if req.Name == "" {
-		http.Error(w, "name is required", http.StatusBadRequest)
+		writeError(w, r, newError(CodeValidationFailed, "name is required"))
+		return
+	}
+	var col *store.Collection
+	if req.Rule != nil {
+		// The rule is written with the other fields, under one version.
+		col, err = h.store.PatchCollection(r.Context(), id, store.Patch{Name: &req.Name, Description: &req.Description, Rule: req.Rule}, version)
+	} else {
+		col, err = h.store.UpdateCollection(r.Context(), id, req.Name, req.Description, version)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	setETag(w, col.Version)
+	writeJSON(w, http.StatusOK, newCollectionResponse(col))
+}
+
+// PatchCollectionHandler handles PATCH /collections/{id} with a JSON Merge Patch or a
+// JSON Patch. Only the fields the patch touches are written.
+func (h *Handler) PatchCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	col, err := store.UpdateCollection(db.DB, id, req.Name, req.Description)
+	w.Header().Set("Accept-Patch", acceptPatch)
+	var col *store.Collection
+	err = applyPatch(r, func() (patchTarget, error) {
+		cur, err := h.store.GetCollection(r.Context(), id)
+		if err != nil {
+			return patchTarget{}, err
+		}
+		return patchTarget{Name: cur.Name, Description: cur.Description, Rule: cur.Rule, Version: cur.Version}, nil
+	}, func(p store.Patch, version int64) error {
+		var err error
+		col, err = h.store.PatchCollection(r.Context(), id, p, version)
+		return err
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(col)
+	setETag(w, col.Version)
+	writeJSON(w, http.StatusOK, newCollectionResponse(col))
 }
 
-// DeleteCollectionHandler handles DELETE /collections/{id}.
-func DeleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.ParseInt(vars["id"], 10, 64)
+// DeleteCollectionHandler handles DELETE /collections/{id}. The collection
+// is moved to the trash; RestoreCollectionHandler brings it back.
+func (h *Handler) DeleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	version, err := ifMatch(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	recursive, err := queryBool(r, "recursive")
 	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	if err := store.DeleteCollection(db.DB, id); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if recursive {
+		_, err = h.store.DeleteCollectionTree(r.Context(), id, version)
+	} else {
+		err = h.store.DeleteCollection(r.Context(), id, version)
+	}
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
 // AddItemToCollectionHandler handles POST /collections/{id}/items.
-func AddItemToCollectionHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	colID, err := strconv.ParseInt(vars["id"], 10, 64)
+func (h *Handler) AddItemToCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	colID, err := pathID(r, "id")
 	if err != nil {
-		http.Error(w, "invalid collection id", http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 	var req ItemInCollectionRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, r, err)
 		return
 	}
 	if req.ItemID == 0 {
-		http.Error(w, "item_id is required", http.StatusBadRequest)
+		writeError(w, r, newError(CodeValidationFailed, "item_id is required"))
+		return
+	}
+	at, placed, err := req.placement()
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	if err := store.AddItemToCollection(db.DB, colID, req.ItemID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if placed {
+		err = h.store.InsertItemIntoCollection(r.Context(), colID, req.ItemID, at)
+	} else {
+		err = h.store.AddItemToCollection(r.Context(), colID, req.ItemID)
+	}
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusCreated)
 }
 
+// MoveItemInCollectionHandler handles POST /collections/{id}/items/{item_id}/move.
+func (h *Handler) MoveItemInCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	colID, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	itemID, err := pathID(r, "item_id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var req PlacementRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	to, placed, err := req.placement()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !placed {
+		writeError(w, r, newError(CodeValidationFailed, "one of position, before and after is required"))
+		return
+	}
+	if err := h.store.MoveItemInCollection(r.Context(), colID, itemID, to); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // ListItemsInCollectionHandler handles GET /collections/{id}/items.
-func ListItemsInCollectionHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	colID, err := strconv.ParseInt(vars["id"], 10, 64)
+func (h *Handler) ListItemsInCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	colID, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	opts, err := parseListOptions(r, filter.ItemFields)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	include, err := includeCollections(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if opts.Recursive, err = queryBool(r, "recursive"); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// The collection is read first, so that a membership change racing with
+	// the listing can only make Last-Modified older than the page.
+	col, err := h.store.GetCollection(r.Context(), colID)
 	if err != nil {
-		http.Error(w, "invalid collection id", http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	items, err := store.ListItemsInCollection(db.DB, colID)
+	items, next, err := h.store.ListItemsInCollection(r.Context(), colID, opts)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(items)
+	resp := newItemResponses(items)
+	lastModified := col.MembersUpdatedAt
+	if opts.Recursive || col.Rule != "" {
+		// Changes to descendants do not move the timestamp either, nor do
+		// changes to the items a rule selects.
+		lastModified = time.Time{}
+	}
+	if include {
+		if err := h.embedCollections(r.Context(), resp); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		// Other collections' memberships do not move this timestamp.
+		lastModified = time.Time{}
+	}
+	writePage(w, r, resp, next, opts.Limit, lastModified)
 }
 
 // RemoveItemFromCollectionHandler handles DELETE /collections/{id}/items/{item_id}.
-func RemoveItemFromCollectionHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	colID, err := strconv.ParseInt(vars["id"], 10, 64)
+func (h *Handler) RemoveItemFromCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	colID, err := pathID(r, "id")
 	if err != nil {
-		http.Error(w, "invalid collection id", http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	itemID, err := strconv.ParseInt(vars["item_id"], 10, 64)
+	itemID, err := pathID(r, "item_id")
 	if err != nil {
-		http.Error(w, "invalid item id", http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	if err := store.RemoveItemFromCollection(db.DB, colID, itemID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.store.RemoveItemFromCollection(r.Context(), colID, itemID); err != nil {
+		writeError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
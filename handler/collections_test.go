@@ -2,30 +2,43 @@ package handler
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
 	"github.com/mmontes11/opencode-test/db"
+	"github.com/mmontes11/opencode-test/search"
 	"github.com/mmontes11/opencode-test/store"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
-// Helper to initialize DB or skip test if DSN not set.
-func initDBForTest(t *testing.T) {
+// Helper to initialize a MariaDB-backed store, falling back to the in-memory
+// store if DSN not set. The connection is closed when the test finishes.
+func initDBForTest(t *testing.T) store.Store {
 	dsn := os.Getenv("MARIADB_DSN")
 	if dsn == "" {
-		t.Skip("MARIADB_DSN env var not set; skipping integration tests")
+		return store.NewMemory()
 	}
-	if err := db.Init(); err != nil {
+	conn, err := db.Open()
+	if err != nil {
 		t.Fatalf("failed to init db: %v", err)
 	}
+	t.Cleanup(func() { conn.Close() })
+	if _, err := db.MigrateUp(context.Background(), conn); err != nil {
+		t.Fatalf("failed to migrate db: %v", err)
+	}
+	return store.NewMariaDB(conn)
 }
 
 func TestCreateCollection(t *testing.T) {
-	initDBForTest(t)
-	// Clean up after
-	defer db.DB.Close()
-	col, err := store.CreateCollection(db.DB, "test collection", "test description")
+	ctx := context.Background()
+	s := initDBForTest(t)
+	col, err := s.CreateCollection(ctx, "test collection", "test description")
 	if err != nil {
 		t.Fatalf("CreateCollection returned error: %v", err)
 	}
@@ -33,32 +46,32 @@ func TestCreateCollection(t *testing.T) {
 		t.Fatalf("unexpected collection data: %+v", col)
 	}
 	// delete
-	if err := store.DeleteCollection(db.DB, col.ID); err != nil {
+	if err := s.DeleteCollection(ctx, col.ID, 0); err != nil {
 		t.Fatalf("DeleteCollection returned error: %v", err)
 	}
 }
 
 func TestAddAndListItemsInCollection(t *testing.T) {
-	initDBForTest(t)
-	defer db.DB.Close()
+	ctx := context.Background()
+	s := initDBForTest(t)
 
 	// create collection
-	col, err := store.CreateCollection(db.DB, "col with items", "")
+	col, err := s.CreateCollection(ctx, "col with items", "")
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
 	// create items
-	item1, _ := store.CreateItem(db.DB, "item1", "")
-	item2, _ := store.CreateItem(db.DB, "item2", "")
+	item1, _ := s.CreateItem(ctx, "item1", "")
+	item2, _ := s.CreateItem(ctx, "item2", "")
 	// add to collection
-	if err := store.AddItemToCollection(db.DB, col.ID, item1.ID); err != nil {
+	if err := s.AddItemToCollection(ctx, col.ID, item1.ID); err != nil {
 		t.Fatalf("add failed: %v", err)
 	}
-	if err := store.AddItemToCollection(db.DB, col.ID, item2.ID); err != nil {
+	if err := s.AddItemToCollection(ctx, col.ID, item2.ID); err != nil {
 		t.Fatalf("add failed: %v", err)
 	}
 	// list
-	items, err := store.ListItemsInCollection(db.DB, col.ID)
+	items, _, err := s.ListItemsInCollection(ctx, col.ID, store.ListOptions{})
 	if err != nil {
 		t.Fatalf("list failed: %v", err)
 	}
@@ -66,37 +79,367 @@ func TestAddAndListItemsInCollection(t *testing.T) {
 		t.Fatalf("expected 2 items, got %d", len(items))
 	}
 	// cleanup
-	store.DeleteItem(db.DB, item1.ID)
-	store.DeleteItem(db.DB, item2.ID)
-	store.DeleteCollection(db.DB, col.ID)
+	s.DeleteItem(ctx, item1.ID, 0)
+	s.DeleteItem(ctx, item2.ID, 0)
+	s.DeleteCollection(ctx, col.ID, 0)
 }
 
 func TestDeleteCollection(t *testing.T) {
-	initDBForTest(t)
-	defer db.DB.Close()
-	col, err := store.CreateCollection(db.DB, "to delete", "")
+	ctx := context.Background()
+	s := initDBForTest(t)
+	col, err := s.CreateCollection(ctx, "to delete", "")
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
-	if err := store.DeleteCollection(db.DB, col.ID); err != nil {
+	if err := s.DeleteCollection(ctx, col.ID, 0); err != nil {
 		t.Fatalf("err: %v", err)
 	}
 	// attempt get
-	if _, err := store.GetCollection(db.DB, col.ID); err == nil {
+	if _, err := s.GetCollection(ctx, col.ID); err == nil {
 		t.Fatalf("expected error retrieving deleted collection")
 	}
 }
 
 func TestRouterCollectionsEndpoints(t *testing.T) {
-	initDBForTest(t)
-	defer db.DB.Close()
+	s := initDBForTest(t)
 	// create collection via HTTP handler with valid body
 	payload := []byte(`{"name":"router test","description":"test"}`)
 	w := httptest.NewRecorder()
 	req := httptest.NewRequest("POST", "/collections", bytes.NewBuffer(payload))
-	CreateCollectionHandler(w, req)
+	New(s, search.NewMemory()).CreateCollectionHandler(w, req)
 	if w.Code != http.StatusCreated {
 		t.Fatalf("expected 201, got %d", w.Code)
 	}
 
 }
+
+func TestListCollectionsPagination(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	for i := 0; i < 3; i++ {
+		if _, err := s.CreateCollection(ctx, "paged", ""); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	h := New(s, search.NewMemory())
+
+	w := httptest.NewRecorder()
+	h.ListCollectionHandler(w, httptest.NewRequest("GET", "/collections?limit=2", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var page struct {
+		Data       []CollectionResponse `json:"data"`
+		NextCursor string               `json:"next_cursor"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(page.Data) != 2 || page.NextCursor == "" {
+		t.Fatalf("unexpected first page: %+v", page)
+	}
+	link := w.Header().Get("Link")
+	if !strings.Contains(link, "cursor="+page.NextCursor) || !strings.Contains(link, `rel="next"`) {
+		t.Fatalf("unexpected Link header: %q", link)
+	}
+
+	w = httptest.NewRecorder()
+	h.ListCollectionHandler(w, httptest.NewRequest("GET", "/collections?limit=2&cursor="+page.NextCursor, nil))
+	page.NextCursor = ""
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(page.Data) != 1 || page.NextCursor != "" || w.Header().Get("Link") != "" {
+		t.Fatalf("unexpected last page: %+v", page)
+	}
+
+	for _, query := range []string{"limit=0", "limit=1000", "cursor=bogus", "filter=owner%3Dme", "sort=owner"} {
+		w = httptest.NewRecorder()
+		h.ListCollectionHandler(w, httptest.NewRequest("GET", "/collections?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestConditionalGetCollectionItems(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	col, _ := s.CreateCollection(ctx, "cached", "")
+	item, _ := s.CreateItem(ctx, "member", "")
+	h := New(s, search.NewMemory())
+	r := mux.NewRouter()
+	r.HandleFunc("/collections/{id}", h.GetCollectionHandler).Methods("GET")
+	r.HandleFunc("/collections/{id}/items", h.ListItemsInCollectionHandler).Methods("GET")
+
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	colPath := fmt.Sprintf("/collections/%d", col.ID)
+	w := get(colPath, nil)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == "" || w.Header().Get("Last-Modified") == "" {
+		t.Fatalf("expected 200 with validators, got %d %v", w.Code, w.Header())
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Fatalf("unexpected Cache-Control %q", cc)
+	}
+	if w := get(colPath, http.Header{"If-None-Match": {w.Header().Get("ETag")}}); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatalf("expected empty 304, got %d %q", w.Code, w.Body.String())
+	}
+	if w := get(colPath, http.Header{"If-Modified-Since": {w.Header().Get("Last-Modified")}}); w.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 for If-Modified-Since, got %d", w.Code)
+	}
+
+	itemsPath := colPath + "/items"
+	w = get(itemsPath, nil)
+	listETag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || !strings.HasPrefix(listETag, `W/"`) {
+		t.Fatalf("expected 200 with a weak ETag, got %d %q", w.Code, listETag)
+	}
+	if w := get(itemsPath, http.Header{"If-None-Match": {`"other", ` + listETag}}); w.Code != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", w.Code)
+	}
+	if err := s.AddItemToCollection(ctx, col.ID, item.ID); err != nil {
+		t.Fatalf("AddItemToCollection: %v", err)
+	}
+	if w := get(itemsPath, http.Header{"If-None-Match": {listETag}}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 after a membership change, got %d", w.Code)
+	}
+}
+
+func TestConditionalListCollections(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := store.NewMemoryWithClock(func() time.Time { return now })
+	col, _ := s.CreateCollection(ctx, "listed", "")
+	h := New(s, search.NewMemory())
+	r := mux.NewRouter()
+	r.HandleFunc("/collections", h.ListCollectionHandler).Methods("GET")
+
+	// list sends If-Modified-Since and expects the given status and
+	// Last-Modified.
+	list := func(since time.Time, status int, modified time.Time) {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/collections", nil)
+		req.Header.Set("If-Modified-Since", since.Format(http.TimeFormat))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != status || w.Header().Get("Last-Modified") != modified.Format(http.TimeFormat) {
+			t.Fatalf("since %v: got %d, Last-Modified %q; want %d, %v", since, w.Code, w.Header().Get("Last-Modified"), status, modified)
+		}
+	}
+	created := now
+	list(created, http.StatusNotModified, created)
+	list(created.Add(-time.Second), http.StatusOK, created)
+
+	now = now.Add(time.Minute)
+	if err := s.DeleteCollection(ctx, col.ID, 0); err != nil {
+		t.Fatalf("DeleteCollection: %v", err)
+	}
+	list(created, http.StatusOK, now)
+	deleted := now
+
+	now = now.Add(time.Minute)
+	if _, err := s.RestoreCollection(ctx, col.ID, 0); err != nil {
+		t.Fatalf("RestoreCollection: %v", err)
+	}
+	list(deleted, http.StatusOK, now)
+}
+
+func TestOrderedCollectionItems(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	col, _ := s.CreateCollection(ctx, "playlist", "")
+	first, _ := s.CreateItem(ctx, "first", "")
+	second, _ := s.CreateItem(ctx, "second", "")
+	h := New(s, search.NewMemory())
+	r := mux.NewRouter()
+	r.HandleFunc("/collections/{id}/items", h.AddItemToCollectionHandler).Methods("POST")
+	r.HandleFunc("/collections/{id}/items", h.ListItemsInCollectionHandler).Methods("GET")
+	r.HandleFunc("/collections/{id}/items/{item_id}/move", h.MoveItemInCollectionHandler).Methods("POST")
+	itemsPath := fmt.Sprintf("/collections/%d/items", col.ID)
+
+	do := func(method, path, body string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		return w.Code
+	}
+	order := func() []int64 {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", itemsPath, nil))
+		var page struct {
+			Data []ItemResponse `json:"data"`
+		}
+		json.NewDecoder(w.Body).Decode(&page)
+		var ids []int64
+		for _, item := range page.Data {
+			ids = append(ids, item.ID)
+		}
+		return ids
+	}
+
+	if code := do("POST", itemsPath, fmt.Sprintf(`{"item_id":%d}`, first.ID)); code != http.StatusCreated {
+		t.Fatalf("append: expected 201, got %d", code)
+	}
+	if code := do("POST", itemsPath, fmt.Sprintf(`{"item_id":%d,"position":0}`, second.ID)); code != http.StatusCreated {
+		t.Fatalf("insert at position: expected 201, got %d", code)
+	}
+	if got := order(); len(got) != 2 || got[0] != second.ID || got[1] != first.ID {
+		t.Fatalf("order after insert = %v", got)
+	}
+	movePath := fmt.Sprintf("%s/%d/move", itemsPath, second.ID)
+	if code := do("POST", movePath, fmt.Sprintf(`{"after":%d}`, first.ID)); code != http.StatusNoContent {
+		t.Fatalf("move: expected 204, got %d", code)
+	}
+	if got := order(); len(got) != 2 || got[0] != first.ID || got[1] != second.ID {
+		t.Fatalf("order after move = %v", got)
+	}
+	if code := do("POST", movePath, `{}`); code != http.StatusBadRequest {
+		t.Fatalf("move without placement: expected 400, got %d", code)
+	}
+	if code := do("POST", movePath, fmt.Sprintf(`{"position":0,"before":%d}`, first.ID)); code != http.StatusBadRequest {
+		t.Fatalf("move with two placements: expected 400, got %d", code)
+	}
+}
+
+func TestNestedCollections(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	root, _ := s.CreateCollection(ctx, "root", "")
+	child, _ := s.CreateCollection(ctx, "child", "")
+	item, _ := s.CreateItem(ctx, "nested", "")
+	s.AddItemToCollection(ctx, child.ID, item.ID)
+	h := New(s, search.NewMemory())
+	r := mux.NewRouter()
+	r.HandleFunc("/collections/{id}", h.DeleteCollectionHandler).Methods("DELETE")
+	r.HandleFunc("/collections/{id}/move", h.MoveCollectionHandler).Methods("POST")
+	r.HandleFunc("/collections/{id}/children", h.ListChildCollectionsHandler).Methods("GET")
+	r.HandleFunc("/collections/{id}/tree", h.CollectionTreeHandler).Methods("GET")
+	r.HandleFunc("/collections/{id}/items", h.ListItemsInCollectionHandler).Methods("GET")
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		return w
+	}
+	rootPath := fmt.Sprintf("/collections/%d", root.ID)
+	childPath := fmt.Sprintf("/collections/%d", child.ID)
+
+	w := do("POST", childPath+"/move", fmt.Sprintf(`{"parent_id":%d}`, root.ID))
+	var moved CollectionResponse
+	json.NewDecoder(w.Body).Decode(&moved)
+	if w.Code != http.StatusOK || moved.ParentID == nil || *moved.ParentID != root.ID {
+		t.Fatalf("move: got %d %+v", w.Code, moved)
+	}
+	if w := do("POST", rootPath+"/move", fmt.Sprintf(`{"parent_id":%d}`, child.ID)); w.Code != http.StatusConflict {
+		t.Fatalf("cyclic move: expected 409, got %d", w.Code)
+	}
+	if w := do("POST", rootPath+"/move", `{}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("move without parent_id: expected 400, got %d", w.Code)
+	}
+
+	w = do("GET", rootPath+"/children", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"name":"child"`) {
+		t.Fatalf("children: got %d %s", w.Code, w.Body)
+	}
+	w = do("GET", rootPath+"/tree", "")
+	var tree CollectionTreeResponse
+	json.NewDecoder(w.Body).Decode(&tree)
+	if w.Code != http.StatusOK || tree.ID != root.ID || len(tree.Children) != 1 || tree.Children[0].ID != child.ID || len(tree.Children[0].Children) != 0 {
+		t.Fatalf("tree: got %d %+v", w.Code, tree)
+	}
+
+	if w := do("GET", rootPath+"/items", ""); !strings.Contains(w.Body.String(), `"data":[]`) {
+		t.Fatalf("non-recursive items: got %s", w.Body)
+	}
+	w = do("GET", rootPath+"/items?recursive=true", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"name":"nested"`) || w.Header().Get("Last-Modified") != "" {
+		t.Fatalf("recursive items: got %d %v %s", w.Code, w.Header(), w.Body)
+	}
+
+	if w := do("DELETE", rootPath, ""); w.Code != http.StatusConflict {
+		t.Fatalf("deleting a parent: expected 409, got %d", w.Code)
+	}
+	if w := do("DELETE", rootPath+"?recursive=true", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("recursive delete: expected 204, got %d", w.Code)
+	}
+	if _, err := s.GetCollection(ctx, child.ID); err == nil {
+		t.Fatalf("recursive delete kept the child")
+	}
+}
+
+func TestSmartCollections(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	match, _ := s.CreateItem(ctx, "match", "")
+	s.AddItemTags(ctx, match.ID, []string{"urgent"}, 0)
+	s.CreateItem(ctx, "other", "")
+	h := New(s, search.NewMemory())
+	r := mux.NewRouter()
+	r.HandleFunc("/collections", h.CreateCollectionHandler).Methods("POST")
+	r.HandleFunc("/collections/{id}", h.UpdateCollectionHandler).Methods("PUT")
+	r.HandleFunc("/collections/{id}", h.PatchCollectionHandler).Methods("PATCH")
+	r.HandleFunc("/collections/{id}/items", h.AddItemToCollectionHandler).Methods("POST")
+	r.HandleFunc("/collections/{id}/items", h.ListItemsInCollectionHandler).Methods("GET")
+	r.HandleFunc("/items/{id}", h.PatchItemHandler).Methods("PATCH")
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if method == "PATCH" {
+			req.Header.Set("Content-Type", "application/merge-patch+json")
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "/collections", `{"name":"urgent","rule":"tag=urgent and created_at>=now-7d"}`)
+	var col CollectionResponse
+	json.NewDecoder(w.Body).Decode(&col)
+	if w.Code != http.StatusCreated || col.Kind != "smart" || col.Rule != "tag=urgent and created_at>=now-7d" {
+		t.Fatalf("creating a smart collection: got %d %+v", w.Code, col)
+	}
+	path := fmt.Sprintf("/collections/%d", col.ID)
+	w = do("GET", path+"/items", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"name":"match"`) || strings.Contains(w.Body.String(), `"name":"other"`) {
+		t.Fatalf("smart items: got %d %s", w.Code, w.Body)
+	}
+	if w.Header().Get("Last-Modified") != "" {
+		t.Fatalf("smart items sent Last-Modified %q", w.Header().Get("Last-Modified"))
+	}
+	if w := do("POST", path+"/items", fmt.Sprintf(`{"item_id":%d}`, match.ID)); w.Code != http.StatusConflict {
+		t.Fatalf("adding to a smart collection: expected 409, got %d", w.Code)
+	}
+	if w := do("GET", path+"/items?recursive=true", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("recursive smart items: expected 400, got %d", w.Code)
+	}
+	if w := do("POST", "/collections", `{"name":"bad","rule":"color=red"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid rule: expected 400, got %d", w.Code)
+	}
+
+	// A PUT without a rule keeps it; PATCH with null removes it.
+	w = do("PUT", path, `{"name":"renamed"}`)
+	json.NewDecoder(w.Body).Decode(&col)
+	if w.Code != http.StatusOK || col.Kind != "smart" {
+		t.Fatalf("PUT without a rule: got %d %+v", w.Code, col)
+	}
+	w = do("PATCH", path, `{"rule":null}`)
+	col = CollectionResponse{}
+	json.NewDecoder(w.Body).Decode(&col)
+	if w.Code != http.StatusOK || col.Kind != "manual" || col.Rule != "" {
+		t.Fatalf("removing the rule: got %d %+v", w.Code, col)
+	}
+	w = do("PUT", path, `{"name":"urgent","rule":"tag=urgent"}`)
+	json.NewDecoder(w.Body).Decode(&col)
+	if w.Code != http.StatusOK || col.Kind != "smart" || col.Rule != "tag=urgent" {
+		t.Fatalf("PUT with a rule: got %d %+v", w.Code, col)
+	}
+	if w := do("PATCH", fmt.Sprintf("/items/%d", match.ID), `{"rule":"tag=urgent"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("patching an item's rule: expected 400, got %d", w.Code)
+	}
+}
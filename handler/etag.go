@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etag formats a resource version as a strong entity tag.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// setETag sets the ETag header of a response carrying a resource.
+func setETag(w http.ResponseWriter, version int64) {
+	w.Header().Set("ETag", etag(version))
+}
+
+// ifMatch returns the version required by the If-Match header, or 0 when the
+// header is absent or "*". Only a single strong entity tag is accepted; a
+// weak tag never matches, as If-Match uses strong comparison.
+func ifMatch(r *http.Request) (int64, error) {
+	v := strings.TrimSpace(r.Header.Get("If-Match"))
+	if v == "" || v == "*" {
+		return 0, nil
+	}
+	if strings.HasPrefix(v, "W/") {
+		return 0, newError(CodePreconditionFailed, "weak entity tag %s does not match", v)
+	}
+	version, err := strconv.ParseInt(strings.Trim(v, `"`), 10, 64)
+	if err != nil || version <= 0 || len(v) < 2 || v[0] != '"' || v[len(v)-1] != '"' {
+		return 0, newError(CodeInvalidParameter, "invalid If-Match header %q", v)
+	}
+	return version, nil
+}
@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+
+	"github.com/mmontes11/opencode-test/store"
+)
+
+// FieldDefinition declares a custom field in a collection's schema. Type is
+// one of string, number, bool, date, enum or url; Values lists the allowed
+// values of an enum field.
+type FieldDefinition struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Required bool     `json:"required"`
+	Values   []string `json:"values,omitempty"`
+}
+
+// SchemaRequest is the payload of PUT /collections/{id}/schema.
+// Example: {"fields": [{"name": "priority", "type": "number", "required": true}]}
+type SchemaRequest struct {
+	Fields []FieldDefinition `json:"fields"`
+}
+
+func newFieldDefinitions(schema []store.FieldDef) []FieldDefinition {
+	out := make([]FieldDefinition, len(schema))
+	for i, def := range schema {
+		out[i] = FieldDefinition{Name: def.Name, Type: string(def.Type), Required: def.Required, Values: def.Values}
+	}
+	return out
+}
+
+// decodeFields reads a JSON object of custom field values.
+func decodeFields(r *http.Request) (map[string]any, error) {
+	var fields map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil || fields == nil {
+		return nil, newError(CodeInvalidBody, "fields must be a JSON object")
+	}
+	return fields, nil
+}
+
+// ReplaceItemFieldsHandler handles PUT /items/{id}/fields. The body is the
+// complete set of custom fields of the item.
+func (h *Handler) ReplaceItemFieldsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	version, err := ifMatch(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	fields, err := decodeFields(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	item, err := h.store.ReplaceItemFields(r.Context(), id, fields, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	setETag(w, item.Version)
+	writeJSON(w, http.StatusOK, newItemResponse(item))
+}
+
+// PatchItemFieldsHandler handles PATCH /items/{id}/fields. The body is a
+// merge patch of the custom fields: null removes a field and fields that are
+// not mentioned are kept.
+func (h *Handler) PatchItemFieldsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Accept-Patch", mergePatchType)
+	version, err := ifMatch(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != mergePatchType {
+		writeError(w, r, newError(CodeUnsupportedMediaType, "PATCH requires %s", mergePatchType))
+		return
+	}
+	fields, err := decodeFields(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	item, err := h.store.PatchItemFields(r.Context(), id, fields, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	setETag(w, item.Version)
+	writeJSON(w, http.StatusOK, newItemResponse(item))
+}
+
+// SetCollectionSchemaHandler handles PUT /collections/{id}/schema. The
+// schema is refused with 409 Conflict if a member of the collection does
+// not match it.
+func (h *Handler) SetCollectionSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	version, err := ifMatch(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var req SchemaRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	schema := make([]store.FieldDef, len(req.Fields))
+	for i, def := range req.Fields {
+		schema[i] = store.FieldDef{Name: def.Name, Type: store.FieldType(def.Type), Required: def.Required, Values: def.Values}
+	}
+	col, err := h.store.SetCollectionSchema(r.Context(), id, schema, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	setETag(w, col.Version)
+	writeJSON(w, http.StatusOK, newCollectionResponse(col))
+}
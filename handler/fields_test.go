@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mmontes11/opencode-test/search"
+)
+
+func TestCustomFields(t *testing.T) {
+	ctx := context.Background()
+	s := initDBForTest(t)
+	item, err := s.CreateItem(ctx, "typed", "")
+	if err != nil {
+		t.Fatalf("CreateItem: %v", err)
+	}
+	t.Cleanup(func() { s.DeleteItem(ctx, item.ID, 0) })
+	col, err := s.CreateCollection(ctx, "typed", "")
+	if err != nil {
+		t.Fatalf("CreateCollection: %v", err)
+	}
+	t.Cleanup(func() { s.DeleteCollection(ctx, col.ID, 0) })
+	h := New(s, search.NewMemory())
+	r := mux.NewRouter()
+	r.HandleFunc("/items", h.ListItemHandler).Methods("GET")
+	r.HandleFunc("/items/{id}/fields", h.ReplaceItemFieldsHandler).Methods("PUT")
+	r.HandleFunc("/items/{id}/fields", h.PatchItemFieldsHandler).Methods("PATCH")
+	r.HandleFunc("/collections", h.ListCollectionHandler).Methods("GET")
+	r.HandleFunc("/collections/{id}/schema", h.SetCollectionSchemaHandler).Methods("PUT")
+	r.HandleFunc("/collections/{id}/items", h.AddItemToCollectionHandler).Methods("POST")
+
+	do := func(method, path, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	// The field name is unique to this run, since the store may be shared.
+	name := fmt.Sprintf("h%d", item.ID)
+	fields := fmt.Sprintf("/items/%d/fields", item.ID)
+
+	w := do("PUT", fields, fmt.Sprintf(`{"%s": 3, "done": true}`, name), "If-Match", etag(item.Version))
+	var got ItemResponse
+	json.NewDecoder(w.Body).Decode(&got)
+	if want := map[string]any{name: 3.0, "done": true}; w.Code != http.StatusOK || !reflect.DeepEqual(got.Fields, want) || w.Header().Get("ETag") != etag(item.Version+1) {
+		t.Fatalf("replacing fields: got %d %+v, ETag %s", w.Code, got, w.Header().Get("ETag"))
+	}
+	if w := do("PATCH", fields, `{"done": null}`, "Content-Type", "application/json"); w.Code != http.StatusUnsupportedMediaType || w.Header().Get("Accept-Patch") != mergePatchType {
+		t.Fatalf("patching fields as application/json: got %d, Accept-Patch %q", w.Code, w.Header().Get("Accept-Patch"))
+	}
+	w = do("PATCH", fields, `{"done": null, "status": "open"}`, "Content-Type", mergePatchType)
+	got = ItemResponse{}
+	json.NewDecoder(w.Body).Decode(&got)
+	if want := map[string]any{name: 3.0, "status": "open"}; w.Code != http.StatusOK || !reflect.DeepEqual(got.Fields, want) {
+		t.Fatalf("patching fields: got %d %+v", w.Code, got)
+	}
+	for _, body := range []string{`[1]`, `null`, `{"Bad": 1}`, `{"x": {"y": 1}}`} {
+		if w := do("PUT", fields, body); w.Code != http.StatusBadRequest {
+			t.Fatalf("replacing fields with %s: got %d, want 400", body, w.Code)
+		}
+	}
+
+	schema := fmt.Sprintf("/collections/%d/schema", col.ID)
+	w = do("PUT", schema, fmt.Sprintf(`{"fields": [{"name": "%s", "type": "number", "required": true}, {"name": "status", "type": "enum", "values": ["open", "done"]}]}`, name))
+	var gotCol CollectionResponse
+	json.NewDecoder(w.Body).Decode(&gotCol)
+	wantSchema := []FieldDefinition{{Name: name, Type: "number", Required: true}, {Name: "status", Type: "enum", Values: []string{"open", "done"}}}
+	if w.Code != http.StatusOK || !reflect.DeepEqual(gotCol.Schema, wantSchema) {
+		t.Fatalf("setting the schema: got %d %+v", w.Code, gotCol)
+	}
+	if w := do("PUT", schema, `{"fields": [{"name": "x", "type": "color"}]}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("setting an invalid schema: got %d, want 400", w.Code)
+	}
+	if w := do("POST", fmt.Sprintf("/collections/%d/items", col.ID), fmt.Sprintf(`{"item_id": %d}`, item.ID)); w.Code != http.StatusCreated {
+		t.Fatalf("adding a matching item: got %d %s", w.Code, w.Body)
+	}
+	if w := do("PATCH", fields, `{"status": "closed"}`, "Content-Type", mergePatchType); w.Code != http.StatusBadRequest {
+		t.Fatalf("patching a field against the schema: got %d, want 400", w.Code)
+	}
+	if w := do("PUT", schema, fmt.Sprintf(`{"fields": [{"name": "%s", "type": "string"}]}`, name)); w.Code != http.StatusConflict {
+		t.Fatalf("setting a schema that a member does not match: got %d, want 409", w.Code)
+	}
+
+	var page struct {
+		Data []ItemResponse `json:"data"`
+	}
+	w = do("GET", fmt.Sprintf("/items?filter=fields.%s>=3&sort=-fields.%s", name, name), "")
+	json.NewDecoder(w.Body).Decode(&page)
+	if w.Code != http.StatusOK || len(page.Data) != 1 || page.Data[0].ID != item.ID {
+		t.Fatalf("filtering by a custom field: got %d %+v", w.Code, page)
+	}
+	if w := do("GET", "/collections?filter=fields.x=1", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("filtering collections by a custom field: got %d, want 400", w.Code)
+	}
+}
@@ -3,8 +3,41 @@ package handler
 import (
     "encoding/json"
     "net/http"
+    "time"
+
+    "github.com/mmontes11/opencode-test/search"
+    "github.com/mmontes11/opencode-test/store"
 )
 
+// Handler serves the item, collection and search endpoints using the
+// injected Store and search Index.
+type Handler struct {
+    store          store.Store
+    search         search.Index
+    idempotencyTTL time.Duration
+}
+
+// Options configures a Handler.
+type Options struct {
+    // IdempotencyTTL is how long the response to a request with an
+    // Idempotency-Key is kept for replay.
+    IdempotencyTTL time.Duration
+}
+
+// DefaultOptions are the Options used by New.
+var DefaultOptions = Options{IdempotencyTTL: 24 * time.Hour}
+
+// New returns a Handler backed by the given Store and search Index.
+func New(s store.Store, idx search.Index) *Handler {
+    return NewWithOptions(s, idx, DefaultOptions)
+}
+
+// NewWithOptions returns a Handler backed by the given Store and search
+// Index and configured by opts.
+func NewWithOptions(s store.Store, idx search.Index, opts Options) *Handler {
+    return &Handler{store: s, search: idx, idempotencyTTL: opts.IdempotencyTTL}
+}
+
 // HealthCheck returns a simple JSON response to indicate the service is running.
 func HealthCheck(w http.ResponseWriter, r *http.Request) {
     w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+)
+
+// maxIdempotencyKeyLen is the longest Idempotency-Key the store can hold.
+const maxIdempotencyKeyLen = 255
+
+// Idempotent is middleware that makes POST requests carrying an
+// Idempotency-Key header safe to retry. The first request with a key runs
+// normally and its response is stored; later requests with the same key and
+// body get the stored response replayed with an Idempotent-Replayed header,
+// and requests that reuse the key for a different body are rejected.
+// Server errors are not stored, so that a retry can succeed. Other methods
+// and requests without the header pass through.
+func (h *Handler) Idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if r.Method != http.MethodPost || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			writeProblem(w, r, CodeInvalidParameter, "Idempotency-Key must be at most 255 characters")
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeProblem(w, r, CodeInvalidBody, err.Error())
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		fp := fingerprint(r, body)
+		rec, err := h.store.ReserveIdempotencyKey(r.Context(), key, fp, h.idempotencyTTL)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if rec != nil {
+			switch {
+			case rec.Fingerprint != fp:
+				writeProblem(w, r, CodeIdempotencyKeyReused, "Idempotency-Key was already used for a different request")
+			case rec.StatusCode == 0:
+				writeProblem(w, r, CodeConflict, "a request with this Idempotency-Key is still in progress")
+			default:
+				for k, v := range rec.Header {
+					w.Header()[k] = v
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(rec.StatusCode)
+				w.Write(rec.Body)
+			}
+			return
+		}
+
+		cw := &captureWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(cw, r)
+		// The response has been sent; record it even if the client is gone.
+		ctx := context.WithoutCancel(r.Context())
+		if cw.status >= 500 {
+			err = h.store.ReleaseIdempotencyKey(ctx, key)
+		} else {
+			err = h.store.CompleteIdempotencyKey(ctx, key, cw.status, cw.header, cw.body.Bytes())
+		}
+		if err != nil {
+			log.Printf("%s %s: storing Idempotency-Key %q: %v", r.Method, r.URL.Path, key, err)
+		}
+	})
+}
+
+// fingerprint identifies a request by its method, path, query and body, so
+// that a key reused for another request can be told apart from a retry.
+func fingerprint(r *http.Request, body []byte) string {
+	sum := sha256.New()
+	io.WriteString(sum, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery+"\n")
+	sum.Write(body)
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// captureWriter passes a response through while keeping a copy of its
+// status, headers and body.
+type captureWriter struct {
+	http.ResponseWriter
+	status      int
+	header      http.Header
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (w *captureWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.status = status
+		w.header = w.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mmontes11/opencode-test/search"
+)
+
+func TestIdempotentCreate(t *testing.T) {
+	s := initDBForTest(t)
+	h := New(s, search.NewMemory())
+	r := mux.NewRouter()
+	r.Use(h.Idempotent)
+	r.HandleFunc("/items", h.CreateItemHandler).Methods("POST")
+	key := fmt.Sprintf("test-%d", time.Now().UnixNano())
+
+	post := func(body string, query ...string) *httptest.ResponseRecorder {
+		path := "/items"
+		if len(query) > 0 {
+			path += "?" + query[0]
+		}
+		req := httptest.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	first := post(`{"name":"once","description":"idempotent"}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first POST: expected 201, got %d", first.Code)
+	}
+	var item ItemResponse
+	if err := json.Unmarshal(first.Body.Bytes(), &item); err != nil {
+		t.Fatalf("failed to decode item: %v", err)
+	}
+	t.Cleanup(func() { s.DeleteItem(t.Context(), item.ID, 0) })
+
+	replay := post(`{"name":"once","description":"idempotent"}`)
+	if replay.Code != http.StatusCreated || replay.Body.String() != first.Body.String() {
+		t.Fatalf("replay: got %d %s, want %d %s", replay.Code, replay.Body, first.Code, first.Body)
+	}
+	if replay.Header().Get("Idempotent-Replayed") != "true" || replay.Header().Get("ETag") != first.Header().Get("ETag") {
+		t.Fatalf("replay headers: %v", replay.Header())
+	}
+
+	reused := post(`{"name":"twice"}`)
+	if reused.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("reused key: expected 422, got %d", reused.Code)
+	}
+	var p Problem
+	if err := json.Unmarshal(reused.Body.Bytes(), &p); err != nil || p.Code != CodeIdempotencyKeyReused {
+		t.Fatalf("reused key: got problem %+v (%v)", p, err)
+	}
+
+	if reused := post(`{"name":"once","description":"idempotent"}`, "include=collections"); reused.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("reused key with another query: expected 422, got %d", reused.Code)
+	}
+}
@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// includeCollections reports whether the request asks for
+// ?include=collections. include is a comma-separated list; unknown values
+// are rejected.
+func includeCollections(r *http.Request) (bool, error) {
+	include := r.URL.Query().Get("include")
+	if include == "" {
+		return false, nil
+	}
+	for _, v := range strings.Split(include, ",") {
+		if strings.TrimSpace(v) != "collections" {
+			return false, newError(CodeInvalidParameter, "invalid include %q: only collections is supported", v)
+		}
+	}
+	return true, nil
+}
+
+// embedCollections sets the Collections field of the item responses, using
+// one store query for all of them.
+func (h *Handler) embedCollections(ctx context.Context, items []ItemResponse) error {
+	ids := make([]int64, len(items))
+	for i := range items {
+		ids[i] = items[i].ID
+	}
+	colIDs, err := h.store.ItemCollectionIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		cols := colIDs[items[i].ID]
+		if cols == nil {
+			cols = []int64{}
+		}
+		items[i].Collections = &ItemCollectionsResponse{Count: len(cols), IDs: cols}
+	}
+	return nil
+}
@@ -1,15 +1,11 @@
 package handler
 
 import (
-	"database/sql"
-	"encoding/json"
-	"fmt"
 	"net/http"
-	"strconv"
+	"time"
 
-	"github.com/gorilla/mux"
-	"github.com/mmontes11/opencode-test/db"
 	"github.com/mmontes11/opencode-test/store"
+	"github.com/mmontes11/opencode-test/store/filter"
 )
 
 // ItemRequest represents the expected payload for creating or updating an item.
@@ -20,104 +16,195 @@ type ItemRequest struct {
 }
 
 // CreateItemHandler handles POST /items to create a new item.
-func CreateItemHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) CreateItemHandler(w http.ResponseWriter, r *http.Request) {
 	var req ItemRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, r, err)
 		return
 	}
 	if req.Name == "" {
-		http.Error(w, "name is required", http.StatusBadRequest)
+		writeError(w, r, newError(CodeValidationFailed, "name is required"))
 		return
 	}
 
-	item, err := store.CreateItem(db.DB, req.Name, req.Description)
+	item, err := h.store.CreateItem(r.Context(), req.Name, req.Description)
 	if err != nil {
-		fmt.Printf("store.CreateItem error: %v\n", err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	_ = json.NewEncoder(w).Encode(item)
+	setETag(w, item.Version)
+	writeJSON(w, http.StatusCreated, newItemResponse(item))
 }
 
 // GetItemHandler handles GET /items/{id} to fetch an item.
-func GetItemHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	idStr, ok := vars["id"]
-	if !ok {
-		http.Error(w, "missing id", http.StatusBadRequest)
+func (h *Handler) GetItemHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	id, err := strconv.ParseInt(idStr, 10, 64)
+
+	include, err := includeCollections(r)
 	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
-	item, err := store.GetItem(db.DB, id)
+	item, err := h.store.GetItem(r.Context(), id)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "item not found", http.StatusNotFound)
+		writeError(w, r, err)
+		return
+	}
+	if include {
+		// Memberships are not covered by the item's version, so the
+		// response is validated by its body instead.
+		resp := []ItemResponse{newItemResponse(item)}
+		if err := h.embedCollections(r.Context(), resp); err != nil {
+			writeError(w, r, err)
 			return
 		}
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		writeDerived(w, r, resp[0])
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(item)
+	if notModified(w, r, etag(item.Version), item.UpdatedAt) {
+		return
+	}
+	writeJSON(w, http.StatusOK, newItemResponse(item))
 }
 
 // ListItemHandler handles GET /items.
-func ListItemHandler(w http.ResponseWriter, r *http.Request) {
-	items, err := store.ListItems(db.DB)
+func (h *Handler) ListItemHandler(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r, filter.ItemFields)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	include, err := includeCollections(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// The timestamp is read first, so that a change racing with the listing
+	// can only make Last-Modified older than the page.
+	lastModified, err := h.store.ItemsLastModified(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	items, next, err := h.store.ListItems(r.Context(), opts)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	resp := newItemResponses(items)
+	if include {
+		if err := h.embedCollections(r.Context(), resp); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		// Memberships do not move the items' timestamps.
+		lastModified = time.Time{}
+	}
+	writePage(w, r, resp, next, opts.Limit, lastModified)
+}
+
+// ListCollectionsOfItemHandler handles GET /items/{id}/collections.
+func (h *Handler) ListCollectionsOfItemHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	opts, err := parseListOptions(r, filter.DefaultFields)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	cols, next, err := h.store.ListCollectionsOfItem(r.Context(), id, opts)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(items)
+	writePage(w, r, newCollectionResponses(cols), next, opts.Limit, time.Time{})
 }
 
 // UpdateItemHandler handles PUT /items/{id}.
-func UpdateItemHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.ParseInt(vars["id"], 10, 64)
+func (h *Handler) UpdateItemHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	version, err := ifMatch(r)
 	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
 	var req ItemRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.Name == "" {
+		writeError(w, r, newError(CodeValidationFailed, "name is required"))
 		return
 	}
 
-	item, err := store.UpdateItem(db.DB, id, req.Name, req.Description)
+	item, err := h.store.UpdateItem(r.Context(), id, req.Name, req.Description, version)
 	if err != nil {
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+	setETag(w, item.Version)
+	writeJSON(w, http.StatusOK, newItemResponse(item))
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(item)
+// PatchItemHandler handles PATCH /items/{id} with a JSON Merge Patch or a
+// JSON Patch. Only the fields the patch touches are written.
+func (h *Handler) PatchItemHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Accept-Patch", acceptPatch)
+	var item *store.Item
+	err = applyPatch(r, func() (patchTarget, error) {
+		cur, err := h.store.GetItem(r.Context(), id)
+		if err != nil {
+			return patchTarget{}, err
+		}
+		return patchTarget{Name: cur.Name, Description: cur.Description, Version: cur.Version}, nil
+	}, func(p store.Patch, version int64) error {
+		var err error
+		item, err = h.store.PatchItem(r.Context(), id, p, version)
+		return err
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	setETag(w, item.Version)
+	writeJSON(w, http.StatusOK, newItemResponse(item))
 }
 
-// DeleteItemHandler handles DELETE /items/{id}.
-func DeleteItemHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.ParseInt(vars["id"], 10, 64)
+// DeleteItemHandler handles DELETE /items/{id}. The item is moved to the
+// trash; RestoreItemHandler brings it back.
+func (h *Handler) DeleteItemHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	version, err := ifMatch(r)
 	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
-	if err := store.DeleteItem(db.DB, id); err != nil {
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+	if err := h.store.DeleteItem(r.Context(), id, version); err != nil {
+		writeError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mmontes11/opencode-test/search"
+	"github.com/mmontes11/opencode-test/store"
+)
+
+func TestCreateAndGetItem(t *testing.T) {
+	ctx := context.Background()
+	s := initDBForTest(t)
+	item, err := s.CreateItem(ctx, "test item", "test description")
+	if err != nil {
+		t.Fatalf("CreateItem returned error: %v", err)
+	}
+	got, err := s.GetItem(ctx, item.ID)
+	if err != nil {
+		t.Fatalf("GetItem returned error: %v", err)
+	}
+	if got.Name != "test item" || got.Description != "test description" {
+		t.Fatalf("unexpected item data: %+v", got)
+	}
+	if err := s.DeleteItem(ctx, item.ID, 0); err != nil {
+		t.Fatalf("DeleteItem returned error: %v", err)
+	}
+}
+
+func TestRouterItemsEndpoints(t *testing.T) {
+	s := initDBForTest(t)
+	h := New(s, search.NewMemory())
+	r := mux.NewRouter()
+	r.HandleFunc("/items", h.CreateItemHandler).Methods("POST")
+	r.HandleFunc("/items/{id}", h.GetItemHandler).Methods("GET")
+	r.HandleFunc("/items/{id}", h.UpdateItemHandler).Methods("PUT")
+	r.HandleFunc("/items/{id}", h.DeleteItemHandler).Methods("DELETE")
+
+	// create
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/items", bytes.NewBufferString(`{"name":"router item","description":"test"}`))
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+	var item ItemResponse
+	if err := json.NewDecoder(w.Body).Decode(&item); err != nil {
+		t.Fatalf("failed to decode item: %v", err)
+	}
+	path := fmt.Sprintf("/items/%d", item.ID)
+
+	// update without name
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("PUT", path, bytes.NewBufferString(`{"description":"no name"}`))
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+
+	// update
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("PUT", path, bytes.NewBufferString(`{"name":"renamed","description":"updated"}`))
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	// delete
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("DELETE", path, nil)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+
+	// update and delete of a missing item
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("PUT", path, bytes.NewBufferString(`{"name":"gone"}`))
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("DELETE", path, nil)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", path, nil)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+}
+
+func TestItemIfMatch(t *testing.T) {
+	s := initDBForTest(t)
+	h := New(s, search.NewMemory())
+	r := mux.NewRouter()
+	r.HandleFunc("/items", h.CreateItemHandler).Methods("POST")
+	r.HandleFunc("/items/{id}", h.UpdateItemHandler).Methods("PUT")
+	r.HandleFunc("/items/{id}", h.DeleteItemHandler).Methods("DELETE")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/items", bytes.NewBufferString(`{"name":"etag item"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+	created := w.Header().Get("ETag")
+	var item ItemResponse
+	if err := json.NewDecoder(w.Body).Decode(&item); err != nil {
+		t.Fatalf("failed to decode item: %v", err)
+	}
+	path := fmt.Sprintf("/items/%d", item.ID)
+
+	send := func(method, body, match string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("If-Match", match)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w = send("PUT", `{"name":"first"}`, created)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	updated := w.Header().Get("ETag")
+	if updated == "" || updated == created {
+		t.Fatalf("expected a new ETag, got %q after %q", updated, created)
+	}
+
+	// A second editor still holding the original ETag must not win.
+	if w := send("PUT", `{"name":"second"}`, created); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for stale PUT, got %d", w.Code)
+	}
+	if w := send("DELETE", "", created); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for stale DELETE, got %d", w.Code)
+	}
+	if w := send("DELETE", "", "W/"+updated); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for weak ETag, got %d", w.Code)
+	}
+	if w := send("DELETE", "", "garbage"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for malformed If-Match, got %d", w.Code)
+	}
+	if w := send("DELETE", "", updated); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+}
+
+func TestItemCollections(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	item, _ := s.CreateItem(ctx, "member", "")
+	first, _ := s.CreateCollection(ctx, "first", "")
+	second, _ := s.CreateCollection(ctx, "second", "")
+	s.AddItemToCollection(ctx, second.ID, item.ID)
+	s.AddItemToCollection(ctx, first.ID, item.ID)
+	h := New(s, search.NewMemory())
+	r := mux.NewRouter()
+	r.HandleFunc("/items", h.ListItemHandler).Methods("GET")
+	r.HandleFunc("/items/{id}", h.GetItemHandler).Methods("GET")
+	r.HandleFunc("/items/{id}/collections", h.ListCollectionsOfItemHandler).Methods("GET")
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	path := fmt.Sprintf("/items/%d", item.ID)
+
+	w := get(path + "/collections")
+	var page struct {
+		Data []CollectionResponse `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("expected 200 with a page, got %d (%v)", w.Code, err)
+	}
+	if len(page.Data) != 2 || page.Data[0].ID != first.ID || page.Data[1].ID != second.ID {
+		t.Fatalf("unexpected collections %+v", page.Data)
+	}
+	if w := get("/items/999999/collections"); w.Code != http.StatusNotFound {
+		t.Fatalf("missing item: expected 404, got %d", w.Code)
+	}
+
+	if w := get(path); strings.Contains(w.Body.String(), `"collections"`) {
+		t.Fatalf("collections embedded without include: %s", w.Body)
+	}
+	w = get(path + "?include=collections")
+	var got ItemResponse
+	json.NewDecoder(w.Body).Decode(&got)
+	if got.Collections == nil || got.Collections.Count != 2 || got.Collections.IDs[0] != first.ID || got.Collections.IDs[1] != second.ID {
+		t.Fatalf("unexpected embedded collections %+v", got.Collections)
+	}
+	if tag := w.Header().Get("ETag"); !strings.HasPrefix(tag, `W/"`) {
+		t.Fatalf("expected a weak ETag with include, got %q", tag)
+	}
+	w = get("/items?include=collections")
+	var list struct {
+		Data []ItemResponse `json:"data"`
+	}
+	json.NewDecoder(w.Body).Decode(&list)
+	if len(list.Data) != 1 || list.Data[0].Collections == nil || list.Data[0].Collections.Count != 2 {
+		t.Fatalf("unexpected list with include %+v", list.Data)
+	}
+	if w := get(path + "?include=tags"); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown include: expected 400, got %d", w.Code)
+	}
+}
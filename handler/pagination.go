@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mmontes11/opencode-test/store"
+	"github.com/mmontes11/opencode-test/store/filter"
+)
+
+const (
+	// DefaultPageSize is used when a list request has no limit parameter.
+	DefaultPageSize = 50
+	// MaxPageSize is the largest limit a client may request.
+	MaxPageSize = 200
+)
+
+// Page is the response body of list endpoints.
+// NextCursor is omitted on the last page.
+type Page struct {
+	Data       any    `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// parseListOptions reads the limit, cursor, filter, sort, tags_all and
+// tags_any query parameters. fields are the fields that may be filtered and
+// sorted by.
+func parseListOptions(r *http.Request, fields filter.Fields) (store.ListOptions, error) {
+	q := r.URL.Query()
+	opts := store.ListOptions{Limit: DefaultPageSize, Cursor: q.Get("cursor")}
+	var err error
+	if opts.Filter, err = filter.Parse(q.Get("filter"), fields); err != nil {
+		return opts, newError(CodeInvalidParameter, "invalid filter: %v", err)
+	}
+	if opts.Sort, err = filter.ParseSort(q.Get("sort"), fields); err != nil {
+		return opts, newError(CodeInvalidParameter, "invalid sort: %v", err)
+	}
+	if opts.TagsAll, err = queryTags(r, "tags_all"); err != nil {
+		return opts, err
+	}
+	if opts.TagsAny, err = queryTags(r, "tags_any"); err != nil {
+		return opts, err
+	}
+	if s := q.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 {
+			return opts, newError(CodeInvalidParameter, "limit must be a positive integer")
+		}
+		if limit > MaxPageSize {
+			return opts, newError(CodeInvalidParameter, "limit must not exceed %d", MaxPageSize)
+		}
+		opts.Limit = limit
+	}
+	return opts, nil
+}
+
+// writePage encodes a page of results. When there is a next page, its URL is
+// also advertised in a Link header with rel="next". The page is validated by
+// a weak ETag derived from its body and, if lastModified is non-zero, by
+// Last-Modified; a matching conditional request gets 304 Not Modified.
+func writePage(w http.ResponseWriter, r *http.Request, data any, next string, limit int, lastModified time.Time) {
+	body, err := json.Marshal(Page{Data: data, NextCursor: next})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if notModified(w, r, weakETag(body), lastModified) {
+		return
+	}
+	if next != "" {
+		u := *r.URL
+		q := u.Query()
+		q.Set("cursor", next)
+		q.Set("limit", strconv.Itoa(limit))
+		u.RawQuery = q.Encode()
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", u.RequestURI()))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(body, '\n'))
+}
@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/mmontes11/opencode-test/store"
+)
+
+// Media types accepted by PATCH endpoints.
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// acceptPatch is advertised in the Accept-Patch header when a PATCH request
+// uses an unsupported media type.
+const acceptPatch = mergePatchType + ", " + jsonPatchType
+
+// maxPatchAttempts bounds how often a JSON Patch is reapplied when the
+// resource changes between reading and writing it. Requests with If-Match
+// are never retried.
+const maxPatchAttempts = 3
+
+// patchTarget is the patchable state of an item or collection.
+type patchTarget struct {
+	Name        string
+	Description string
+	Rule        string
+	Version     int64
+}
+
+// patchFields maps the JSON member names of patchable fields to their
+// position in a patchTarget.
+var patchFields = map[string]func(t *patchTarget) *string{
+	"name":        func(t *patchTarget) *string { return &t.Name },
+	"description": func(t *patchTarget) *string { return &t.Description },
+	"rule":        func(t *patchTarget) *string { return &t.Rule },
+}
+
+// applyPatch decodes the body of a PATCH request and writes it through
+// write, guarded by the If-Match version. JSON Patch documents are applied
+// to the state returned by get; merge patches need no current state.
+func applyPatch(r *http.Request, get func() (patchTarget, error), write func(p store.Patch, version int64) error) error {
+	version, err := ifMatch(r)
+	if err != nil {
+		return err
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != mergePatchType && mediaType != jsonPatchType {
+		return newError(CodeUnsupportedMediaType, "PATCH requires %s", acceptPatch)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return newError(CodeInvalidBody, "invalid request body: %v", err)
+	}
+
+	if mediaType == mergePatchType {
+		p, err := parseMergePatch(body)
+		if err != nil {
+			return err
+		}
+		return write(p, version)
+	}
+
+	ops, err := parseJSONPatch(body)
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		cur, err := get()
+		if err != nil {
+			return err
+		}
+		if version != 0 && cur.Version != version {
+			return newError(CodePreconditionFailed, "current version is %d", cur.Version)
+		}
+		p, err := ops.apply(cur)
+		if err != nil {
+			return err
+		}
+		err = write(p, cur.Version)
+		if version != 0 || attempt == maxPatchAttempts || !errors.Is(err, store.ErrPreconditionFailed) {
+			return err
+		}
+	}
+}
+
+// parseMergePatch decodes an RFC 7396 merge patch. null removes the
+// description or rule; the name cannot be removed.
+func parseMergePatch(body []byte) (store.Patch, error) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(body, &members); err != nil || members == nil {
+		return store.Patch{}, newError(CodeInvalidBody, "merge patch must be a JSON object")
+	}
+	var t patchTarget
+	var p store.Patch
+	for name, raw := range members {
+		field, ok := patchFields[name]
+		if !ok {
+			return store.Patch{}, newError(CodeValidationFailed, "unknown field %q", name)
+		}
+		v := field(&t)
+		if !bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+			if err := json.Unmarshal(raw, v); err != nil {
+				return store.Patch{}, newError(CodeValidationFailed, "%s must be a string", name)
+			}
+		}
+		setPatchField(&p, name, v)
+	}
+	if p.Name != nil && *p.Name == "" {
+		return store.Patch{}, newError(CodeValidationFailed, "name is required")
+	}
+	return p, nil
+}
+
+// jsonPatchOp is one operation of an RFC 6902 JSON Patch.
+type jsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+type jsonPatch []jsonPatchOp
+
+// parseJSONPatch decodes a JSON Patch and validates its operations and
+// paths, so that apply only fails on test operations.
+func parseJSONPatch(body []byte) (jsonPatch, error) {
+	var ops jsonPatch
+	if err := json.Unmarshal(body, &ops); err != nil {
+		return nil, newError(CodeInvalidBody, "JSON Patch must be an array of operations: %v", err)
+	}
+	for i, op := range ops {
+		switch op.Op {
+		case "add", "replace", "test":
+			var s string
+			if err := json.Unmarshal(op.Value, &s); err != nil {
+				return nil, newError(CodeValidationFailed, "operation %d: value must be a string", i)
+			}
+		case "remove":
+		case "move", "copy":
+			if _, ok := patchFields[fieldName(op.From)]; !ok {
+				return nil, newError(CodeValidationFailed, "operation %d: unsupported from %q", i, op.From)
+			}
+		default:
+			return nil, newError(CodeValidationFailed, "operation %d: unsupported op %q", i, op.Op)
+		}
+		if _, ok := patchFields[fieldName(op.Path)]; !ok {
+			return nil, newError(CodeValidationFailed, "operation %d: unsupported path %q", i, op.Path)
+		}
+	}
+	return ops, nil
+}
+
+// fieldName returns the member named by a JSON Pointer to a top-level
+// field, or "" for any other pointer.
+func fieldName(pointer string) string {
+	if len(pointer) < 2 || pointer[0] != '/' {
+		return ""
+	}
+	return pointer[1:]
+}
+
+// apply runs the operations against cur and returns a patch of the fields
+// they touched. Removing the description or rule empties it; the name
+// cannot be removed. A failed test operation is reported as a conflict.
+func (ops jsonPatch) apply(cur patchTarget) (store.Patch, error) {
+	var p store.Patch
+	for i, op := range ops {
+		name := fieldName(op.Path)
+		v := patchFields[name](&cur)
+		switch op.Op {
+		case "add", "replace":
+			json.Unmarshal(op.Value, v)
+		case "remove":
+			*v = ""
+		case "move", "copy":
+			from := fieldName(op.From)
+			src := patchFields[from](&cur)
+			*v = *src
+			if op.Op == "move" && from != name {
+				*src = ""
+				setPatchField(&p, from, src)
+			}
+		case "test":
+			var want string
+			json.Unmarshal(op.Value, &want)
+			if *v != want {
+				return store.Patch{}, newError(CodeConflict, "operation %d: test of %s failed", i, op.Path)
+			}
+			continue
+		}
+		setPatchField(&p, name, v)
+	}
+	if p.Name != nil && *p.Name == "" {
+		return store.Patch{}, newError(CodeValidationFailed, "name is required")
+	}
+	return p, nil
+}
+
+func setPatchField(p *store.Patch, name string, v *string) {
+	switch name {
+	case "name":
+		p.Name = v
+	case "description":
+		p.Description = v
+	case "rule":
+		p.Rule = v
+	}
+}
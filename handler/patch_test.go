@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mmontes11/opencode-test/search"
+)
+
+func TestPatchCollection(t *testing.T) {
+	ctx := context.Background()
+	s := initDBForTest(t)
+	col, err := s.CreateCollection(ctx, "patched", "original")
+	if err != nil {
+		t.Fatalf("CreateCollection: %v", err)
+	}
+	t.Cleanup(func() { s.DeleteCollection(ctx, col.ID, 0) })
+	h := New(s, search.NewMemory())
+	r := mux.NewRouter()
+	r.HandleFunc("/collections/{id}", h.PatchCollectionHandler).Methods("PATCH")
+	path := fmt.Sprintf("/collections/%d", col.ID)
+
+	patch := func(contentType, body string) (*httptest.ResponseRecorder, CollectionResponse) {
+		req := httptest.NewRequest("PATCH", path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var got CollectionResponse
+		if w.Code == http.StatusOK {
+			json.NewDecoder(w.Body).Decode(&got)
+		}
+		return w, got
+	}
+
+	w, got := patch(mergePatchType, `{"description":"merged"}`)
+	if w.Code != http.StatusOK || got.Name != "patched" || got.Description != "merged" {
+		t.Fatalf("merge patch: got %d %+v", w.Code, got)
+	}
+	w, got = patch(mergePatchType, `{"description":null}`)
+	if w.Code != http.StatusOK || got.Name != "patched" || got.Description != "" {
+		t.Fatalf("merge patch removing description: got %d %+v", w.Code, got)
+	}
+	w, got = patch(jsonPatchType, `[{"op":"test","path":"/name","value":"patched"},{"op":"copy","from":"/name","path":"/description"},{"op":"replace","path":"/name","value":"renamed"}]`)
+	if w.Code != http.StatusOK || got.Name != "renamed" || got.Description != "patched" {
+		t.Fatalf("JSON patch: got %d %+v", w.Code, got)
+	}
+
+	for _, tc := range []struct {
+		contentType, body string
+		code              int
+	}{
+		{"application/json", `{"name":"x"}`, http.StatusUnsupportedMediaType},
+		{mergePatchType, `["not an object"]`, http.StatusBadRequest},
+		{mergePatchType, `{"name":null}`, http.StatusBadRequest},
+		{mergePatchType, `{"owner":"me"}`, http.StatusBadRequest},
+		{jsonPatchType, `[{"op":"remove","path":"/name"}]`, http.StatusBadRequest},
+		{jsonPatchType, `[{"op":"replace","path":"/id","value":"1"}]`, http.StatusBadRequest},
+		{jsonPatchType, `[{"op":"test","path":"/name","value":"stale"}]`, http.StatusConflict},
+	} {
+		w, _ := patch(tc.contentType, tc.body)
+		if w.Code != tc.code {
+			t.Fatalf("%s %s: expected %d, got %d", tc.contentType, tc.body, tc.code, w.Code)
+		}
+	}
+	if w, _ := patch("text/plain", `x`); w.Header().Get("Accept-Patch") != acceptPatch {
+		t.Fatalf("expected Accept-Patch header, got %q", w.Header().Get("Accept-Patch"))
+	}
+	if got, _ := s.GetCollection(ctx, col.ID); got.Name != "renamed" || got.Description != "patched" {
+		t.Fatalf("rejected patches modified the collection: %+v", got)
+	}
+}
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/mmontes11/opencode-test/store"
+)
+
+// ErrorCode is a stable, machine-readable identifier of an error response.
+// The codes are documented in docs/errors.md.
+type ErrorCode string
+
+const (
+	CodeInvalidBody          ErrorCode = "invalid_body"
+	CodeInvalidParameter     ErrorCode = "invalid_parameter"
+	CodeValidationFailed     ErrorCode = "validation_failed"
+	CodeNotFound             ErrorCode = "not_found"
+	CodeRouteNotFound        ErrorCode = "route_not_found"
+	CodeMethodNotAllowed     ErrorCode = "method_not_allowed"
+	CodeConflict             ErrorCode = "conflict"
+	CodePreconditionFailed   ErrorCode = "precondition_failed"
+	CodeUnsupportedMediaType ErrorCode = "unsupported_media_type"
+	CodeIdempotencyKeyReused ErrorCode = "idempotency_key_reused"
+	CodeInternal             ErrorCode = "internal_error"
+)
+
+// errorTypeBase prefixes error codes to form the problem type URI.
+const errorTypeBase = "https://github.com/mmontes11/opencode-test/blob/main/docs/errors.md#"
+
+// errorCatalog holds the HTTP status and title of every error code.
+var errorCatalog = map[ErrorCode]struct {
+	Status int
+	Title  string
+}{
+	CodeInvalidBody:          {http.StatusBadRequest, "Invalid request body"},
+	CodeInvalidParameter:     {http.StatusBadRequest, "Invalid parameter"},
+	CodeValidationFailed:     {http.StatusBadRequest, "Validation failed"},
+	CodeNotFound:             {http.StatusNotFound, "Resource not found"},
+	CodeRouteNotFound:        {http.StatusNotFound, "Route not found"},
+	CodeMethodNotAllowed:     {http.StatusMethodNotAllowed, "Method not allowed"},
+	CodeConflict:             {http.StatusConflict, "Conflict"},
+	CodePreconditionFailed:   {http.StatusPreconditionFailed, "Precondition failed"},
+	CodeUnsupportedMediaType: {http.StatusUnsupportedMediaType, "Unsupported media type"},
+	CodeIdempotencyKeyReused: {http.StatusUnprocessableEntity, "Idempotency key reused"},
+	CodeInternal:             {http.StatusInternalServerError, "Internal server error"},
+}
+
+// Problem is an RFC 7807 problem details object, extended with a stable
+// error code.
+type Problem struct {
+	Type     string    `json:"type"`
+	Title    string    `json:"title"`
+	Status   int       `json:"status"`
+	Detail   string    `json:"detail,omitempty"`
+	Instance string    `json:"instance,omitempty"`
+	Code     ErrorCode `json:"code"`
+}
+
+// apiError is an error raised by the handlers themselves, such as a
+// malformed ID or request body.
+type apiError struct {
+	code   ErrorCode
+	detail string
+}
+
+func (e *apiError) Error() string { return e.detail }
+
+// newError returns an error that writeError renders with the given code.
+func newError(code ErrorCode, format string, args ...any) error {
+	return &apiError{code: code, detail: fmt.Sprintf(format, args...)}
+}
+
+// writeError maps err to a problem response. Store domain errors keep their
+// message as the detail; any other error is logged and reported as an
+// internal error without details, so driver messages never reach clients.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	code, detail := CodeInternal, ""
+	var apiErr *apiError
+	switch {
+	case errors.As(err, &apiErr):
+		code, detail = apiErr.code, apiErr.detail
+	case errors.Is(err, store.ErrInvalidCursor):
+		code, detail = CodeInvalidParameter, "invalid cursor"
+	case errors.Is(err, store.ErrNotFound):
+		code, detail = CodeNotFound, err.Error()
+	case errors.Is(err, store.ErrConflict):
+		code, detail = CodeConflict, err.Error()
+	case errors.Is(err, store.ErrPreconditionFailed):
+		code, detail = CodePreconditionFailed, err.Error()
+	case errors.Is(err, store.ErrValidation):
+		code, detail = CodeValidationFailed, err.Error()
+	default:
+		fmt.Printf("%s %s error: %v\n", r.Method, r.URL.Path, err)
+	}
+	writeProblem(w, r, code, detail)
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, code ErrorCode, detail string) {
+	entry := errorCatalog[code]
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(entry.Status)
+	_ = json.NewEncoder(w).Encode(Problem{
+		Type:     errorTypeBase + string(code),
+		Title:    entry.Title,
+		Status:   entry.Status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Code:     code,
+	})
+}
+
+// NotFoundHandler answers requests that match no route.
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, CodeRouteNotFound, fmt.Sprintf("no route for %s", r.URL.Path))
+}
+
+// MethodNotAllowedHandler answers requests whose path matches a route but
+// whose method does not.
+func MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, CodeMethodNotAllowed, fmt.Sprintf("%s is not allowed on %s", r.Method, r.URL.Path))
+}
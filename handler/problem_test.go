@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mmontes11/opencode-test/search"
+	"github.com/mmontes11/opencode-test/store"
+)
+
+// failingStore fails every list operation with a driver-like error.
+type failingStore struct {
+	store.Store
+}
+
+func (failingStore) ListCollections(context.Context, store.ListOptions) ([]store.Collection, string, error) {
+	return nil, "", errors.New("Error 1146: Table 'mydb.collections' doesn't exist")
+}
+
+func decodeProblem(t *testing.T, w *httptest.ResponseRecorder) Problem {
+	t.Helper()
+	if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Fatalf("expected Content-Type application/problem+json, got %s", ct)
+	}
+	var p Problem
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatalf("failed to decode problem: %v", err)
+	}
+	return p
+}
+
+func TestProblemResponses(t *testing.T) {
+	h := New(store.NewMemory(), search.NewMemory())
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		path    string
+		body    string
+		vars    map[string]string
+		status  int
+		code    ErrorCode
+	}{
+		{"missing item", h.GetItemHandler, "GET", "/items/42", "", map[string]string{"id": "42"}, 404, CodeNotFound},
+		{"invalid id", h.GetItemHandler, "GET", "/items/abc", "", map[string]string{"id": "abc"}, 400, CodeInvalidParameter},
+		{"invalid body", h.CreateItemHandler, "POST", "/items", "{", nil, 400, CodeInvalidBody},
+		{"missing name", h.CreateCollectionHandler, "POST", "/collections", "{}", nil, 400, CodeValidationFailed},
+		{"bad filter", h.ListItemHandler, "GET", "/items?filter=owner%3Dme", "", nil, 400, CodeInvalidParameter},
+		{"bad cursor", h.ListItemHandler, "GET", "/items?cursor=bogus", "", nil, 400, CodeInvalidParameter},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		if tt.vars != nil {
+			req = mux.SetURLVars(req, tt.vars)
+		}
+		tt.handler(w, req)
+		if w.Code != tt.status {
+			t.Fatalf("%s: expected %d, got %d", tt.name, tt.status, w.Code)
+		}
+		p := decodeProblem(t, w)
+		if p.Code != tt.code || p.Status != tt.status || p.Type != errorTypeBase+string(tt.code) || p.Instance != req.URL.Path {
+			t.Fatalf("%s: unexpected problem %+v", tt.name, p)
+		}
+	}
+}
+
+func TestProblemHidesInternalErrors(t *testing.T) {
+	h := New(failingStore{store.NewMemory()}, search.NewMemory())
+	w := httptest.NewRecorder()
+	h.ListCollectionHandler(w, httptest.NewRequest("GET", "/collections", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", w.Code)
+	}
+	p := decodeProblem(t, w)
+	if p.Code != CodeInternal || p.Detail != "" {
+		t.Fatalf("unexpected problem %+v", p)
+	}
+}
+
+func TestErrorCatalogDocumented(t *testing.T) {
+	doc, err := os.ReadFile("../docs/errors.md")
+	if err != nil {
+		t.Fatalf("failed to read catalog: %v", err)
+	}
+	for code, entry := range errorCatalog {
+		if !strings.Contains(string(doc), "\n## "+string(code)+"\n") {
+			t.Fatalf("code %s is not documented in docs/errors.md", code)
+		}
+		if entry.Status == 0 || entry.Title == "" {
+			t.Fatalf("code %s has an incomplete catalog entry", code)
+		}
+	}
+}
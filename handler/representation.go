@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/mmontes11/opencode-test/store"
+)
+
+// The types below are the wire format of the API. They are decoupled from
+// the store structs so that the JSON stays stable when those change.
+// Timestamps are encoded as RFC 3339 in UTC.
+
+// ItemResponse is the JSON representation of an item.
+type ItemResponse struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Version     int64     `json:"version"`
+	Tags        []string  `json:"tags"`
+	// Fields holds the custom fields, keyed by name.
+	Fields map[string]any `json:"fields"`
+	// DeletedAt is only set in the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Collections is only set with ?include=collections.
+	Collections *ItemCollectionsResponse `json:"collections,omitempty"`
+}
+
+// ItemCollectionsResponse lists the collections that contain an item.
+type ItemCollectionsResponse struct {
+	Count int     `json:"count"`
+	IDs   []int64 `json:"ids"`
+}
+
+// CollectionResponse is the JSON representation of a collection.
+type CollectionResponse struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Version     int64     `json:"version"`
+	Tags        []string  `json:"tags"`
+	// ParentID is null for top-level collections.
+	ParentID *int64 `json:"parent_id"`
+	// Kind is "smart" if the items are selected by Rule, else "manual".
+	Kind string `json:"kind"`
+	Rule string `json:"rule,omitempty"`
+	// Schema declares the custom fields of the items in the collection.
+	Schema []FieldDefinition `json:"schema"`
+	// DeletedAt is only set in the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+func newItemResponse(item *store.Item) ItemResponse {
+	resp := ItemResponse{
+		ID:          item.ID,
+		Name:        item.Name,
+		Description: item.Description,
+		CreatedAt:   item.CreatedAt.UTC(),
+		UpdatedAt:   item.UpdatedAt.UTC(),
+		Version:     item.Version,
+		Tags:        tagList(item.Tags),
+		Fields:      item.Fields,
+	}
+	if resp.Fields == nil {
+		resp.Fields = map[string]any{}
+	}
+	resp.DeletedAt = deletedAt(item.DeletedAt)
+	return resp
+}
+
+func newItemResponses(items []store.Item) []ItemResponse {
+	out := make([]ItemResponse, len(items))
+	for i := range items {
+		out[i] = newItemResponse(&items[i])
+	}
+	return out
+}
+
+func newCollectionResponse(col *store.Collection) CollectionResponse {
+	resp := CollectionResponse{
+		ID:          col.ID,
+		Name:        col.Name,
+		Description: col.Description,
+		CreatedAt:   col.CreatedAt.UTC(),
+		UpdatedAt:   col.UpdatedAt.UTC(),
+		Version:     col.Version,
+		Tags:        tagList(col.Tags),
+		Kind:        "manual",
+		Rule:        col.Rule,
+		Schema:      newFieldDefinitions(col.Schema),
+	}
+	if col.Rule != "" {
+		resp.Kind = "smart"
+	}
+	if col.ParentID != 0 {
+		resp.ParentID = &col.ParentID
+	}
+	resp.DeletedAt = deletedAt(col.DeletedAt)
+	return resp
+}
+
+func newCollectionResponses(cols []store.Collection) []CollectionResponse {
+	out := make([]CollectionResponse, len(cols))
+	for i := range cols {
+		out[i] = newCollectionResponse(&cols[i])
+	}
+	return out
+}
+
+// deletedAt encodes the deletion time of live rows, which is zero, as
+// null.
+func deletedAt(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+// tagList encodes missing tags as an empty array rather than null.
+func tagList(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mmontes11/opencode-test/search"
+	"github.com/mmontes11/opencode-test/store"
+)
+
+func TestItemRepresentation(t *testing.T) {
+	created := time.Date(2026, 3, 1, 9, 30, 0, 0, time.FixedZone("CET", 3600))
+	s := store.NewMemoryWithClock(func() time.Time { return created })
+	item, _ := s.CreateItem(context.Background(), "wire", "format")
+	h := New(s, search.NewMemory())
+	r := mux.NewRouter()
+	r.HandleFunc("/items/{id}", h.GetItemHandler).Methods("GET")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/items/%d", item.ID), nil))
+	var body map[string]any
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	var keys []string
+	for k := range body {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	want := []string{"created_at", "description", "fields", "id", "name", "tags", "updated_at", "version"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("unexpected members %v, want %v", keys, want)
+	}
+	for _, k := range []string{"created_at", "updated_at"} {
+		if v := body[k]; v != "2026-03-01T08:30:00Z" {
+			t.Fatalf("%s: got %v, want an RFC 3339 timestamp in UTC", k, v)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// pathID parses the named path variable as an ID.
+func pathID(r *http.Request, name string) (int64, error) {
+	s, ok := mux.Vars(r)[name]
+	if !ok {
+		return 0, newError(CodeInvalidParameter, "missing %s", name)
+	}
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, newError(CodeInvalidParameter, "invalid %s %q", name, s)
+	}
+	return id, nil
+}
+
+// decodeBody decodes the JSON request body into v.
+func decodeBody(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return newError(CodeInvalidBody, "invalid request body: %v", err)
+	}
+	return nil
+}
+
+// writeJSON encodes v as the JSON response body with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/mmontes11/opencode-test/search"
+)
+
+const (
+	// DefaultSearchLimit is used when a search request has no limit parameter.
+	DefaultSearchLimit = 20
+	// MaxSearchLimit is the largest limit a search request may ask for.
+	MaxSearchLimit = 100
+)
+
+// SearchHandler handles GET /search?q=.
+func (h *Handler) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := q.Get("q")
+	if query == "" {
+		writeError(w, r, newError(CodeInvalidParameter, "q is required"))
+		return
+	}
+	limit := DefaultSearchLimit
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > MaxSearchLimit {
+			writeError(w, r, newError(CodeInvalidParameter, "limit must be between 1 and %d", MaxSearchLimit))
+			return
+		}
+		limit = n
+	}
+	results, err := h.search.Search(r.Context(), query, limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if results == nil {
+		results = []search.Result{}
+	}
+	writeJSON(w, http.StatusOK, Page{Data: results})
+}
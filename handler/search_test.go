@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mmontes11/opencode-test/search"
+	"github.com/mmontes11/opencode-test/store"
+)
+
+// emptyIndex finds nothing and, like the MariaDB index, reports that as a
+// nil slice.
+type emptyIndex struct{}
+
+func (emptyIndex) Index(context.Context, search.Document) error { return nil }
+
+func (emptyIndex) Remove(context.Context, string, int64) error { return nil }
+
+func (emptyIndex) Search(context.Context, string, int) ([]search.Result, error) {
+	return nil, nil
+}
+
+func TestSearchHandler(t *testing.T) {
+	ctx := context.Background()
+	idx := search.NewMemory()
+	s := search.Sync(store.NewMemory(), idx)
+	if _, err := s.CreateItem(ctx, "Garden hose", "green"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	h := New(s, idx)
+
+	w := httptest.NewRecorder()
+	h.SearchHandler(w, httptest.NewRequest("GET", "/search?q=garden", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var page struct {
+		Data []search.Result `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(page.Data) != 1 || page.Data[0].Type != search.TypeItem || len(page.Data[0].Snippets) != 1 {
+		t.Fatalf("unexpected results: %+v", page.Data)
+	}
+
+	// A search without hits returns an empty list, not null.
+	w = httptest.NewRecorder()
+	New(s, emptyIndex{}).SearchHandler(w, httptest.NewRequest("GET", "/search?q=trowel", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var empty map[string]json.RawMessage
+	if err := json.NewDecoder(w.Body).Decode(&empty); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if string(empty["data"]) != "[]" {
+		t.Fatalf("expected empty data, got %s", empty["data"])
+	}
+
+	for _, query := range []string{"", "q=garden&limit=0"} {
+		w = httptest.NewRecorder()
+		h.SearchHandler(w, httptest.NewRequest("GET", "/search?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%q: expected 400, got %d", query, w.Code)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mmontes11/opencode-test/store"
+)
+
+const (
+	// MaxTagsPerRequest is the largest number of tags a request may add or
+	// filter by.
+	MaxTagsPerRequest = 100
+	// DefaultTagLimit is used when GET /tags has no limit parameter.
+	DefaultTagLimit = 20
+)
+
+// TagsRequest is the payload of POST /items/{id}/tags and
+// POST /collections/{id}/tags.
+// Example: {"tags": ["garden", "summer"]}
+type TagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// TagCountResponse reports how many items and collections carry a tag.
+type TagCountResponse struct {
+	Tag         string `json:"tag"`
+	Items       int    `json:"items"`
+	Collections int    `json:"collections"`
+}
+
+// queryTags parses the named query parameter as a comma-separated list of
+// tags. The parameter may be repeated.
+func queryTags(r *http.Request, name string) ([]string, error) {
+	var tags []string
+	for _, v := range r.URL.Query()[name] {
+		tags = append(tags, strings.Split(v, ",")...)
+	}
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	if len(tags) > MaxTagsPerRequest {
+		return nil, newError(CodeInvalidParameter, "%s must not list more than %d tags", name, MaxTagsPerRequest)
+	}
+	tags, err := store.NormalizeTags(tags)
+	if err != nil {
+		return nil, newError(CodeInvalidParameter, "invalid %s: %v", name, err)
+	}
+	return tags, nil
+}
+
+// decodeTags reads a TagsRequest.
+func decodeTags(r *http.Request) ([]string, error) {
+	var req TagsRequest
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+	if len(req.Tags) == 0 {
+		return nil, newError(CodeValidationFailed, "tags must not be empty")
+	}
+	if len(req.Tags) > MaxTagsPerRequest {
+		return nil, newError(CodeValidationFailed, "tags must not list more than %d tags", MaxTagsPerRequest)
+	}
+	return req.Tags, nil
+}
+
+// tagRequest reads the ID, If-Match version and tags of a tag change. The
+// tags come from the body, or from the {tag} path variable of a DELETE.
+func tagRequest(r *http.Request) (id, version int64, tags []string, err error) {
+	if id, err = pathID(r, "id"); err != nil {
+		return 0, 0, nil, err
+	}
+	if version, err = ifMatch(r); err != nil {
+		return 0, 0, nil, err
+	}
+	if tag, ok := mux.Vars(r)["tag"]; ok {
+		return id, version, []string{tag}, nil
+	}
+	tags, err = decodeTags(r)
+	return id, version, tags, err
+}
+
+// AddItemTagsHandler handles POST /items/{id}/tags.
+func (h *Handler) AddItemTagsHandler(w http.ResponseWriter, r *http.Request) {
+	h.changeItemTags(w, r, h.store.AddItemTags)
+}
+
+// RemoveItemTagHandler handles DELETE /items/{id}/tags/{tag}.
+func (h *Handler) RemoveItemTagHandler(w http.ResponseWriter, r *http.Request) {
+	h.changeItemTags(w, r, h.store.RemoveItemTags)
+}
+
+func (h *Handler) changeItemTags(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, id int64, tags []string, version int64) (*store.Item, error)) {
+	id, version, tags, err := tagRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	item, err := change(r.Context(), id, tags, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	setETag(w, item.Version)
+	writeJSON(w, http.StatusOK, newItemResponse(item))
+}
+
+// AddCollectionTagsHandler handles POST /collections/{id}/tags.
+func (h *Handler) AddCollectionTagsHandler(w http.ResponseWriter, r *http.Request) {
+	h.changeCollectionTags(w, r, h.store.AddCollectionTags)
+}
+
+// RemoveCollectionTagHandler handles DELETE /collections/{id}/tags/{tag}.
+func (h *Handler) RemoveCollectionTagHandler(w http.ResponseWriter, r *http.Request) {
+	h.changeCollectionTags(w, r, h.store.RemoveCollectionTags)
+}
+
+func (h *Handler) changeCollectionTags(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, id int64, tags []string, version int64) (*store.Collection, error)) {
+	id, version, tags, err := tagRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	col, err := change(r.Context(), id, tags, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	setETag(w, col.Version)
+	writeJSON(w, http.StatusOK, newCollectionResponse(col))
+}
+
+// ListTagsHandler handles GET /tags. The optional prefix parameter
+// restricts the tags to those starting with it, for autocompletion, and
+// limit caps their number. The most used tags come first.
+func (h *Handler) ListTagsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := store.TagListOptions{Prefix: strings.ToLower(strings.TrimSpace(q.Get("prefix"))), Limit: DefaultTagLimit}
+	if s := q.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 {
+			writeError(w, r, newError(CodeInvalidParameter, "limit must be a positive integer"))
+			return
+		}
+		if limit > MaxPageSize {
+			writeError(w, r, newError(CodeInvalidParameter, "limit must not exceed %d", MaxPageSize))
+			return
+		}
+		opts.Limit = limit
+	}
+	counts, err := h.store.ListTags(r.Context(), opts)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	resp := make([]TagCountResponse, len(counts))
+	for i, c := range counts {
+		resp[i] = TagCountResponse{Tag: c.Tag, Items: c.Items, Collections: c.Collections}
+	}
+	writePage(w, r, resp, "", opts.Limit, time.Time{})
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mmontes11/opencode-test/search"
+)
+
+func TestTags(t *testing.T) {
+	ctx := context.Background()
+	s := initDBForTest(t)
+	item, err := s.CreateItem(ctx, "tagged", "")
+	if err != nil {
+		t.Fatalf("CreateItem: %v", err)
+	}
+	t.Cleanup(func() { s.DeleteItem(ctx, item.ID, 0) })
+	col, err := s.CreateCollection(ctx, "tagged", "")
+	if err != nil {
+		t.Fatalf("CreateCollection: %v", err)
+	}
+	t.Cleanup(func() { s.DeleteCollection(ctx, col.ID, 0) })
+	h := New(s, search.NewMemory())
+	r := mux.NewRouter()
+	r.HandleFunc("/items", h.ListItemHandler).Methods("GET")
+	r.HandleFunc("/items/{id}/tags", h.AddItemTagsHandler).Methods("POST")
+	r.HandleFunc("/items/{id}/tags/{tag}", h.RemoveItemTagHandler).Methods("DELETE")
+	r.HandleFunc("/collections", h.ListCollectionHandler).Methods("GET")
+	r.HandleFunc("/collections/{id}/tags", h.AddCollectionTagsHandler).Methods("POST")
+	r.HandleFunc("/tags", h.ListTagsHandler).Methods("GET")
+
+	do := func(method, path, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	// The tags are unique to this run, since the store may be shared.
+	p := fmt.Sprintf("h%d-", item.ID)
+	itemTags := fmt.Sprintf("/items/%d/tags", item.ID)
+
+	w := do("POST", itemTags, fmt.Sprintf(`{"tags":["%sGarden","%ssummer"]}`, p, p), "If-Match", etag(item.Version))
+	var got ItemResponse
+	json.NewDecoder(w.Body).Decode(&got)
+	if w.Code != http.StatusOK || !slices.Equal(got.Tags, []string{p + "garden", p + "summer"}) || w.Header().Get("ETag") != etag(item.Version+1) {
+		t.Fatalf("adding tags: got %d %+v, ETag %s", w.Code, got, w.Header().Get("ETag"))
+	}
+	if w := do("POST", fmt.Sprintf("/collections/%d/tags", col.ID), fmt.Sprintf(`{"tags":["%sgarden"]}`, p)); w.Code != http.StatusOK {
+		t.Fatalf("adding collection tags: got %d %s", w.Code, w.Body)
+	}
+
+	for _, tc := range []struct {
+		query string
+		want  int
+	}{
+		{"tags_all=" + p + "garden," + p + "summer", 1},
+		{"tags_all=" + p + "garden&tags_all=" + p + "winter", 0},
+		{"tags_any=" + p + "winter," + p + "SUMMER", 1},
+	} {
+		w := do("GET", "/items?"+tc.query, "")
+		var page struct{ Data []ItemResponse }
+		json.NewDecoder(w.Body).Decode(&page)
+		if w.Code != http.StatusOK || len(page.Data) != tc.want {
+			t.Fatalf("GET /items?%s: got %d with %d items, want %d", tc.query, w.Code, len(page.Data), tc.want)
+		}
+	}
+	if w := do("GET", "/collections?tags_any="+p+"garden", ""); w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte(`"tags":["`+p+`garden"]`)) {
+		t.Fatalf("GET /collections by tag: got %d %s", w.Code, w.Body)
+	}
+	if w := do("GET", "/items?tags_all=two%20words", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("malformed tag filter: expected 400, got %d", w.Code)
+	}
+
+	w = do("GET", "/tags?prefix="+p+"G", "")
+	var tags struct{ Data []TagCountResponse }
+	json.NewDecoder(w.Body).Decode(&tags)
+	if w.Code != http.StatusOK || len(tags.Data) != 1 || tags.Data[0] != (TagCountResponse{Tag: p + "garden", Items: 1, Collections: 1}) {
+		t.Fatalf("GET /tags: got %d %+v", w.Code, tags)
+	}
+	if w := do("GET", "/tags?limit=0", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("GET /tags?limit=0: expected 400, got %d", w.Code)
+	}
+
+	w = do("DELETE", itemTags+"/"+p+"summer", "")
+	got = ItemResponse{}
+	json.NewDecoder(w.Body).Decode(&got)
+	if w.Code != http.StatusOK || !slices.Equal(got.Tags, []string{p + "garden"}) {
+		t.Fatalf("removing a tag: got %d %+v", w.Code, got)
+	}
+	for _, tc := range []struct {
+		body string
+		code int
+	}{
+		{`{}`, http.StatusBadRequest},
+		{`{"tags":["a b"]}`, http.StatusBadRequest},
+		{`{"tags":["ok"]}`, http.StatusPreconditionFailed},
+	} {
+		if w := do("POST", itemTags, tc.body, "If-Match", etag(item.Version)); w.Code != tc.code {
+			t.Fatalf("POST %s: expected %d, got %d", tc.body, tc.code, w.Code)
+		}
+	}
+	if w := do("POST", fmt.Sprintf("/items/%d/tags", item.ID+1000000), `{"tags":["x"]}`); w.Code != http.StatusNotFound {
+		t.Fatalf("tagging a missing item: expected 404, got %d", w.Code)
+	}
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mmontes11/opencode-test/store/filter"
+)
+
+// ListDeletedItemsHandler handles GET /trash/items. It lists deleted items
+// with their deleted_at, and takes the same parameters as GET /items.
+func (h *Handler) ListDeletedItemsHandler(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r, filter.ItemFields)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	items, next, err := h.store.ListDeletedItems(r.Context(), opts)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writePage(w, r, newItemResponses(items), next, opts.Limit, time.Time{})
+}
+
+// ListDeletedCollectionsHandler handles GET /trash/collections.
+func (h *Handler) ListDeletedCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r, filter.DefaultFields)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	cols, next, err := h.store.ListDeletedCollections(r.Context(), opts)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writePage(w, r, newCollectionResponses(cols), next, opts.Limit, time.Time{})
+}
+
+// RestoreItemHandler handles POST /items/{id}/restore. It takes a deleted
+// item out of the trash together with its memberships.
+func (h *Handler) RestoreItemHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	version, err := ifMatch(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	item, err := h.store.RestoreItem(r.Context(), id, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	setETag(w, item.Version)
+	writeJSON(w, http.StatusOK, newItemResponse(item))
+}
+
+// RestoreCollectionHandler handles POST /collections/{id}/restore. It takes
+// a deleted collection out of the trash together with its memberships and
+// the descendants deleted along with it.
+func (h *Handler) RestoreCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	version, err := ifMatch(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	col, err := h.store.RestoreCollection(r.Context(), id, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	setETag(w, col.Version)
+	writeJSON(w, http.StatusOK, newCollectionResponse(col))
+}
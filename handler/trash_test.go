@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mmontes11/opencode-test/search"
+)
+
+func TestTrash(t *testing.T) {
+	ctx := context.Background()
+	s := initDBForTest(t)
+	item, err := s.CreateItem(ctx, "trashed", "")
+	if err != nil {
+		t.Fatalf("CreateItem: %v", err)
+	}
+	t.Cleanup(func() { s.DeleteItem(ctx, item.ID, 0) })
+	col, err := s.CreateCollection(ctx, "trashed", "")
+	if err != nil {
+		t.Fatalf("CreateCollection: %v", err)
+	}
+	t.Cleanup(func() { s.DeleteCollection(ctx, col.ID, 0) })
+	if err := s.AddItemToCollection(ctx, col.ID, item.ID); err != nil {
+		t.Fatalf("AddItemToCollection: %v", err)
+	}
+	h := New(s, search.NewMemory())
+	r := mux.NewRouter()
+	r.HandleFunc("/items/{id}", h.GetItemHandler).Methods("GET")
+	r.HandleFunc("/items/{id}", h.DeleteItemHandler).Methods("DELETE")
+	r.HandleFunc("/items/{id}/restore", h.RestoreItemHandler).Methods("POST")
+	r.HandleFunc("/collections/{id}", h.DeleteCollectionHandler).Methods("DELETE")
+	r.HandleFunc("/collections/{id}/restore", h.RestoreCollectionHandler).Methods("POST")
+	r.HandleFunc("/collections/{id}/items", h.ListItemsInCollectionHandler).Methods("GET")
+	r.HandleFunc("/trash/items", h.ListDeletedItemsHandler).Methods("GET")
+	r.HandleFunc("/trash/collections", h.ListDeletedCollectionsHandler).Methods("GET")
+
+	do := func(method, path string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(""))
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	var page struct {
+		Data []ItemResponse `json:"data"`
+	}
+	itemPath := fmt.Sprintf("/items/%d", item.ID)
+	colItems := fmt.Sprintf("/collections/%d/items", col.ID)
+
+	if w := do("DELETE", itemPath); w.Code != http.StatusNoContent {
+		t.Fatalf("deleting the item: got %d %s", w.Code, w.Body)
+	}
+	if w := do("GET", itemPath); w.Code != http.StatusNotFound {
+		t.Fatalf("getting a deleted item: got %d, want 404", w.Code)
+	}
+	w := do("GET", colItems)
+	json.NewDecoder(w.Body).Decode(&page)
+	if w.Code != http.StatusOK || len(page.Data) != 0 {
+		t.Fatalf("listing a collection with a deleted item: got %d %+v", w.Code, page)
+	}
+	w = do("GET", fmt.Sprintf("/trash/items?limit=%d", MaxPageSize))
+	page.Data = nil
+	json.NewDecoder(w.Body).Decode(&page)
+	var trashed *ItemResponse
+	for i := range page.Data {
+		if page.Data[i].ID == item.ID {
+			trashed = &page.Data[i]
+		}
+	}
+	if w.Code != http.StatusOK || trashed == nil || trashed.DeletedAt == nil {
+		t.Fatalf("listing the trash: got %d %+v, want item %d with deleted_at", w.Code, page, item.ID)
+	}
+
+	if w := do("POST", itemPath+"/restore", "If-Match", etag(item.Version+1)); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("restoring with a stale version: got %d, want 412", w.Code)
+	}
+	w = do("POST", itemPath+"/restore", "If-Match", etag(item.Version))
+	var got map[string]any
+	json.NewDecoder(w.Body).Decode(&got)
+	if _, ok := got["deleted_at"]; w.Code != http.StatusOK || ok || w.Header().Get("ETag") != etag(item.Version+1) {
+		t.Fatalf("restoring the item: got %d %v, ETag %s", w.Code, got, w.Header().Get("ETag"))
+	}
+	if w := do("POST", itemPath+"/restore"); w.Code != http.StatusConflict {
+		t.Fatalf("restoring a live item: got %d, want 409", w.Code)
+	}
+	w = do("GET", colItems)
+	page.Data = nil
+	json.NewDecoder(w.Body).Decode(&page)
+	if len(page.Data) != 1 || page.Data[0].ID != item.ID {
+		t.Fatalf("membership was not restored: got %+v", page)
+	}
+
+	colPath := fmt.Sprintf("/collections/%d", col.ID)
+	if w := do("DELETE", colPath); w.Code != http.StatusNoContent {
+		t.Fatalf("deleting the collection: got %d %s", w.Code, w.Body)
+	}
+	if w := do("GET", colItems); w.Code != http.StatusNotFound {
+		t.Fatalf("listing a deleted collection: got %d, want 404", w.Code)
+	}
+	w = do("GET", fmt.Sprintf("/trash/collections?limit=%d&filter=name=trashed", MaxPageSize))
+	var cols struct {
+		Data []CollectionResponse `json:"data"`
+	}
+	json.NewDecoder(w.Body).Decode(&cols)
+	if w.Code != http.StatusOK || len(cols.Data) == 0 || cols.Data[len(cols.Data)-1].DeletedAt == nil {
+		t.Fatalf("listing deleted collections: got %d %+v", w.Code, cols)
+	}
+	if w := do("POST", colPath+"/restore"); w.Code != http.StatusOK {
+		t.Fatalf("restoring the collection: got %d %s", w.Code, w.Body)
+	}
+	if w := do("POST", fmt.Sprintf("/collections/%d/restore", col.ID+1000000)); w.Code != http.StatusNotFound {
+		t.Fatalf("restoring a missing collection: got %d, want 404", w.Code)
+	}
+}
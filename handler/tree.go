@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mmontes11/opencode-test/store"
+	"github.com/mmontes11/opencode-test/store/filter"
+)
+
+// MoveCollectionRequest is the payload of POST /collections/{id}/move.
+// A null parent_id moves the collection to the top level.
+// Example: {"parent_id": 7}
+type MoveCollectionRequest struct {
+	ParentID json.RawMessage `json:"parent_id"`
+}
+
+// CollectionTreeResponse is a collection with its descendants nested in
+// children.
+type CollectionTreeResponse struct {
+	CollectionResponse
+	Children []*CollectionTreeResponse `json:"children"`
+}
+
+// queryBool parses the named boolean query parameter, which defaults to
+// false.
+func queryBool(r *http.Request, name string) (bool, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, newError(CodeInvalidParameter, "invalid %s %q", name, v)
+	}
+	return b, nil
+}
+
+// MoveCollectionHandler handles POST /collections/{id}/move.
+func (h *Handler) MoveCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	version, err := ifMatch(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var req MoveCollectionRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(req.ParentID) == 0 {
+		writeError(w, r, newError(CodeValidationFailed, "parent_id is required; use null for the top level"))
+		return
+	}
+	var parentID *int64
+	if err := json.Unmarshal(req.ParentID, &parentID); err != nil {
+		writeError(w, r, newError(CodeInvalidBody, "invalid parent_id: %v", err))
+		return
+	}
+	if parentID == nil {
+		parentID = new(int64)
+	}
+	col, err := h.store.MoveCollection(r.Context(), id, *parentID, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	setETag(w, col.Version)
+	writeJSON(w, http.StatusOK, newCollectionResponse(col))
+}
+
+// ListChildCollectionsHandler handles GET /collections/{id}/children.
+func (h *Handler) ListChildCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	opts, err := parseListOptions(r, filter.DefaultFields)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	cols, next, err := h.store.ListChildCollections(r.Context(), id, opts)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writePage(w, r, newCollectionResponses(cols), next, opts.Limit, time.Time{})
+}
+
+// CollectionTreeHandler handles GET /collections/{id}/tree, which returns
+// the collection with all its descendants nested.
+func (h *Handler) CollectionTreeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	cols, err := h.store.CollectionSubtree(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeDerived(w, r, newCollectionTree(id, cols))
+}
+
+// newCollectionTree nests the collections of a subtree under the root with
+// the given ID. Children are ordered by ID, like cols.
+func newCollectionTree(rootID int64, cols []store.Collection) *CollectionTreeResponse {
+	nodes := make(map[int64]*CollectionTreeResponse, len(cols))
+	for i := range cols {
+		nodes[cols[i].ID] = &CollectionTreeResponse{CollectionResponse: newCollectionResponse(&cols[i]), Children: []*CollectionTreeResponse{}}
+	}
+	for i := range cols {
+		if parent, ok := nodes[cols[i].ParentID]; ok && cols[i].ID != rootID {
+			parent.Children = append(parent.Children, nodes[cols[i].ID])
+		}
+	}
+	return nodes[rootID]
+}
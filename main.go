@@ -1,21 +1,107 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"log"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/mmontes11/opencode-test/db"
+	"github.com/mmontes11/opencode-test/handler"
 	"github.com/mmontes11/opencode-test/router"
+	"github.com/mmontes11/opencode-test/search"
+	"github.com/mmontes11/opencode-test/store"
 )
 
 func main() {
-	// Initialize database connection
-	if err := db.Init(); err != nil {
-		log.Fatalf("failed to initialize database: %v", err)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			runMigrate(os.Args[2:])
+		case "fsck":
+			runFsck(os.Args[2:])
+		default:
+			log.Fatalf("unknown command %q", os.Args[1])
+		}
+		return
 	}
 
+	// Select the store backend; STORE_BACKEND=memory skips MariaDB entirely
+	var s store.Store
+	var conn *sql.DB
+	switch backend := os.Getenv("STORE_BACKEND"); backend {
+	case "", "mariadb":
+		var err error
+		conn, err = db.Open()
+		if err != nil {
+			log.Fatalf("failed to initialize database: %v", err)
+		}
+		defer conn.Close()
+		// Apply pending migrations unless MIGRATE_ON_START=false
+		if os.Getenv("MIGRATE_ON_START") != "false" {
+			applied, err := db.MigrateUp(context.Background(), conn)
+			if err != nil {
+				log.Fatalf("failed to apply migrations: %v", err)
+			}
+			for _, m := range applied {
+				log.Printf("applied migration %d_%s", m.Version, m.Name)
+			}
+		}
+		s = store.NewMariaDB(conn)
+	case "memory":
+		log.Printf("using in-memory store; data will not be persisted")
+		s = store.NewMemory()
+	default:
+		log.Fatalf("unknown STORE_BACKEND %q", backend)
+	}
+
+	// Select the search index; it defaults to FULLTEXT when MariaDB is used
+	var idx search.Index
+	switch backend := os.Getenv("SEARCH_BACKEND"); {
+	case backend == "mariadb" || backend == "" && conn != nil:
+		if conn == nil {
+			log.Fatalf("SEARCH_BACKEND=mariadb requires STORE_BACKEND=mariadb")
+		}
+		idx = search.NewMariaDB(conn)
+	case backend == "memory" || backend == "":
+		mem := search.NewMemory()
+		if err := search.Rebuild(context.Background(), mem, s); err != nil {
+			log.Fatalf("failed to build search index: %v", err)
+		}
+		idx = mem
+	default:
+		log.Fatalf("unknown SEARCH_BACKEND %q", backend)
+	}
+	s = search.Sync(s, idx)
+
+	// Keep Idempotency-Key responses for IDEMPOTENCY_TTL and purge them
+	// once they expire
+	opts := handler.DefaultOptions
+	if v := os.Getenv("IDEMPOTENCY_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl <= 0 {
+			log.Fatalf("invalid IDEMPOTENCY_TTL %q", v)
+		}
+		opts.IdempotencyTTL = ttl
+	}
+	go purgeIdempotencyKeys(s, min(opts.IdempotencyTTL, time.Hour))
+
+	// Keep deleted items and collections in the trash for TRASH_RETENTION
+	// and purge them afterwards
+	retention := defaultTrashRetention
+	if v := os.Getenv("TRASH_RETENTION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("invalid TRASH_RETENTION %q", v)
+		}
+		retention = d
+	}
+	go purgeTrash(s, retention, min(retention, time.Hour))
+
 	// Setup router
-	r := router.NewRouter()
+	r := router.NewRouter(s, idx, opts)
 
 	// Start HTTP server
 	addr := ":8080"
@@ -24,3 +110,33 @@ func main() {
 		log.Fatalf("server error: %v", err)
 	}
 }
+
+// defaultTrashRetention is how long deleted rows stay restorable unless
+// TRASH_RETENTION says otherwise.
+const defaultTrashRetention = 30 * 24 * time.Hour
+
+// purgeIdempotencyKeys deletes expired Idempotency-Key records every
+// interval. Expired keys are already ignored, so this only reclaims space.
+func purgeIdempotencyKeys(s store.Store, interval time.Duration) {
+	for range time.Tick(interval) {
+		n, err := s.PurgeIdempotencyKeys(context.Background())
+		if err != nil {
+			log.Printf("failed to purge idempotency keys: %v", err)
+		} else if n > 0 {
+			log.Printf("purged %d expired idempotency keys", n)
+		}
+	}
+}
+
+// purgeTrash permanently deletes the items and collections that have been
+// in the trash for longer than retention, every interval.
+func purgeTrash(s store.Store, retention, interval time.Duration) {
+	for range time.Tick(interval) {
+		n, err := s.PurgeDeleted(context.Background(), retention)
+		if err != nil {
+			log.Printf("failed to purge the trash: %v", err)
+		} else if n > 0 {
+			log.Printf("purged %d deleted items and collections", n)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/mmontes11/opencode-test/db"
+)
+
+// runMigrate implements the "migrate up|down [n]|status" subcommand.
+func runMigrate(args []string) {
+	if len(args) == 0 {
+		log.Fatalf("usage: migrate up|down [n]|status")
+	}
+	conn, err := db.Open()
+	if err != nil {
+		log.Fatalf("failed to initialize database: %v", err)
+	}
+	defer conn.Close()
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := db.MigrateUp(ctx, conn)
+		for _, m := range applied {
+			fmt.Printf("applied %d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("migrate up: %v", err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatalf("invalid step count %q", args[1])
+			}
+		}
+		reverted, err := db.MigrateDown(ctx, conn, steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("migrate down: %v", err)
+		}
+	case "status":
+		status, err := db.Status(ctx, conn)
+		if err != nil {
+			log.Fatalf("migrate status: %v", err)
+		}
+		for _, s := range status {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, state)
+		}
+	default:
+		log.Fatalf("unknown migrate command %q", args[0])
+	}
+}
@@ -5,26 +5,81 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/mmontes11/opencode-test/handler"
+	"github.com/mmontes11/opencode-test/search"
+	"github.com/mmontes11/opencode-test/store"
 )
 
 // NewRouter creates a new HTTP router with example routes.
-func NewRouter() http.Handler {
+// All item and collection routes are served under /v1 from the given Store,
+// and /v1/search queries the given Index. POST routes honor the
+// Idempotency-Key header as configured by opts.
+func NewRouter(s store.Store, idx search.Index, opts handler.Options) http.Handler {
 	r := mux.NewRouter()
+	r.NotFoundHandler = http.HandlerFunc(handler.NotFoundHandler)
+	r.MethodNotAllowedHandler = http.HandlerFunc(handler.MethodNotAllowedHandler)
+	h := handler.NewWithOptions(s, idx, opts)
 
 	// Simple health check endpoint
 	r.HandleFunc("/health", handler.HealthCheck).Methods("GET")
 
+	// The API is versioned by path prefix, so that a future incompatible
+	// representation can be served next to this one.
+	v1 := r.PathPrefix("/v1").Subrouter()
+	v1.Use(h.Idempotent)
+
+	// Item routes
+	v1.HandleFunc("/items", h.CreateItemHandler).Methods("POST")
+	v1.HandleFunc("/items", h.ListItemHandler).Methods("GET")
+	v1.HandleFunc("/items/{id}", h.GetItemHandler).Methods("GET")
+	v1.HandleFunc("/items/{id}", h.UpdateItemHandler).Methods("PUT")
+	v1.HandleFunc("/items/{id}", h.PatchItemHandler).Methods("PATCH")
+	v1.HandleFunc("/items/{id}", h.DeleteItemHandler).Methods("DELETE")
+	v1.HandleFunc("/items/{id}/collections", h.ListCollectionsOfItemHandler).Methods("GET")
+	v1.HandleFunc("/items/{id}/restore", h.RestoreItemHandler).Methods("POST")
+	v1.HandleFunc("/items/{id}/tags", h.AddItemTagsHandler).Methods("POST")
+	v1.HandleFunc("/items/{id}/tags/{tag}", h.RemoveItemTagHandler).Methods("DELETE")
+	v1.HandleFunc("/items/{id}/fields", h.ReplaceItemFieldsHandler).Methods("PUT")
+	v1.HandleFunc("/items/{id}/fields", h.PatchItemFieldsHandler).Methods("PATCH")
+
 	// Collection routes
-	r.HandleFunc("/collections", handler.CreateCollectionHandler).Methods("POST")
-	r.HandleFunc("/collections", handler.ListCollectionHandler).Methods("GET")
-	r.HandleFunc("/collections/{id}", handler.GetCollectionHandler).Methods("GET")
-	r.HandleFunc("/collections/{id}", handler.UpdateCollectionHandler).Methods("PUT")
-	r.HandleFunc("/collections/{id}", handler.DeleteCollectionHandler).Methods("DELETE")
+	v1.HandleFunc("/collections", h.CreateCollectionHandler).Methods("POST")
+	v1.HandleFunc("/collections", h.ListCollectionHandler).Methods("GET")
+	v1.HandleFunc("/collections/{id}", h.GetCollectionHandler).Methods("GET")
+	v1.HandleFunc("/collections/{id}", h.UpdateCollectionHandler).Methods("PUT")
+	v1.HandleFunc("/collections/{id}", h.PatchCollectionHandler).Methods("PATCH")
+	v1.HandleFunc("/collections/{id}", h.DeleteCollectionHandler).Methods("DELETE")
+	v1.HandleFunc("/collections/{id}/move", h.MoveCollectionHandler).Methods("POST")
+	v1.HandleFunc("/collections/{id}/restore", h.RestoreCollectionHandler).Methods("POST")
+	v1.HandleFunc("/collections/{id}/children", h.ListChildCollectionsHandler).Methods("GET")
+	v1.HandleFunc("/collections/{id}/tree", h.CollectionTreeHandler).Methods("GET")
+	v1.HandleFunc("/collections/{id}/tags", h.AddCollectionTagsHandler).Methods("POST")
+	v1.HandleFunc("/collections/{id}/tags/{tag}", h.RemoveCollectionTagHandler).Methods("DELETE")
+	v1.HandleFunc("/collections/{id}/schema", h.SetCollectionSchemaHandler).Methods("PUT")
 
 	// Collection item routes
-	r.HandleFunc("/collections/{id}/items", handler.AddItemToCollectionHandler).Methods("POST")
-	r.HandleFunc("/collections/{id}/items", handler.ListItemsInCollectionHandler).Methods("GET")
-	r.HandleFunc("/collections/{id}/items/{item_id}", handler.RemoveItemFromCollectionHandler).Methods("DELETE")
+	v1.HandleFunc("/collections/{id}/items", h.AddItemToCollectionHandler).Methods("POST")
+	v1.HandleFunc("/collections/{id}/items", h.ListItemsInCollectionHandler).Methods("GET")
+	v1.HandleFunc("/collections/{id}/items", h.ReplaceCollectionItemsHandler).Methods("PUT")
+	v1.HandleFunc("/collections/{id}/items/bulk-add", h.AddItemsToCollectionHandler).Methods("POST")
+	v1.HandleFunc("/collections/{id}/items/bulk-remove", h.RemoveItemsFromCollectionHandler).Methods("POST")
+	v1.HandleFunc("/collections/{id}/items/{item_id}", h.RemoveItemFromCollectionHandler).Methods("DELETE")
+	v1.HandleFunc("/collections/{id}/items/{item_id}/move", h.MoveItemInCollectionHandler).Methods("POST")
+
+	// Tag routes
+	v1.HandleFunc("/tags", h.ListTagsHandler).Methods("GET")
+
+	// Trash routes
+	v1.HandleFunc("/trash/items", h.ListDeletedItemsHandler).Methods("GET")
+	v1.HandleFunc("/trash/collections", h.ListDeletedCollectionsHandler).Methods("GET")
+
+	// Search routes
+	v1.HandleFunc("/search", h.SearchHandler).Methods("GET")
+
+	// Batch route; sub-requests are served by a router like this one,
+	// backed by a transaction in atomic mode.
+	v1.HandleFunc("/batch", h.BatchHandler(func(s store.Store) http.Handler {
+		return NewRouter(s, idx, opts)
+	})).Methods("POST")
 
 	return r
 }
@@ -0,0 +1,66 @@
+package search
+
+import (
+	"context"
+	"database/sql"
+)
+
+// MariaDB searches the FULLTEXT indexes created by the migrations in
+// db/migrations. The database maintains those indexes on every write, so
+// Index and Remove have nothing to do.
+//
+// Natural language mode ignores stopwords and words shorter than
+// innodb_ft_min_token_size (3 by default).
+type MariaDB struct {
+	db *sql.DB
+}
+
+var _ Index = (*MariaDB)(nil)
+
+// NewMariaDB returns an Index that queries the given database.
+func NewMariaDB(db *sql.DB) *MariaDB {
+	return &MariaDB{db: db}
+}
+
+func (m *MariaDB) Index(ctx context.Context, doc Document) error { return nil }
+
+func (m *MariaDB) Remove(ctx context.Context, docType string, id int64) error { return nil }
+
+func (m *MariaDB) Search(ctx context.Context, query string, limit int) ([]Result, error) {
+	terms := queryTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	q := `SELECT 'item', id, name, description, MATCH(name, description) AGAINST (? IN NATURAL LANGUAGE MODE) AS score
+FROM items WHERE MATCH(name, description) AGAINST (? IN NATURAL LANGUAGE MODE) AND deleted_at IS NULL
+UNION ALL
+SELECT 'collection', id, name, description, MATCH(name, description) AGAINST (? IN NATURAL LANGUAGE MODE) AS score
+FROM collections WHERE MATCH(name, description) AGAINST (? IN NATURAL LANGUAGE MODE) AND deleted_at IS NULL
+ORDER BY score DESC, id`
+	args := []any{query, query, query, query}
+	if limit > 0 {
+		q += " LIMIT ?"
+		args = append(args, limit)
+	}
+	rows, err := m.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var results []Result
+	for rows.Next() {
+		var doc Document
+		var score float64
+		if err := rows.Scan(&doc.Type, &doc.ID, &doc.Name, &doc.Description, &score); err != nil {
+			return nil, err
+		}
+		results = append(results, Result{
+			Type:     doc.Type,
+			ID:       doc.ID,
+			Name:     doc.Name,
+			Score:    score,
+			Snippets: snippets(doc, terms),
+		})
+	}
+	return results, rows.Err()
+}
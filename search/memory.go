@@ -0,0 +1,124 @@
+package search
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+)
+
+// nameWeight boosts matches in the name over matches in the description.
+const nameWeight = 2
+
+type docKey struct {
+	docType string
+	id      int64
+}
+
+// Memory is an in-process inverted index. Documents are ranked by TF-IDF,
+// with matches in the name weighted above matches in the description. It is
+// safe for concurrent use.
+type Memory struct {
+	mu       sync.RWMutex
+	docs     map[docKey]Document
+	postings map[string]map[docKey]float64
+}
+
+var _ Index = (*Memory)(nil)
+
+// NewMemory returns an empty in-memory index.
+func NewMemory() *Memory {
+	return &Memory{
+		docs:     make(map[docKey]Document),
+		postings: make(map[string]map[docKey]float64),
+	}
+}
+
+func (m *Memory) Index(ctx context.Context, doc Document) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := docKey{doc.Type, doc.ID}
+	m.remove(key)
+	m.docs[key] = doc
+	weights := make(map[string]float64)
+	for _, tok := range tokenize(doc.Name) {
+		weights[tok.term] += nameWeight
+	}
+	for _, tok := range tokenize(doc.Description) {
+		weights[tok.term]++
+	}
+	for term, w := range weights {
+		p, ok := m.postings[term]
+		if !ok {
+			p = make(map[docKey]float64)
+			m.postings[term] = p
+		}
+		p[key] = w
+	}
+	return nil
+}
+
+func (m *Memory) Remove(ctx context.Context, docType string, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(docKey{docType, id})
+	return nil
+}
+
+// remove drops a document and its postings. The caller must hold the lock.
+func (m *Memory) remove(key docKey) {
+	doc, ok := m.docs[key]
+	if !ok {
+		return
+	}
+	delete(m.docs, key)
+	for _, text := range []string{doc.Name, doc.Description} {
+		for _, tok := range tokenize(text) {
+			if p, ok := m.postings[tok.term]; ok {
+				delete(p, key)
+				if len(p) == 0 {
+					delete(m.postings, tok.term)
+				}
+			}
+		}
+	}
+}
+
+func (m *Memory) Search(ctx context.Context, query string, limit int) ([]Result, error) {
+	terms := queryTerms(query)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	scores := make(map[docKey]float64)
+	n := float64(len(m.docs))
+	for _, term := range terms {
+		p := m.postings[term]
+		idf := math.Log(1 + n/float64(len(p)+1))
+		for key, w := range p {
+			scores[key] += w * idf
+		}
+	}
+	results := make([]Result, 0, len(scores))
+	for key, score := range scores {
+		doc := m.docs[key]
+		results = append(results, Result{
+			Type:     doc.Type,
+			ID:       doc.ID,
+			Name:     doc.Name,
+			Score:    score,
+			Snippets: snippets(doc, terms),
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if results[i].Type != results[j].Type {
+			return results[i].Type > results[j].Type
+		}
+		return results[i].ID < results[j].ID
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
@@ -0,0 +1,167 @@
+// Package search provides full-text search over items and collections.
+//
+// Index is implemented by MariaDB, which relies on FULLTEXT indexes, and by
+// Memory, a pure-Go inverted index. Sync wraps a store.Store so that every
+// write is mirrored into the index.
+package search
+
+import (
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Document types.
+const (
+	TypeItem       = "item"
+	TypeCollection = "collection"
+)
+
+// Document is the searchable representation of an item or collection.
+type Document struct {
+	Type        string
+	ID          int64
+	Name        string
+	Description string
+}
+
+// Range is a half-open byte range [Start, End) within a snippet's text.
+type Range struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// Snippet is an excerpt of a matching field. Highlights locate the matched
+// terms within Text.
+type Snippet struct {
+	Field      string  `json:"field"`
+	Text       string  `json:"text"`
+	Highlights []Range `json:"highlights"`
+}
+
+// Result is a ranked search hit.
+type Result struct {
+	Type     string    `json:"type"`
+	ID       int64     `json:"id"`
+	Name     string    `json:"name"`
+	Score    float64   `json:"score"`
+	Snippets []Snippet `json:"snippets"`
+}
+
+// Index maintains and queries a full-text index of documents.
+type Index interface {
+	// Index adds a document, replacing any previous version.
+	Index(ctx context.Context, doc Document) error
+	// Remove deletes a document. Removing a missing document is not an error.
+	Remove(ctx context.Context, docType string, id int64) error
+	// Search returns at most limit results ranked by relevance.
+	Search(ctx context.Context, query string, limit int) ([]Result, error)
+}
+
+// token is a lowercased word and its byte range in the source text.
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits text into lowercased words of letters and digits.
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if word && start < 0 {
+			start = i
+		} else if !word && start >= 0 {
+			tokens = append(tokens, token{term: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{term: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+	return tokens
+}
+
+// queryTerms returns the distinct terms of a query.
+func queryTerms(query string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, tok := range tokenize(query) {
+		if !seen[tok.term] {
+			seen[tok.term] = true
+			terms = append(terms, tok.term)
+		}
+	}
+	return terms
+}
+
+// snippetRadius is the number of bytes of context kept on each side of the
+// first match in a field.
+const snippetRadius = 40
+
+// snippets returns an excerpt of every field of doc that contains one of the
+// terms.
+func snippets(doc Document, terms []string) []Snippet {
+	want := make(map[string]bool, len(terms))
+	for _, t := range terms {
+		want[t] = true
+	}
+	var out []Snippet
+	for _, f := range []struct{ name, text string }{{"name", doc.Name}, {"description", doc.Description}} {
+		var matches []token
+		for _, tok := range tokenize(f.text) {
+			if want[tok.term] {
+				matches = append(matches, tok)
+			}
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		out = append(out, excerpt(f.name, f.text, matches))
+	}
+	return out
+}
+
+// excerpt cuts a window of text around the first match, cutting at word
+// boundaries, and translates the matches inside it into highlights.
+func excerpt(field, text string, matches []token) Snippet {
+	start := matches[0].start - snippetRadius
+	if start <= 0 {
+		start = 0
+	} else {
+		for !utf8.RuneStart(text[start]) {
+			start++
+		}
+		if i := strings.IndexByte(text[start:matches[0].start], ' '); i >= 0 {
+			start += i + 1
+		}
+	}
+	end := matches[0].end + snippetRadius
+	if end >= len(text) {
+		end = len(text)
+	} else {
+		for !utf8.RuneStart(text[end]) {
+			end--
+		}
+		if i := strings.LastIndexByte(text[matches[0].end:end], ' '); i >= 0 {
+			end = matches[0].end + i
+		}
+	}
+	prefix, suffix := "", ""
+	if start > 0 {
+		prefix = "…"
+	}
+	if end < len(text) {
+		suffix = "…"
+	}
+	s := Snippet{Field: field, Text: prefix + text[start:end] + suffix}
+	for _, m := range matches {
+		if m.start >= start && m.end <= end {
+			off := len(prefix) - start
+			s.Highlights = append(s.Highlights, Range{Start: m.start + off, End: m.end + off})
+		}
+	}
+	return s
+}
@@ -0,0 +1,126 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/mmontes11/opencode-test/store"
+)
+
+func TestMemorySearchRanking(t *testing.T) {
+	ctx := context.Background()
+	idx := NewMemory()
+	idx.Index(ctx, Document{Type: TypeItem, ID: 1, Name: "Garden tools", Description: "A rake and a shovel"})
+	idx.Index(ctx, Document{Type: TypeItem, ID: 2, Name: "Kitchen", Description: "Knives, a garden hose adapter"})
+	idx.Index(ctx, Document{Type: TypeCollection, ID: 1, Name: "Misc", Description: "Nothing relevant"})
+
+	results, err := idx.Search(ctx, "garden", 10)
+	if err != nil {
+		t.Fatalf("Search returned error: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %+v", results)
+	}
+	if results[0].ID != 1 || results[1].ID != 2 {
+		t.Fatalf("expected name match to rank first, got %+v", results)
+	}
+	if results[0].Score <= results[1].Score {
+		t.Fatalf("expected decreasing scores, got %+v", results)
+	}
+
+	if results, _ := idx.Search(ctx, "garden", 1); len(results) != 1 {
+		t.Fatalf("expected limit to apply, got %+v", results)
+	}
+	if results, _ := idx.Search(ctx, "nomatch", 10); len(results) != 0 {
+		t.Fatalf("expected no results, got %+v", results)
+	}
+}
+
+func TestSnippets(t *testing.T) {
+	doc := Document{
+		Name:        "Shovel",
+		Description: "This description is long enough that the excerpt has to cut the text before the shovel and also after it, because the text keeps going on and on.",
+	}
+	got := snippets(doc, []string{"shovel"})
+	if len(got) != 2 {
+		t.Fatalf("expected snippets for name and description, got %+v", got)
+	}
+	if !reflect.DeepEqual(got[0], Snippet{Field: "name", Text: "Shovel", Highlights: []Range{{0, 6}}}) {
+		t.Fatalf("unexpected name snippet: %+v", got[0])
+	}
+	desc := got[1]
+	if desc.Field != "description" || len(desc.Highlights) != 1 {
+		t.Fatalf("unexpected description snippet: %+v", desc)
+	}
+	h := desc.Highlights[0]
+	if desc.Text[h.Start:h.End] != "shovel" {
+		t.Fatalf("highlight %v does not cover the match in %q", h, desc.Text)
+	}
+	if desc.Text[:len("…")] != "…" || desc.Text[len(desc.Text)-len("…"):] != "…" {
+		t.Fatalf("expected an elided excerpt, got %q", desc.Text)
+	}
+}
+
+func TestSyncAndRebuild(t *testing.T) {
+	ctx := context.Background()
+	mem := store.NewMemory()
+	existing, _ := mem.CreateCollection(ctx, "Existing garden", "")
+
+	idx := NewMemory()
+	if err := Rebuild(ctx, idx, mem); err != nil {
+		t.Fatalf("Rebuild returned error: %v", err)
+	}
+	s := Sync(mem, idx)
+
+	item, _ := s.CreateItem(ctx, "Rake", "garden")
+	results, _ := idx.Search(ctx, "garden", 10)
+	if len(results) != 2 {
+		t.Fatalf("expected rebuilt and created documents, got %+v", results)
+	}
+
+	s.UpdateItem(ctx, item.ID, "Rake", "shed", 0)
+	if results, _ := idx.Search(ctx, "shed", 10); len(results) != 1 || results[0].ID != item.ID {
+		t.Fatalf("expected update to be indexed, got %+v", results)
+	}
+	if results, _ := idx.Search(ctx, "garden", 10); len(results) != 1 || results[0].Type != TypeCollection {
+		t.Fatalf("expected stale terms to be dropped, got %+v", results)
+	}
+
+	s.DeleteCollection(ctx, existing.ID, 0)
+	s.DeleteItem(ctx, item.ID, 0)
+	if results, _ := idx.Search(ctx, "garden shed", 10); len(results) != 0 {
+		t.Fatalf("expected deletes to be removed from the index, got %+v", results)
+	}
+}
+
+func TestSyncWithTx(t *testing.T) {
+	ctx := context.Background()
+	idx := NewMemory()
+	s := Sync(store.NewMemory(), idx)
+
+	s.WithTx(ctx, func(tx store.Store) error {
+		tx.CreateItem(ctx, "Trowel", "")
+		return errors.New("abort")
+	})
+	if results, _ := idx.Search(ctx, "trowel", 10); len(results) != 0 {
+		t.Fatalf("expected rolled back write not to be indexed, got %+v", results)
+	}
+
+	err := s.WithTx(ctx, func(tx store.Store) error {
+		if _, err := tx.CreateItem(ctx, "Trowel", ""); err != nil {
+			return err
+		}
+		if results, _ := idx.Search(ctx, "trowel", 10); len(results) != 0 {
+			t.Errorf("expected indexing to wait for commit, got %+v", results)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithTx returned error: %v", err)
+	}
+	if results, _ := idx.Search(ctx, "trowel", 10); len(results) != 1 {
+		t.Fatalf("expected committed write to be indexed, got %+v", results)
+	}
+}
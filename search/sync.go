@@ -0,0 +1,225 @@
+package search
+
+import (
+	"context"
+	"log"
+
+	"github.com/mmontes11/opencode-test/store"
+)
+
+// syncedStore mirrors successful store writes into an Index. Reads are served
+// by the embedded Store unchanged.
+type syncedStore struct {
+	store.Store
+	idx Index
+	// pending is set inside WithTx. Index updates are queued there and
+	// applied only once the transaction commits.
+	pending *[]func(ctx context.Context)
+}
+
+// Sync returns a Store that keeps idx up to date with every write made
+// through it. Index failures are logged rather than returned, since the
+// write itself has already been committed.
+func Sync(s store.Store, idx Index) store.Store {
+	return &syncedStore{Store: s, idx: idx}
+}
+
+// Rebuild indexes every item and collection in s. It is used to populate an
+// in-process index at startup.
+func Rebuild(ctx context.Context, idx Index, s store.Store) error {
+	opts := store.ListOptions{Limit: 500}
+	for {
+		items, next, err := s.ListItems(ctx, opts)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if err := idx.Index(ctx, itemDocument(&item)); err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			break
+		}
+		opts.Cursor = next
+	}
+	opts = store.ListOptions{Limit: 500}
+	for {
+		cols, next, err := s.ListCollections(ctx, opts)
+		if err != nil {
+			return err
+		}
+		for _, col := range cols {
+			if err := idx.Index(ctx, collectionDocument(&col)); err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		opts.Cursor = next
+	}
+}
+
+func itemDocument(item *store.Item) Document {
+	return Document{Type: TypeItem, ID: item.ID, Name: item.Name, Description: item.Description}
+}
+
+func collectionDocument(col *store.Collection) Document {
+	return Document{Type: TypeCollection, ID: col.ID, Name: col.Name, Description: col.Description}
+}
+
+// apply runs an index update now, or queues it until commit inside WithTx.
+// Updates run with a context that is not canceled, since the store write
+// they mirror has already happened.
+func (s *syncedStore) apply(ctx context.Context, op func(ctx context.Context)) {
+	if s.pending != nil {
+		*s.pending = append(*s.pending, op)
+		return
+	}
+	op(context.WithoutCancel(ctx))
+}
+
+func (s *syncedStore) index(ctx context.Context, doc Document) {
+	s.apply(ctx, func(ctx context.Context) {
+		if err := s.idx.Index(ctx, doc); err != nil {
+			log.Printf("search: index %s %d: %v", doc.Type, doc.ID, err)
+		}
+	})
+}
+
+func (s *syncedStore) remove(ctx context.Context, docType string, id int64) {
+	s.apply(ctx, func(ctx context.Context) {
+		if err := s.idx.Remove(ctx, docType, id); err != nil {
+			log.Printf("search: remove %s %d: %v", docType, id, err)
+		}
+	})
+}
+
+func (s *syncedStore) WithTx(ctx context.Context, fn func(tx store.Store) error) error {
+	if s.pending != nil {
+		// Already in a transaction; the outermost WithTx applies the queue.
+		return s.Store.WithTx(ctx, func(tx store.Store) error {
+			return fn(&syncedStore{Store: tx, idx: s.idx, pending: s.pending})
+		})
+	}
+	var pending []func(ctx context.Context)
+	err := s.Store.WithTx(ctx, func(tx store.Store) error {
+		return fn(&syncedStore{Store: tx, idx: s.idx, pending: &pending})
+	})
+	if err != nil {
+		return err
+	}
+	for _, op := range pending {
+		op(context.WithoutCancel(ctx))
+	}
+	return nil
+}
+
+func (s *syncedStore) CreateItem(ctx context.Context, name, description string) (*store.Item, error) {
+	item, err := s.Store.CreateItem(ctx, name, description)
+	if err == nil {
+		s.index(ctx, itemDocument(item))
+	}
+	return item, err
+}
+
+func (s *syncedStore) UpdateItem(ctx context.Context, id int64, name, description string, version int64) (*store.Item, error) {
+	item, err := s.Store.UpdateItem(ctx, id, name, description, version)
+	if err == nil {
+		s.index(ctx, itemDocument(item))
+	}
+	return item, err
+}
+
+func (s *syncedStore) PatchItem(ctx context.Context, id int64, p store.Patch, version int64) (*store.Item, error) {
+	item, err := s.Store.PatchItem(ctx, id, p, version)
+	if err == nil {
+		s.index(ctx, itemDocument(item))
+	}
+	return item, err
+}
+
+func (s *syncedStore) DeleteItem(ctx context.Context, id int64, version int64) error {
+	err := s.Store.DeleteItem(ctx, id, version)
+	if err == nil {
+		s.remove(ctx, TypeItem, id)
+	}
+	return err
+}
+
+func (s *syncedStore) CreateCollection(ctx context.Context, name, description string) (*store.Collection, error) {
+	col, err := s.Store.CreateCollection(ctx, name, description)
+	if err == nil {
+		s.index(ctx, collectionDocument(col))
+	}
+	return col, err
+}
+
+func (s *syncedStore) CreateSmartCollection(ctx context.Context, name, description, rule string) (*store.Collection, error) {
+	col, err := s.Store.CreateSmartCollection(ctx, name, description, rule)
+	if err == nil {
+		s.index(ctx, collectionDocument(col))
+	}
+	return col, err
+}
+
+func (s *syncedStore) UpdateCollection(ctx context.Context, id int64, name, description string, version int64) (*store.Collection, error) {
+	col, err := s.Store.UpdateCollection(ctx, id, name, description, version)
+	if err == nil {
+		s.index(ctx, collectionDocument(col))
+	}
+	return col, err
+}
+
+func (s *syncedStore) PatchCollection(ctx context.Context, id int64, p store.Patch, version int64) (*store.Collection, error) {
+	col, err := s.Store.PatchCollection(ctx, id, p, version)
+	if err == nil {
+		s.index(ctx, collectionDocument(col))
+	}
+	return col, err
+}
+
+func (s *syncedStore) DeleteCollection(ctx context.Context, id int64, version int64) error {
+	err := s.Store.DeleteCollection(ctx, id, version)
+	if err == nil {
+		s.remove(ctx, TypeCollection, id)
+	}
+	return err
+}
+
+func (s *syncedStore) DeleteCollectionTree(ctx context.Context, id int64, version int64) ([]int64, error) {
+	ids, err := s.Store.DeleteCollectionTree(ctx, id, version)
+	if err == nil {
+		for _, id := range ids {
+			s.remove(ctx, TypeCollection, id)
+		}
+	}
+	return ids, err
+}
+
+func (s *syncedStore) RestoreItem(ctx context.Context, id int64, version int64) (*store.Item, error) {
+	item, err := s.Store.RestoreItem(ctx, id, version)
+	if err == nil {
+		s.index(ctx, itemDocument(item))
+	}
+	return item, err
+}
+
+// RestoreCollection also indexes the descendants restored along with the
+// collection.
+func (s *syncedStore) RestoreCollection(ctx context.Context, id int64, version int64) (*store.Collection, error) {
+	col, err := s.Store.RestoreCollection(ctx, id, version)
+	if err != nil {
+		return nil, err
+	}
+	cols, err := s.Store.CollectionSubtree(ctx, id)
+	if err != nil {
+		log.Printf("search: subtree of collection %d: %v", id, err)
+		cols = []store.Collection{*col}
+	}
+	for i := range cols {
+		s.index(ctx, collectionDocument(&cols[i]))
+	}
+	return col, nil
+}
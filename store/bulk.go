@@ -0,0 +1,302 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MembershipStatus is the outcome of a bulk membership change for one item.
+type MembershipStatus string
+
+const (
+	MembershipAdded          MembershipStatus = "added"
+	MembershipAlreadyPresent MembershipStatus = "already_present"
+	MembershipRemoved        MembershipStatus = "removed"
+	MembershipNotPresent     MembershipStatus = "not_present"
+	MembershipMissingItem    MembershipStatus = "missing_item"
+	// MembershipInvalidFields reports an item whose custom fields do not
+	// match the collection's schema. It is not added.
+	MembershipInvalidFields MembershipStatus = "invalid_fields"
+)
+
+// MembershipResult reports what a bulk membership change did to one item.
+type MembershipResult struct {
+	ItemID int64
+	Status MembershipStatus
+}
+
+// uniqueIDs returns ids without duplicates, keeping the first occurrence.
+func uniqueIDs(ids []int64) []int64 {
+	seen := make(map[int64]bool, len(ids))
+	out := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// placeholders returns n comma-separated bind parameters of the given shape,
+// e.g. placeholders(2, "(?, ?)") is "(?, ?), (?, ?)".
+func placeholders(n int, shape string) string {
+	return strings.TrimSuffix(strings.Repeat(shape+", ", n), ", ")
+}
+
+// idArgs prepends args to ids, as query arguments.
+func idArgs(ids []int64, args ...any) []any {
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	return args
+}
+
+// selectIDs runs a query returning a single ID column and collects the IDs
+// into a set.
+func selectIDs(ctx context.Context, q Querier, query string, args ...any) (map[int64]bool, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// existingItems returns which of ids are live items, share-locking them.
+func existingItems(ctx context.Context, q Querier, ids []int64) (map[int64]bool, error) {
+	if len(ids) == 0 {
+		return map[int64]bool{}, nil
+	}
+	return selectIDs(ctx, q, "SELECT id FROM items WHERE id IN ("+placeholders(len(ids), "?")+") AND deleted_at IS NULL LOCK IN SHARE MODE", idArgs(ids)...)
+}
+
+// presentMembers returns which of ids are members of the collection,
+// locking their rows.
+func presentMembers(ctx context.Context, q Querier, collectionID int64, ids []int64) (map[int64]bool, error) {
+	if len(ids) == 0 {
+		return map[int64]bool{}, nil
+	}
+	return selectIDs(ctx, q, "SELECT item_id FROM collection_items WHERE collection_id = ? AND item_id IN ("+placeholders(len(ids), "?")+") FOR UPDATE", idArgs(ids, collectionID)...)
+}
+
+// insertMembers appends the items to the collection in one statement.
+func insertMembers(ctx context.Context, q Querier, collectionID int64, itemIDs []int64) error {
+	if len(itemIDs) == 0 {
+		return nil
+	}
+	var last int64
+	if err := q.QueryRowContext(ctx, "SELECT COALESCE(MAX(position), 0) FROM collection_items WHERE collection_id = ?", collectionID).Scan(&last); err != nil {
+		return err
+	}
+	args := make([]any, 0, 3*len(itemIDs))
+	for i, id := range itemIDs {
+		args = append(args, collectionID, id, last+int64(i+1)*positionGap)
+	}
+	_, err := q.ExecContext(ctx, "INSERT IGNORE INTO collection_items (collection_id, item_id, position) VALUES "+placeholders(len(itemIDs), "(?, ?, ?)"), args...)
+	return err
+}
+
+// orderMembers makes the items the members of the collection and ranks
+// them in the given order, in one statement.
+func orderMembers(ctx context.Context, q Querier, collectionID int64, itemIDs []int64) error {
+	if len(itemIDs) == 0 {
+		return nil
+	}
+	args := make([]any, 0, 3*len(itemIDs))
+	for i, id := range itemIDs {
+		args = append(args, collectionID, id, int64(i+1)*positionGap)
+	}
+	_, err := q.ExecContext(ctx, "INSERT INTO collection_items (collection_id, item_id, position) VALUES "+placeholders(len(itemIDs), "(?, ?, ?)")+" ON DUPLICATE KEY UPDATE position = VALUES(position)", args...)
+	return err
+}
+
+// existingInOrder returns the IDs that are in existing, in the order of
+// ids, except those in invalid that are not in present.
+func existingInOrder(ids []int64, existing, present map[int64]bool, invalid map[int64]error) []int64 {
+	var out []int64
+	for _, id := range ids {
+		if existing[id] && (present[id] || invalid[id] == nil) {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// deleteMembers removes the items from the collection in one statement.
+func deleteMembers(ctx context.Context, q Querier, collectionID int64, itemIDs []int64) error {
+	if len(itemIDs) == 0 {
+		return nil
+	}
+	_, err := q.ExecContext(ctx, "DELETE FROM collection_items WHERE collection_id = ? AND item_id IN ("+placeholders(len(itemIDs), "?")+")", idArgs(itemIDs, collectionID)...)
+	return err
+}
+
+// bumpMembers bumps members_updated_at of a collection if changed is set.
+func bumpMembers(ctx context.Context, q Querier, collectionID int64, changed bool, now time.Time) error {
+	if !changed {
+		return nil
+	}
+	_, err := q.ExecContext(ctx, "UPDATE collections SET members_updated_at = ? WHERE id = ?", now, collectionID)
+	return err
+}
+
+// AddItemsToCollection adds the items to a collection in one transaction and
+// reports per item whether it was added, already present, missing or
+// rejected by the collection's schema. It returns ErrNotFound if the
+// collection does not exist.
+func AddItemsToCollection(ctx context.Context, q Querier, collectionID int64, itemIDs []int64, now time.Time) ([]MembershipResult, error) {
+	itemIDs = uniqueIDs(itemIDs)
+	var results []MembershipResult
+	err := withTx(ctx, q, func(q Querier) error {
+		if err := mustBeManual(ctx, q, collectionID, "LOCK IN SHARE MODE"); err != nil {
+			return err
+		}
+		existing, err := existingItems(ctx, q, itemIDs)
+		if err != nil {
+			return err
+		}
+		present, err := presentMembers(ctx, q, collectionID, itemIDs)
+		if err != nil {
+			return err
+		}
+		invalid, err := schemaMismatches(ctx, q, collectionID, itemIDs)
+		if err != nil {
+			return err
+		}
+		var added []int64
+		results = addResults(itemIDs, existing, present, invalid, &added)
+		if err := insertMembers(ctx, q, collectionID, added); err != nil {
+			return err
+		}
+		return bumpMembers(ctx, q, collectionID, len(added) > 0, now)
+	})
+	return results, err
+}
+
+// RemoveItemsFromCollection removes the items from a collection in one
+// transaction and reports per item whether it was removed or not present.
+// It returns ErrNotFound if the collection does not exist.
+func RemoveItemsFromCollection(ctx context.Context, q Querier, collectionID int64, itemIDs []int64, now time.Time) ([]MembershipResult, error) {
+	itemIDs = uniqueIDs(itemIDs)
+	var results []MembershipResult
+	err := withTx(ctx, q, func(q Querier) error {
+		if err := mustBeManual(ctx, q, collectionID, "LOCK IN SHARE MODE"); err != nil {
+			return err
+		}
+		present, err := presentMembers(ctx, q, collectionID, itemIDs)
+		if err != nil {
+			return err
+		}
+		var removed []int64
+		results = removeResults(itemIDs, present, &removed)
+		if err := deleteMembers(ctx, q, collectionID, removed); err != nil {
+			return err
+		}
+		return bumpMembers(ctx, q, collectionID, len(removed) > 0, now)
+	})
+	return results, err
+}
+
+// ReplaceCollectionItems makes the items the exact members of a collection,
+// in the given order, in one transaction. The results list the requested
+// items as for AddItemsToCollection, followed by the former members that
+// were removed. It returns ErrNotFound if the collection does not exist.
+func ReplaceCollectionItems(ctx context.Context, q Querier, collectionID int64, itemIDs []int64, now time.Time) ([]MembershipResult, error) {
+	itemIDs = uniqueIDs(itemIDs)
+	var results []MembershipResult
+	err := withTx(ctx, q, func(q Querier) error {
+		if err := mustBeManual(ctx, q, collectionID, "LOCK IN SHARE MODE"); err != nil {
+			return err
+		}
+		existing, err := existingItems(ctx, q, itemIDs)
+		if err != nil {
+			return err
+		}
+		present, err := selectIDs(ctx, q, "SELECT item_id FROM collection_items WHERE collection_id = ? FOR UPDATE", collectionID)
+		if err != nil {
+			return err
+		}
+		invalid, err := schemaMismatches(ctx, q, collectionID, itemIDs)
+		if err != nil {
+			return err
+		}
+		var added, removed []int64
+		results = replaceResults(itemIDs, existing, present, invalid, &added, &removed)
+		if err := deleteMembers(ctx, q, collectionID, removed); err != nil {
+			return err
+		}
+		if err := orderMembers(ctx, q, collectionID, existingInOrder(itemIDs, existing, present, invalid)); err != nil {
+			return err
+		}
+		return bumpMembers(ctx, q, collectionID, len(added)+len(removed) > 0 || len(existing) > 0, now)
+	})
+	return results, err
+}
+
+// The functions below compute the results of the bulk operations from the
+// requested IDs, the IDs that are items and the current members. They are
+// shared with the Memory store and append the IDs to write to the given
+// slices. invalid holds the items that do not match the collection's schema.
+
+func addResults(itemIDs []int64, existing, present map[int64]bool, invalid map[int64]error, added *[]int64) []MembershipResult {
+	results := make([]MembershipResult, len(itemIDs))
+	for i, id := range itemIDs {
+		status := MembershipAdded
+		switch {
+		case !existing[id]:
+			status = MembershipMissingItem
+		case present[id]:
+			status = MembershipAlreadyPresent
+		case invalid[id] != nil:
+			status = MembershipInvalidFields
+		default:
+			*added = append(*added, id)
+		}
+		results[i] = MembershipResult{ItemID: id, Status: status}
+	}
+	return results
+}
+
+func removeResults(itemIDs []int64, present map[int64]bool, removed *[]int64) []MembershipResult {
+	results := make([]MembershipResult, len(itemIDs))
+	for i, id := range itemIDs {
+		status := MembershipNotPresent
+		if present[id] {
+			status = MembershipRemoved
+			*removed = append(*removed, id)
+		}
+		results[i] = MembershipResult{ItemID: id, Status: status}
+	}
+	return results
+}
+
+func replaceResults(itemIDs []int64, existing, present map[int64]bool, invalid map[int64]error, added, removed *[]int64) []MembershipResult {
+	results := addResults(itemIDs, existing, present, invalid, added)
+	keep := make(map[int64]bool, len(itemIDs))
+	for _, id := range itemIDs {
+		keep[id] = true
+	}
+	var gone []int64
+	for id := range present {
+		if !keep[id] {
+			gone = append(gone, id)
+		}
+	}
+	slices.Sort(gone)
+	for _, id := range gone {
+		*removed = append(*removed, id)
+		results = append(results, MembershipResult{ItemID: id, Status: MembershipRemoved})
+	}
+	return results
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Domain errors returned by Store implementations. Callers should test for
+// them with errors.Is, since they are usually wrapped with more detail.
+var (
+	// ErrNotFound means the referenced item or collection does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict means the write conflicts with the current state.
+	ErrConflict = errors.New("conflict")
+	// ErrValidation means the input was rejected by the store.
+	ErrValidation = errors.New("validation failed")
+	// ErrPreconditionFailed means the caller's version of a row is stale.
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+// ErrInvalidCursor is returned by list operations when the cursor was not
+// produced by a previous page with the same sort order.
+var ErrInvalidCursor = fmt.Errorf("invalid cursor: %w", ErrValidation)
+
+// notFound reports a missing row of the given kind, e.g. "item".
+func notFound(kind string, id int64) error {
+	return fmt.Errorf("%s %d: %w", kind, id, ErrNotFound)
+}
+
+// preconditionFailed reports a stale version of a row of the given kind.
+func preconditionFailed(kind string, id, current int64) error {
+	return fmt.Errorf("%s %d is at version %d: %w", kind, id, current, ErrPreconditionFailed)
+}
+
+// MySQL error numbers translated by dbError.
+const (
+	mysqlDuplicateEntry = 1062
+)
+
+// dbError translates driver errors into domain errors. sql.ErrNoRows becomes
+// a not found error for the given row. A duplicate key becomes a conflict
+// that names only the row, since domain errors reach clients; the driver's
+// message, with its key and constraint names, is logged instead.
+func dbError(err error, kind string, id int64) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFound(kind, id)
+	}
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) && myErr.Number == mysqlDuplicateEntry {
+		log.Printf("%s %d: %v", kind, id, myErr)
+		return fmt.Errorf("%s %d: %w with existing data", kind, id, ErrConflict)
+	}
+	return err
+}
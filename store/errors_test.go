@@ -0,0 +1,21 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestDBErrorHidesDriverDetails(t *testing.T) {
+	driverErr := &mysql.MySQLError{Number: mysqlDuplicateEntry, Message: "Duplicate entry 'x' for key 'uniq_collections_name'"}
+	err := dbError(fmt.Errorf("insert: %w", driverErr), "collection", 7)
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("dbError = %v, want ErrConflict", err)
+	}
+	if strings.Contains(err.Error(), "uniq_collections_name") || strings.Contains(err.Error(), "Duplicate") {
+		t.Fatalf("dbError = %q, leaks the driver message", err)
+	}
+}
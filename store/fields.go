@@ -0,0 +1,583 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mmontes11/opencode-test/store/filter"
+)
+
+// FieldType is the type of a custom field declared by a collection's schema.
+type FieldType string
+
+const (
+	FieldString FieldType = "string"
+	FieldNumber FieldType = "number"
+	FieldBool   FieldType = "bool"
+	// FieldDate values are strings in the form 2006-01-02.
+	FieldDate FieldType = "date"
+	// FieldEnum values are strings from the declared values.
+	FieldEnum FieldType = "enum"
+	// FieldURL values are absolute http or https URLs.
+	FieldURL FieldType = "url"
+)
+
+const (
+	// MaxFieldValueLength is the maximum length of a custom field's text
+	// value in characters.
+	MaxFieldValueLength = 1024
+	// MaxFields is the largest number of custom fields an item may carry
+	// and a schema may declare.
+	MaxFields = 50
+	// MaxEnumValues is the largest number of values an enum field may
+	// declare.
+	MaxEnumValues = 100
+)
+
+// FieldDef declares a custom field in a collection's schema. Schemas are
+// stored as JSON.
+type FieldDef struct {
+	Name     string    `json:"name"`
+	Type     FieldType `json:"type"`
+	Required bool      `json:"required,omitempty"`
+	// Values lists the allowed values of an enum field.
+	Values []string `json:"values,omitempty"`
+}
+
+// validateSchema returns ErrValidation unless the fields have valid,
+// distinct names and valid types.
+func validateSchema(schema []FieldDef) error {
+	if len(schema) > MaxFields {
+		return fmt.Errorf("%w: a schema must not declare more than %d fields", ErrValidation, MaxFields)
+	}
+	seen := make(map[string]bool, len(schema))
+	for _, def := range schema {
+		if !filter.ValidCustomName(def.Name) {
+			return fmt.Errorf("%w: invalid field name %q", ErrValidation, def.Name)
+		}
+		if seen[def.Name] {
+			return fmt.Errorf("%w: field %q is declared twice", ErrValidation, def.Name)
+		}
+		seen[def.Name] = true
+		switch def.Type {
+		case FieldString, FieldNumber, FieldBool, FieldDate, FieldURL:
+			if len(def.Values) > 0 {
+				return fmt.Errorf("%w: field %q: only enum fields have values", ErrValidation, def.Name)
+			}
+		case FieldEnum:
+			if len(def.Values) == 0 || len(def.Values) > MaxEnumValues {
+				return fmt.Errorf("%w: field %q must declare between 1 and %d values", ErrValidation, def.Name, MaxEnumValues)
+			}
+			for i, v := range def.Values {
+				if v == "" || utf8.RuneCountInString(v) > MaxFieldValueLength || slices.Contains(def.Values[:i], v) {
+					return fmt.Errorf("%w: field %q: invalid or repeated value %q", ErrValidation, def.Name, v)
+				}
+			}
+		default:
+			return fmt.Errorf("%w: field %q has unknown type %q", ErrValidation, def.Name, def.Type)
+		}
+	}
+	return nil
+}
+
+// validateFields returns ErrValidation unless the custom fields have valid
+// names and string, finite number or boolean values. With patch set, nil
+// values are allowed; they remove the field.
+func validateFields(fields map[string]any, patch bool) error {
+	if len(fields) > MaxFields {
+		return fmt.Errorf("%w: an item must not have more than %d fields", ErrValidation, MaxFields)
+	}
+	for name, v := range fields {
+		if !filter.ValidCustomName(name) {
+			return fmt.Errorf("%w: invalid field name %q", ErrValidation, name)
+		}
+		switch v := v.(type) {
+		case nil:
+			if !patch {
+				return fmt.Errorf("%w: field %q must not be null", ErrValidation, name)
+			}
+		case string:
+			if utf8.RuneCountInString(v) > MaxFieldValueLength {
+				return fmt.Errorf("%w: field %q is longer than %d characters", ErrValidation, name, MaxFieldValueLength)
+			}
+		case float64:
+			if math.IsInf(v, 0) || math.IsNaN(v) {
+				return fmt.Errorf("%w: field %q must be a finite number", ErrValidation, name)
+			}
+		case bool:
+		default:
+			return fmt.Errorf("%w: field %q must be a string, number or boolean", ErrValidation, name)
+		}
+	}
+	return nil
+}
+
+// checkSchema reports why the fields do not match schema, or returns nil.
+func checkSchema(schema []FieldDef, fields map[string]any) error {
+	for _, def := range schema {
+		v, ok := fields[def.Name]
+		if !ok {
+			if def.Required {
+				return fmt.Errorf("field %q is required", def.Name)
+			}
+			continue
+		}
+		s, isString := v.(string)
+		switch def.Type {
+		case FieldNumber:
+			if _, ok := v.(float64); !ok {
+				return fmt.Errorf("field %q must be a number", def.Name)
+			}
+			continue
+		case FieldBool:
+			if _, ok := v.(bool); !ok {
+				return fmt.Errorf("field %q must be a boolean", def.Name)
+			}
+			continue
+		}
+		if !isString {
+			return fmt.Errorf("field %q must be a string", def.Name)
+		}
+		switch def.Type {
+		case FieldDate:
+			if _, err := time.Parse("2006-01-02", s); err != nil {
+				return fmt.Errorf("field %q must be a date like 2006-01-02", def.Name)
+			}
+		case FieldEnum:
+			if !slices.Contains(def.Values, s) {
+				return fmt.Errorf("field %q must be one of %s", def.Name, strings.Join(def.Values, ", "))
+			}
+		case FieldURL:
+			if u, err := url.Parse(s); err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+				return fmt.Errorf("field %q must be an http or https URL", def.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// schemaMismatch reports that an item's fields do not match the schema of
+// a collection, with the given sentinel error.
+func schemaMismatch(sentinel error, itemID, collectionID int64, reason error) error {
+	return fmt.Errorf("%w: item %d does not match the schema of collection %d: %v", sentinel, itemID, collectionID, reason)
+}
+
+// fieldText is the text form of a custom field value, which filters match
+// and compare as text.
+func fieldText(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
+
+// fieldSortKey encodes a custom field value so that the keys of booleans,
+// numbers and text compare in that order, and each in its natural order.
+// Missing values have the empty key and sort first.
+func fieldSortKey(v any) string {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return "b1"
+		}
+		return "b0"
+	case float64:
+		// Flipping the sign bit of positive numbers and all bits of
+		// negative ones makes the bits order like the numbers.
+		bits := math.Float64bits(v)
+		if v < 0 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+		return fmt.Sprintf("n%016x", bits)
+	case string:
+		return "s" + strings.ToLower(v)
+	}
+	return ""
+}
+
+// fieldType is the JSON type of a custom field value, as stored in the
+// type column of item_fields.
+func fieldType(v any) string {
+	switch v.(type) {
+	case float64:
+		return "number"
+	case bool:
+		return "bool"
+	}
+	return "string"
+}
+
+// parseField is the inverse of fieldType and fieldText.
+func parseField(typ, text string) any {
+	switch typ {
+	case "number":
+		f, _ := strconv.ParseFloat(text, 64)
+		return f
+	case "bool":
+		return text == "true"
+	}
+	return text
+}
+
+// matchCustom reports whether a custom field value, which is missing if ok
+// is false, satisfies a comparison. Numbers compare numerically with
+// numeric values, everything else compares as case-insensitive text, and
+// missing values only satisfy "!=" and "!~".
+func matchCustom(e *filter.Comparison, v any, ok bool) bool {
+	op, negate := e.Op, false
+	switch op {
+	case filter.Ne:
+		op, negate = filter.Eq, true
+	case filter.NotContains:
+		op, negate = filter.Contains, true
+	}
+	if !ok {
+		return negate
+	}
+	var match bool
+	if op == filter.Contains {
+		match = strings.Contains(strings.ToLower(fieldText(v)), strings.ToLower(e.Value))
+	} else if n, isNumber := v.(float64); isNumber {
+		want, err := strconv.ParseFloat(e.Value, 64)
+		match = err == nil && compareOp(cmp.Compare(n, want), op)
+	} else {
+		match = compareOp(strings.Compare(strings.ToLower(fieldText(v)), strings.ToLower(e.Value)), op)
+	}
+	return match != negate
+}
+
+// customCondition compiles a comparison of a custom field of the item whose
+// ID is ref, with the semantics of matchCustom.
+func customCondition(e *filter.Comparison, ref string) (string, []any) {
+	name, _ := filter.CustomName(e.Field)
+	op, negate := e.Op, false
+	switch op {
+	case filter.Ne:
+		op, negate = filter.Eq, true
+	case filter.NotContains:
+		op, negate = filter.Contains, true
+	}
+	var cond string
+	args := []any{name}
+	if op == filter.Contains {
+		cond = "f.value LIKE ?"
+		args = append(args, "%"+escapeLike(e.Value)+"%")
+	} else {
+		// A NULL number never compares, so numbers only match numeric
+		// values.
+		var number any
+		if n, err := strconv.ParseFloat(e.Value, 64); err == nil {
+			number = n
+		}
+		cond = "IF(f.number IS NULL, f.value " + string(op) + " ?, f.number " + string(op) + " ?)"
+		args = append(args, e.Value, number)
+	}
+	cond = "EXISTS (SELECT 1 FROM item_fields f WHERE f.item_id = " + ref + " AND f.name = ? AND " + cond + ")"
+	if negate {
+		cond = "NOT " + cond
+	}
+	return cond, args
+}
+
+// customSortColumn returns the sort key of a custom field of the item whose
+// ID is ref. The name is inlined, which is safe because the filter package
+// only admits names of letters, digits and underscores.
+func customSortColumn(name, ref string) string {
+	return "COALESCE((SELECT f.sort_key FROM item_fields f WHERE f.item_id = " + ref + " AND f.name = '" + name + "'), '')"
+}
+
+// fieldsOf returns the custom fields of the items, keyed by ID.
+func fieldsOf(ctx context.Context, q Querier, ids []int64) (map[int64]map[string]any, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	rows, err := q.QueryContext(ctx, "SELECT item_id, name, type, value FROM item_fields WHERE item_id IN ("+placeholders(len(ids), "?")+")", idArgs(ids)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	fields := make(map[int64]map[string]any)
+	for rows.Next() {
+		var id int64
+		var name, typ, text string
+		if err := rows.Scan(&id, &name, &typ, &text); err != nil {
+			return nil, err
+		}
+		if fields[id] == nil {
+			fields[id] = make(map[string]any)
+		}
+		fields[id][name] = parseField(typ, text)
+	}
+	return fields, rows.Err()
+}
+
+// loadItemFields fills in the custom fields of the items.
+func loadItemFields(ctx context.Context, q Querier, items []Item) error {
+	ids := make([]int64, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	fields, err := fieldsOf(ctx, q, ids)
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		items[i].Fields = fields[items[i].ID]
+	}
+	return nil
+}
+
+// scanSchema decodes the field_schema column of a collection.
+func scanSchema(s sql.NullString) ([]FieldDef, error) {
+	if s.String == "" {
+		return nil, nil
+	}
+	var schema []FieldDef
+	if err := json.Unmarshal([]byte(s.String), &schema); err != nil {
+		return nil, fmt.Errorf("invalid stored schema: %w", err)
+	}
+	return schema, nil
+}
+
+// encodeSchema is the inverse of scanSchema. An empty schema is stored as
+// NULL.
+func encodeSchema(schema []FieldDef) (any, error) {
+	if len(schema) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(schema)
+	return string(b), err
+}
+
+// schemaMismatches checks the custom fields of the items against the
+// schema of the collection, and returns why those that do not match fail.
+func schemaMismatches(ctx context.Context, q Querier, collectionID int64, ids []int64) (map[int64]error, error) {
+	var s sql.NullString
+	if err := q.QueryRowContext(ctx, "SELECT field_schema FROM collections WHERE id = ?", collectionID).Scan(&s); err != nil {
+		return nil, dbError(err, "collection", collectionID)
+	}
+	schema, err := scanSchema(s)
+	if err != nil || len(schema) == 0 {
+		return nil, err
+	}
+	fields, err := fieldsOf(ctx, q, ids)
+	if err != nil {
+		return nil, err
+	}
+	return mismatches(schema, ids, fields), nil
+}
+
+// mismatches returns why the fields of the items do not match schema,
+// keyed by the IDs of the items that do not.
+func mismatches(schema []FieldDef, ids []int64, fields map[int64]map[string]any) map[int64]error {
+	out := make(map[int64]error)
+	for _, id := range ids {
+		if err := checkSchema(schema, fields[id]); err != nil {
+			out[id] = err
+		}
+	}
+	return out
+}
+
+// firstMismatch returns ErrConflict for the first of the items, in the
+// order of ids, whose fields do not match the new schema of a collection.
+func firstMismatch(schema []FieldDef, ids []int64, fields map[int64]map[string]any, collectionID int64) error {
+	invalid := mismatches(schema, ids, fields)
+	for _, id := range ids {
+		if invalid[id] != nil {
+			return schemaMismatch(ErrConflict, id, collectionID, invalid[id])
+		}
+	}
+	return nil
+}
+
+// mustMatchSchema returns ErrConflict if the custom fields of the item do
+// not match the schema of the collection.
+func mustMatchSchema(ctx context.Context, q Querier, collectionID, itemID int64) error {
+	invalid, err := schemaMismatches(ctx, q, collectionID, []int64{itemID})
+	if err != nil || invalid[itemID] == nil {
+		return err
+	}
+	return schemaMismatch(ErrConflict, itemID, collectionID, invalid[itemID])
+}
+
+// ReplaceItemFields makes fields the custom fields of an item and returns
+// it. The item's version is incremented if that changes anything. It
+// returns ErrValidation if a field is malformed or the fields do not match
+// the schema of a collection containing the item, ErrNotFound if the item
+// does not exist and ErrPreconditionFailed if version is non-zero and not
+// the current one.
+func ReplaceItemFields(ctx context.Context, q Querier, id int64, fields map[string]any, version int64, now time.Time) (*Item, error) {
+	if err := validateFields(fields, false); err != nil {
+		return nil, err
+	}
+	return changeItemFields(ctx, q, id, version, now, func(map[string]any) map[string]any { return fields })
+}
+
+// PatchItemFields sets the custom fields of an item that are in fields,
+// removes those whose value is nil and returns the item, like
+// ReplaceItemFields.
+func PatchItemFields(ctx context.Context, q Querier, id int64, fields map[string]any, version int64, now time.Time) (*Item, error) {
+	if err := validateFields(fields, true); err != nil {
+		return nil, err
+	}
+	return changeItemFields(ctx, q, id, version, now, func(cur map[string]any) map[string]any {
+		return patchFields(cur, fields)
+	})
+}
+
+// patchFields returns cur with patch applied, without modifying either.
+func patchFields(cur, patch map[string]any) map[string]any {
+	out := maps.Clone(cur)
+	if out == nil {
+		out = make(map[string]any)
+	}
+	for name, v := range patch {
+		if v == nil {
+			delete(out, name)
+		} else {
+			out[name] = v
+		}
+	}
+	return out
+}
+
+// changeItemFields replaces the custom fields of an item by those change
+// computes from the current ones.
+func changeItemFields(ctx context.Context, q Querier, id, version int64, now time.Time, change func(cur map[string]any) map[string]any) (*Item, error) {
+	var item *Item
+	err := withTx(ctx, q, func(q Querier) error {
+		if err := lockVersion(ctx, q, "item", id, version); err != nil {
+			return err
+		}
+		cur, err := fieldsOf(ctx, q, []int64{id})
+		if err != nil {
+			return err
+		}
+		fields := change(cur[id])
+		if len(fields) > MaxFields {
+			return fmt.Errorf("%w: an item must not have more than %d fields", ErrValidation, MaxFields)
+		}
+		if !maps.Equal(fields, cur[id]) {
+			if err := checkItemSchemas(ctx, q, id, fields); err != nil {
+				return err
+			}
+			if err := writeItemFields(ctx, q, id, fields); err != nil {
+				return err
+			}
+			if _, err := q.ExecContext(ctx, "UPDATE items SET version = version + 1, updated_at = ? WHERE id = ?", now, id); err != nil {
+				return err
+			}
+			if err := touchItemCollections(ctx, q, id, now); err != nil {
+				return err
+			}
+		}
+		item, err = GetItem(ctx, q, id)
+		return err
+	})
+	return item, err
+}
+
+// checkItemSchemas returns ErrValidation unless the fields match the
+// schemas of all collections containing the item. The collections are
+// share-locked, so that their schemas cannot change meanwhile.
+func checkItemSchemas(ctx context.Context, q Querier, itemID int64, fields map[string]any) error {
+	rows, err := q.QueryContext(ctx, "SELECT c.id, c.field_schema FROM collections c JOIN collection_items ci ON c.id = ci.collection_id WHERE ci.item_id = ? AND c.field_schema IS NOT NULL ORDER BY c.id LOCK IN SHARE MODE", itemID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var colID int64
+		var s sql.NullString
+		if err := rows.Scan(&colID, &s); err != nil {
+			return err
+		}
+		schema, err := scanSchema(s)
+		if err != nil {
+			return err
+		}
+		if err := checkSchema(schema, fields); err != nil {
+			return schemaMismatch(ErrValidation, itemID, colID, err)
+		}
+	}
+	return rows.Err()
+}
+
+// writeItemFields replaces the item_fields rows of an item.
+func writeItemFields(ctx context.Context, q Querier, id int64, fields map[string]any) error {
+	if _, err := q.ExecContext(ctx, "DELETE FROM item_fields WHERE item_id = ?", id); err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	args := make([]any, 0, 6*len(fields))
+	for name, v := range fields {
+		var number any
+		if n, ok := v.(float64); ok {
+			number = n
+		}
+		args = append(args, id, name, fieldType(v), fieldText(v), number, fieldSortKey(v))
+	}
+	_, err := q.ExecContext(ctx, "INSERT INTO item_fields (item_id, name, type, value, number, sort_key) VALUES "+placeholders(len(fields), "(?, ?, ?, ?, ?, ?)"), args...)
+	return err
+}
+
+// SetCollectionSchema replaces the schema that the custom fields of a
+// collection's items must match, increments the collection's version and
+// returns it. An empty schema removes it. It returns ErrValidation for an
+// invalid schema, ErrConflict if a member of the collection does not match
+// it, ErrNotFound if the collection does not exist and
+// ErrPreconditionFailed if version is non-zero and not the current one.
+func SetCollectionSchema(ctx context.Context, q Querier, id int64, schema []FieldDef, version int64, now time.Time) (*Collection, error) {
+	if err := validateSchema(schema); err != nil {
+		return nil, err
+	}
+	encoded, err := encodeSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	var col *Collection
+	err = withTx(ctx, q, func(q Querier) error {
+		if err := lockVersion(ctx, q, "collection", id, version); err != nil {
+			return err
+		}
+		members, err := selectIDs(ctx, q, "SELECT item_id FROM collection_items WHERE collection_id = ? LOCK IN SHARE MODE", id)
+		if err != nil {
+			return err
+		}
+		ids := slices.Sorted(maps.Keys(members))
+		fields, err := fieldsOf(ctx, q, ids)
+		if err != nil {
+			return err
+		}
+		if err := firstMismatch(schema, ids, fields, id); err != nil {
+			return err
+		}
+		if _, err := q.ExecContext(ctx, "UPDATE collections SET field_schema = ?, version = version + 1, updated_at = ? WHERE id = ?", encoded, now, id); err != nil {
+			return err
+		}
+		col, err = GetCollection(ctx, q, id)
+		return err
+	})
+	return col, err
+}
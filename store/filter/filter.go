@@ -0,0 +1,495 @@
+// Package filter parses the filter and sort expressions accepted by the list
+// endpoints into an AST. The store package compiles the AST into
+// parameterized SQL, so values never reach a query as raw text.
+//
+// Filter grammar:
+//
+//	expr       = term { "or" term }
+//	term       = factor { "and" factor }
+//	factor     = "not" factor | "(" expr ")" | comparison
+//	comparison = field op value
+//	op         = "=" | "!=" | "~" | "!~" | "<" | "<=" | ">" | ">="
+//	value      = quoted-string | bare-word
+//
+// "~" is a case-insensitive substring match. The tag field only supports "="
+// and "!=", which test whether a row carries a tag or not. The custom fields
+// of items are named fields.<name>; they compare numerically with numbers
+// and as case-insensitive text otherwise. Time values may be
+// relative to the current time: now, or now followed by a signed duration in
+// seconds (s), minutes (m), hours (h), days (d) or weeks (w). Example:
+//
+//	name~"foo" and created_at>2026-01-01
+//	tag=urgent and created_at>=now-7d
+//	fields.priority>=3 and fields.status!=done
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Type is the type of a filterable field. It determines the operators the
+// field accepts and how its values are parsed.
+type Type int
+
+const (
+	String Type = iota
+	Time
+	// Tag matches rows that carry, or with "!=" lack, the given tag.
+	Tag
+	// Custom is the type of the custom fields of items. Their values may be
+	// strings, numbers or booleans, so the value of a comparison is kept as
+	// written.
+	Custom
+)
+
+// CustomPrefix starts the name of a custom field, as in fields.color.
+const CustomPrefix = "fields."
+
+// MaxCustomNameLength is the maximum length of the name of a custom field.
+const MaxCustomNameLength = 64
+
+// Fields maps field names to their types. A CustomPrefix entry of type
+// Custom admits every custom field.
+type Fields map[string]Type
+
+// lookup returns the type of a field.
+func (f Fields) lookup(field string) (Type, bool) {
+	if typ, ok := f[field]; ok && field != CustomPrefix {
+		return typ, true
+	}
+	name, ok := CustomName(field)
+	if !ok || f[CustomPrefix] != Custom {
+		return 0, false
+	}
+	return Custom, ValidCustomName(name)
+}
+
+// CustomName returns the name of a custom field without CustomPrefix, and
+// whether field is a custom field at all.
+func CustomName(field string) (string, bool) {
+	return strings.CutPrefix(field, CustomPrefix)
+}
+
+// ValidCustomName reports whether name is a valid custom field name: a
+// lowercase letter followed by lowercase letters, digits and underscores.
+func ValidCustomName(name string) bool {
+	if name == "" || len(name) > MaxCustomNameLength || name[0] < 'a' || name[0] > 'z' {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		c := name[i]
+		if c != '_' && (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// DefaultFields are the fields shared by items and collections.
+var DefaultFields = Fields{
+	"name":        String,
+	"description": String,
+	"created_at":  Time,
+	"updated_at":  Time,
+	"tag":         Tag,
+}
+
+// ItemFields are the fields of items: DefaultFields and the custom fields.
+var ItemFields = Fields{
+	"name":        String,
+	"description": String,
+	"created_at":  Time,
+	"updated_at":  Time,
+	"tag":         Tag,
+	CustomPrefix:  Custom,
+}
+
+// TimeLayout is the canonical layout of Time values in a Comparison.
+const TimeLayout = "2006-01-02 15:04:05"
+
+// Op is a comparison operator.
+type Op string
+
+const (
+	Eq          Op = "="
+	Ne          Op = "!="
+	Contains    Op = "~"
+	NotContains Op = "!~"
+	Lt          Op = "<"
+	Le          Op = "<="
+	Gt          Op = ">"
+	Ge          Op = ">="
+)
+
+var opsByType = map[Type][]Op{
+	String: {Eq, Ne, Contains, NotContains},
+	Time:   {Eq, Ne, Lt, Le, Gt, Ge},
+	Tag:    {Eq, Ne},
+	Custom: {Eq, Ne, Contains, NotContains, Lt, Le, Gt, Ge},
+}
+
+// Expr is a node of a filter AST: *And, *Or, *Not or *Comparison.
+type Expr interface {
+	expr()
+}
+
+// And matches when both operands match.
+type And struct{ Left, Right Expr }
+
+// Or matches when either operand matches.
+type Or struct{ Left, Right Expr }
+
+// Not matches when its operand does not.
+type Not struct{ Expr Expr }
+
+// Comparison compares a field with a literal value. Time values are
+// normalized to TimeLayout in UTC, and tags to lower case.
+type Comparison struct {
+	Field string
+	Type  Type
+	Op    Op
+	Value string
+}
+
+func (*And) expr()        {}
+func (*Or) expr()         {}
+func (*Not) expr()        {}
+func (*Comparison) expr() {}
+
+// Error describes an invalid filter or sort expression. Pos is the byte
+// offset at which the problem was detected.
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
+}
+
+// Parse parses a filter expression over the given fields. An empty string
+// yields a nil Expr, which matches everything. Relative times are resolved
+// against the current time.
+func Parse(s string, fields Fields) (Expr, error) {
+	return ParseAt(s, fields, time.Now())
+}
+
+// ParseAt is like Parse, but resolves relative times against now.
+func ParseAt(s string, fields Fields, now time.Time) (Expr, error) {
+	p := &parser{src: s, fields: fields, now: now}
+	p.skipSpace()
+	if p.pos == len(p.src) {
+		return nil, nil
+	}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos != len(p.src) {
+		return nil, p.errorf("unexpected %q", p.src[p.pos:])
+	}
+	return e, nil
+}
+
+type parser struct {
+	src    string
+	pos    int
+	fields Fields
+	now    time.Time
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return &Error{Pos: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+// keyword consumes kw if it appears next as a whole word.
+func (p *parser) keyword(kw string) bool {
+	p.skipSpace()
+	end := p.pos + len(kw)
+	if end > len(p.src) || !strings.EqualFold(p.src[p.pos:end], kw) {
+		return false
+	}
+	if end < len(p.src) && isIdentChar(p.src[end]) {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &Or{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		left = &And{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseFactor() (Expr, error) {
+	if p.keyword("not") {
+		e, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return &Not{Expr: e}, nil
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) && p.src[p.pos] == '(' {
+		p.pos++
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.pos >= len(p.src) || p.src[p.pos] != ')' {
+			return nil, p.errorf("expected \")\"")
+		}
+		p.pos++
+		return e, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (Expr, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) && (isIdentChar(p.src[p.pos]) || p.src[p.pos] == '.') {
+		p.pos++
+	}
+	if start == p.pos {
+		return nil, p.errorf("expected field name")
+	}
+	field := p.src[start:p.pos]
+	typ, ok := p.fields.lookup(field)
+	if !ok {
+		p.pos = start
+		return nil, p.errorf("unknown field %q", field)
+	}
+
+	p.skipSpace()
+	opStart := p.pos
+	op, ok := p.parseOp()
+	if !ok {
+		return nil, p.errorf("expected operator after %q", field)
+	}
+	if !supports(typ, op) {
+		p.pos = opStart
+		return nil, p.errorf("operator %q is not supported for field %q", op, field)
+	}
+
+	p.skipSpace()
+	valStart := p.pos
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case Time:
+		t, err := parseTimeAt(value, p.now)
+		if err != nil {
+			p.pos = valStart
+			return nil, p.errorf("invalid time %q for field %q", value, field)
+		}
+		value = t.Format(TimeLayout)
+	case Tag:
+		value = strings.ToLower(value)
+	}
+	return &Comparison{Field: field, Type: typ, Op: op, Value: value}, nil
+}
+
+func (p *parser) parseOp() (Op, bool) {
+	// Two-character operators must be tried first.
+	for _, op := range []Op{Ne, NotContains, Le, Ge, Eq, Contains, Lt, Gt} {
+		if strings.HasPrefix(p.src[p.pos:], string(op)) {
+			p.pos += len(op)
+			return op, true
+		}
+	}
+	return "", false
+}
+
+// parseValue reads a double-quoted string, in which \" and \\ are escapes,
+// or a bare word running up to the next space or parenthesis.
+func (p *parser) parseValue() (string, error) {
+	if p.pos >= len(p.src) {
+		return "", p.errorf("expected value")
+	}
+	if p.src[p.pos] != '"' {
+		start := p.pos
+		for p.pos < len(p.src) && !unicode.IsSpace(rune(p.src[p.pos])) && p.src[p.pos] != '(' && p.src[p.pos] != ')' {
+			p.pos++
+		}
+		if start == p.pos {
+			return "", p.errorf("expected value")
+		}
+		return p.src[start:p.pos], nil
+	}
+	start := p.pos
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			return b.String(), nil
+		case c == '\\' && p.pos+1 < len(p.src):
+			b.WriteByte(p.src[p.pos+1])
+			p.pos += 2
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+	p.pos = start
+	return "", p.errorf("unterminated string")
+}
+
+func supports(typ Type, op Op) bool {
+	for _, o := range opsByType[typ] {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// ParseTime accepts a date (2006-01-02), a date and time separated by a
+// space, or an RFC 3339 timestamp. The result is in UTC.
+func ParseTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, TimeLayout, "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+// parseTimeAt is like ParseTime, but also accepts times relative to now
+// such as "now" and "now-7d".
+func parseTimeAt(s string, now time.Time) (time.Time, error) {
+	rest, ok := strings.CutPrefix(strings.ToLower(s), "now")
+	if !ok {
+		return ParseTime(s)
+	}
+	now = now.UTC().Truncate(time.Second)
+	if rest == "" {
+		return now, nil
+	}
+	if len(rest) < 3 || rest[0] != '-' && rest[0] != '+' {
+		return time.Time{}, fmt.Errorf("invalid time %q", s)
+	}
+	n, err := strconv.Atoi(rest[1 : len(rest)-1])
+	if err != nil || n < 0 {
+		return time.Time{}, fmt.Errorf("invalid time %q", s)
+	}
+	unit, ok := relativeUnits[rest[len(rest)-1]]
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid time %q", s)
+	}
+	d := time.Duration(n) * unit
+	if rest[0] == '-' {
+		d = -d
+	}
+	return now.Add(d), nil
+}
+
+// relativeUnits are the units of relative times.
+var relativeUnits = map[byte]time.Duration{
+	's': time.Second,
+	'm': time.Minute,
+	'h': time.Hour,
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+}
+
+// SortKey orders results by a field, descending when Desc is set.
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// ParseSort parses a comma-separated list of fields, each optionally
+// prefixed with "-" for descending order, e.g. "-created_at,name". Besides
+// the given fields, "id" is always sortable; tag fields are not. Custom
+// fields sort missing values first, then booleans, numbers and text.
+func ParseSort(s string, fields Fields) ([]SortKey, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var keys []SortKey
+	seen := make(map[string]bool)
+	pos := 0
+	for _, part := range strings.Split(s, ",") {
+		field := strings.TrimSpace(part)
+		key := SortKey{}
+		if strings.HasPrefix(field, "-") {
+			key.Desc = true
+			field = field[1:]
+		}
+		typ, ok := fields.lookup(field)
+		if !ok && field != "id" {
+			return nil, &Error{Pos: pos, Msg: fmt.Sprintf("unknown sort field %q", field)}
+		}
+		if ok && typ == Tag {
+			return nil, &Error{Pos: pos, Msg: fmt.Sprintf("cannot sort by %q", field)}
+		}
+		if seen[field] {
+			return nil, &Error{Pos: pos, Msg: fmt.Sprintf("duplicate sort field %q", field)}
+		}
+		seen[field] = true
+		key.Field = field
+		keys = append(keys, key)
+		pos += len(part) + 1
+	}
+	return keys, nil
+}
+
+// FormatSort is the inverse of ParseSort.
+func FormatSort(keys []SortKey) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		if k.Desc {
+			parts[i] = "-" + k.Field
+		} else {
+			parts[i] = k.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,152 @@
+package filter
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Expr
+	}{
+		{"", nil},
+		{`name~"foo"`, &Comparison{Field: "name", Type: String, Op: Contains, Value: "foo"}},
+		{`name = "say \"hi\""`, &Comparison{Field: "name", Type: String, Op: Eq, Value: `say "hi"`}},
+		{
+			`name~"foo" and created_at>2026-01-01`,
+			&And{
+				Left:  &Comparison{Field: "name", Type: String, Op: Contains, Value: "foo"},
+				Right: &Comparison{Field: "created_at", Type: Time, Op: Gt, Value: "2026-01-01 00:00:00"},
+			},
+		},
+		{
+			`not (description!~bar OR name=x) and created_at<=2026-01-01T10:00:00+02:00`,
+			&And{
+				Left: &Not{Expr: &Or{
+					Left:  &Comparison{Field: "description", Type: String, Op: NotContains, Value: "bar"},
+					Right: &Comparison{Field: "name", Type: String, Op: Eq, Value: "x"},
+				}},
+				Right: &Comparison{Field: "created_at", Type: Time, Op: Le, Value: "2026-01-01 08:00:00"},
+			},
+		},
+		{
+			`name=a or name=b and name=c`,
+			&Or{
+				Left: &Comparison{Field: "name", Type: String, Op: Eq, Value: "a"},
+				Right: &And{
+					Left:  &Comparison{Field: "name", Type: String, Op: Eq, Value: "b"},
+					Right: &Comparison{Field: "name", Type: String, Op: Eq, Value: "c"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in, DefaultFields)
+		if err != nil {
+			t.Fatalf("Parse(%q) returned error: %v", tt.in, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("Parse(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseAt(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 30, 15, 500, time.UTC)
+	got, err := ParseAt(`tag=Urgent and created_at>=now-7d and updated_at<NOW+90m and created_at!=now`, DefaultFields, now)
+	if err != nil {
+		t.Fatalf("ParseAt returned error: %v", err)
+	}
+	want := &And{
+		Left: &And{
+			Left: &And{
+				Left:  &Comparison{Field: "tag", Type: Tag, Op: Eq, Value: "urgent"},
+				Right: &Comparison{Field: "created_at", Type: Time, Op: Ge, Value: "2026-03-03 12:30:15"},
+			},
+			Right: &Comparison{Field: "updated_at", Type: Time, Op: Lt, Value: "2026-03-10 14:00:15"},
+		},
+		Right: &Comparison{Field: "created_at", Type: Time, Op: Ne, Value: "2026-03-10 12:30:15"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ParseAt = %#v, want %#v", got, want)
+	}
+}
+
+func TestParseCustom(t *testing.T) {
+	got, err := Parse(`fields.priority>=3 and not fields.status="In review"`, ItemFields)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	want := &And{
+		Left:  &Comparison{Field: "fields.priority", Type: Custom, Op: Ge, Value: "3"},
+		Right: &Not{Expr: &Comparison{Field: "fields.status", Type: Custom, Op: Eq, Value: "In review"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Parse = %#v, want %#v", got, want)
+	}
+	for _, in := range []string{"fields.priority=3", "fields.=3", "fields.Priority=3", "fields.a.b=3", "fields=3"} {
+		if _, err := Parse(in, DefaultFields); err == nil {
+			t.Fatalf("Parse(%q, DefaultFields): expected error", in)
+		}
+		if in != "fields.priority=3" {
+			if _, err := Parse(in, ItemFields); err == nil {
+				t.Fatalf("Parse(%q, ItemFields): expected error", in)
+			}
+		}
+	}
+	keys, err := ParseSort("-fields.priority,name", ItemFields)
+	if err != nil || !reflect.DeepEqual(keys, []SortKey{{Field: "fields.priority", Desc: true}, {Field: "name"}}) {
+		t.Fatalf("ParseSort = %+v, %v", keys, err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		in  string
+		pos int
+		msg string
+	}{
+		{`owner="me"`, 0, `unknown field "owner"`},
+		{`name<"a"`, 4, `operator "<" is not supported for field "name"`},
+		{`created_at>yesterday`, 11, `invalid time "yesterday" for field "created_at"`},
+		{`name="open`, 5, "unterminated string"},
+		{`(name=a`, 7, `expected ")"`},
+		{`name=a name=b`, 7, `unexpected "name=b"`},
+		{`name`, 4, `expected operator after "name"`},
+		{`name=`, 5, "expected value"},
+		{`tag~urg`, 3, `operator "~" is not supported for field "tag"`},
+		{`created_at>now-7y`, 11, `invalid time "now-7y" for field "created_at"`},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.in, DefaultFields)
+		var perr *Error
+		if !errors.As(err, &perr) {
+			t.Fatalf("Parse(%q): expected *Error, got %v", tt.in, err)
+		}
+		if perr.Pos != tt.pos || perr.Msg != tt.msg {
+			t.Fatalf("Parse(%q) = %q at %d, want %q at %d", tt.in, perr.Msg, perr.Pos, tt.msg, tt.pos)
+		}
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	keys, err := ParseSort("-created_at, name", DefaultFields)
+	if err != nil {
+		t.Fatalf("ParseSort returned error: %v", err)
+	}
+	want := []SortKey{{Field: "created_at", Desc: true}, {Field: "name"}}
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("ParseSort = %+v, want %+v", keys, want)
+	}
+	if got := FormatSort(keys); got != "-created_at,name" {
+		t.Fatalf("FormatSort = %q", got)
+	}
+	for _, in := range []string{"owner", "name,-name", "name,", "tag"} {
+		if _, err := ParseSort(in, DefaultFields); err == nil {
+			t.Fatalf("ParseSort(%q): expected error", in)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package store
+
+import "context"
+
+// Orphan is a membership that does not relate two live rows: a
+// collection_items row whose collection or item no longer exists or is in
+// the trash, or a deleted_collection_items row whose collection or item no
+// longer exists. Missing rows were left behind by versions of DeleteItem
+// that did not clean up memberships, and by memberships added for IDs that
+// never existed, before foreign keys were added.
+type Orphan struct {
+	CollectionID      int64
+	ItemID            int64
+	MissingCollection bool
+	MissingItem       bool
+	DeletedCollection bool
+	DeletedItem       bool
+	// Trashed is set for rows of deleted_collection_items.
+	Trashed bool
+}
+
+const (
+	membershipJoin = "LEFT JOIN collections c ON c.id = ci.collection_id " +
+		"LEFT JOIN items i ON i.id = ci.item_id "
+	liveOrphanJoin = "FROM collection_items ci " + membershipJoin +
+		"WHERE c.id IS NULL OR i.id IS NULL OR c.deleted_at IS NOT NULL OR i.deleted_at IS NOT NULL"
+	trashedOrphanJoin = "FROM deleted_collection_items ci " + membershipJoin +
+		"WHERE c.id IS NULL OR i.id IS NULL"
+)
+
+// FindOrphans lists the memberships that reference a missing collection or
+// item, and the live memberships that reference a deleted one.
+func FindOrphans(ctx context.Context, q Querier) ([]Orphan, error) {
+	const columns = "SELECT ci.collection_id, ci.item_id, c.id IS NULL, i.id IS NULL, c.deleted_at IS NOT NULL, i.deleted_at IS NOT NULL, "
+	rows, err := q.QueryContext(ctx, columns+"FALSE "+liveOrphanJoin+" UNION ALL "+columns+"TRUE "+trashedOrphanJoin+" ORDER BY 7, 1, 2")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var orphans []Orphan
+	for rows.Next() {
+		var o Orphan
+		if err := rows.Scan(&o.CollectionID, &o.ItemID, &o.MissingCollection, &o.MissingItem, &o.DeletedCollection, &o.DeletedItem, &o.Trashed); err != nil {
+			return nil, err
+		}
+		orphans = append(orphans, o)
+	}
+	return orphans, rows.Err()
+}
+
+// RepairOrphans fixes the memberships reported by FindOrphans and returns
+// how many were fixed. Memberships of missing rows are deleted; live
+// memberships of deleted rows are moved to the trash, so that restoring the
+// rows brings them back.
+func RepairOrphans(ctx context.Context, q Querier) (int64, error) {
+	var repaired int64
+	err := withTx(ctx, q, func(q Querier) error {
+		const deleted = "FROM collection_items ci JOIN collections c ON c.id = ci.collection_id JOIN items i ON i.id = ci.item_id " +
+			"WHERE c.deleted_at IS NOT NULL OR i.deleted_at IS NOT NULL"
+		if _, err := q.ExecContext(ctx, "INSERT IGNORE INTO deleted_collection_items (collection_id, item_id, position) SELECT ci.collection_id, ci.item_id, ci.position "+deleted); err != nil {
+			return err
+		}
+		for _, query := range []string{"DELETE ci " + deleted, "DELETE ci " + liveOrphanJoin, "DELETE ci " + trashedOrphanJoin} {
+			res, err := q.ExecContext(ctx, query)
+			if err != nil {
+				return err
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			repaired += n
+		}
+		return nil
+	})
+	return repaired, err
+}
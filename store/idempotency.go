@@ -0,0 +1,105 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// idempotencyAbandonAfter is how long a reservation may stay in progress.
+// After that the request that made it is assumed to have died, and the key
+// can be reserved again.
+const idempotencyAbandonAfter = time.Minute
+
+// IdempotencyRecord is the stored outcome of a request made with an
+// Idempotency-Key header. The table schema is:
+//
+//	idempotency_key VARCHAR(255) NOT NULL PRIMARY KEY,
+//	fingerprint CHAR(64) NOT NULL,
+//	status_code INT NULL,
+//	response_header TEXT NULL,
+//	response_body MEDIUMBLOB NULL,
+//	created_at DATETIME NOT NULL,
+//	expires_at DATETIME NOT NULL
+type IdempotencyRecord struct {
+	Key         string
+	Fingerprint string
+	// StatusCode is zero while the original request is in progress.
+	StatusCode int
+	Header     map[string][]string
+	Body       []byte
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+}
+
+// ReserveIdempotencyKey claims key for a request with the given fingerprint
+// until now+ttl. It returns nil if the key was free, expired or abandoned,
+// and the existing record otherwise.
+func ReserveIdempotencyKey(ctx context.Context, q Querier, key, fingerprint string, ttl time.Duration, now time.Time) (*IdempotencyRecord, error) {
+	var rec *IdempotencyRecord
+	err := withTx(ctx, q, func(q Querier) error {
+		if _, err := q.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE idempotency_key = ? AND (expires_at <= ? OR (status_code IS NULL AND created_at <= ?))", key, now, now.Add(-idempotencyAbandonAfter)); err != nil {
+			return err
+		}
+		res, err := q.ExecContext(ctx, "INSERT IGNORE INTO idempotency_keys (idempotency_key, fingerprint, created_at, expires_at) VALUES (?, ?, ?, ?)", key, fingerprint, now, now.Add(ttl))
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 1 {
+			return err
+		}
+		rec, err = getIdempotencyRecord(ctx, q, key)
+		return err
+	})
+	return rec, err
+}
+
+func getIdempotencyRecord(ctx context.Context, q Querier, key string) (*IdempotencyRecord, error) {
+	var rec IdempotencyRecord
+	var status sql.NullInt64
+	var header sql.NullString
+	row := q.QueryRowContext(ctx, "SELECT idempotency_key, fingerprint, status_code, response_header, response_body, created_at, expires_at FROM idempotency_keys WHERE idempotency_key = ?", key)
+	if err := row.Scan(&rec.Key, &rec.Fingerprint, &status, &header, &rec.Body, &rec.CreatedAt, &rec.ExpiresAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	rec.StatusCode = int(status.Int64)
+	if header.Valid {
+		if err := json.Unmarshal([]byte(header.String), &rec.Header); err != nil {
+			return nil, err
+		}
+	}
+	return &rec, nil
+}
+
+// CompleteIdempotencyKey stores the response to the request that reserved
+// key, so that it can be replayed.
+func CompleteIdempotencyKey(ctx context.Context, q Querier, key string, status int, header map[string][]string, body []byte) error {
+	h, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx, "UPDATE idempotency_keys SET status_code = ?, response_header = ?, response_body = ? WHERE idempotency_key = ?", status, string(h), body, key)
+	return err
+}
+
+// ReleaseIdempotencyKey deletes a reservation, e.g. because the request
+// failed in a way that a retry may fix.
+func ReleaseIdempotencyKey(ctx context.Context, q Querier, key string) error {
+	_, err := q.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE idempotency_key = ?", key)
+	return err
+}
+
+// PurgeIdempotencyKeys deletes the records that expired before now and
+// returns how many were removed.
+func PurgeIdempotencyKeys(ctx context.Context, q Querier, now time.Time) (int64, error) {
+	res, err := q.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= ?", now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,220 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// MariaDB is a Store backed by a MariaDB database.
+// It delegates to the package-level functions in store.go.
+type MariaDB struct {
+	db *sql.DB
+	// q is db, or the transaction the store is bound to by WithTx.
+	q   Querier
+	now Clock
+}
+
+var _ Store = (*MariaDB)(nil)
+
+// NewMariaDB returns a Store that uses the given database connection. The
+// connection must scan DATETIME columns as time.Time in UTC, i.e. its DSN
+// needs parseTime=true and loc=UTC, as set by db.Open.
+func NewMariaDB(db *sql.DB) *MariaDB {
+	return NewMariaDBWithClock(db, time.Now)
+}
+
+// NewMariaDBWithClock is like NewMariaDB, but stamps writes with now.
+func NewMariaDBWithClock(db *sql.DB, now Clock) *MariaDB {
+	return &MariaDB{db: db, q: db, now: now}
+}
+
+func (m *MariaDB) CreateItem(ctx context.Context, name, description string) (*Item, error) {
+	return CreateItem(ctx, m.q, name, description, m.now.timestamp())
+}
+
+func (m *MariaDB) GetItem(ctx context.Context, id int64) (*Item, error) {
+	return GetItem(ctx, m.q, id)
+}
+
+func (m *MariaDB) ListItems(ctx context.Context, opts ListOptions) ([]Item, string, error) {
+	return ListItems(ctx, m.q, opts)
+}
+
+func (m *MariaDB) ItemsLastModified(ctx context.Context) (time.Time, error) {
+	return ItemsLastModified(ctx, m.q)
+}
+
+func (m *MariaDB) UpdateItem(ctx context.Context, id int64, name, description string, version int64) (*Item, error) {
+	return UpdateItem(ctx, m.q, id, name, description, version, m.now.timestamp())
+}
+
+func (m *MariaDB) PatchItem(ctx context.Context, id int64, p Patch, version int64) (*Item, error) {
+	return PatchItem(ctx, m.q, id, p, version, m.now.timestamp())
+}
+
+func (m *MariaDB) DeleteItem(ctx context.Context, id int64, version int64) error {
+	return DeleteItem(ctx, m.q, id, version, m.now.timestamp())
+}
+
+func (m *MariaDB) CreateCollection(ctx context.Context, name, description string) (*Collection, error) {
+	return CreateCollection(ctx, m.q, name, description, m.now.timestamp())
+}
+
+func (m *MariaDB) CreateSmartCollection(ctx context.Context, name, description, rule string) (*Collection, error) {
+	return CreateSmartCollection(ctx, m.q, name, description, rule, m.now.timestamp())
+}
+
+func (m *MariaDB) GetCollection(ctx context.Context, id int64) (*Collection, error) {
+	return GetCollection(ctx, m.q, id)
+}
+
+func (m *MariaDB) ListCollections(ctx context.Context, opts ListOptions) ([]Collection, string, error) {
+	return ListCollections(ctx, m.q, opts)
+}
+
+func (m *MariaDB) CollectionsLastModified(ctx context.Context) (time.Time, error) {
+	return CollectionsLastModified(ctx, m.q)
+}
+
+func (m *MariaDB) UpdateCollection(ctx context.Context, id int64, name, description string, version int64) (*Collection, error) {
+	return UpdateCollection(ctx, m.q, id, name, description, version, m.now.timestamp())
+}
+
+func (m *MariaDB) PatchCollection(ctx context.Context, id int64, p Patch, version int64) (*Collection, error) {
+	return PatchCollection(ctx, m.q, id, p, version, m.now.timestamp())
+}
+
+func (m *MariaDB) DeleteCollection(ctx context.Context, id int64, version int64) error {
+	return DeleteCollection(ctx, m.q, id, version, m.now.timestamp())
+}
+
+func (m *MariaDB) AddItemToCollection(ctx context.Context, collectionID, itemID int64) error {
+	return AddItemToCollection(ctx, m.q, collectionID, itemID, m.now.timestamp())
+}
+
+func (m *MariaDB) ListItemsInCollection(ctx context.Context, collectionID int64, opts ListOptions) ([]Item, string, error) {
+	return ListItemsInCollection(ctx, m.q, collectionID, opts, m.now.timestamp())
+}
+
+func (m *MariaDB) RemoveItemFromCollection(ctx context.Context, collectionID, itemID int64) error {
+	return RemoveItemFromCollection(ctx, m.q, collectionID, itemID, m.now.timestamp())
+}
+
+func (m *MariaDB) MoveCollection(ctx context.Context, id, parentID int64, version int64) (*Collection, error) {
+	return MoveCollection(ctx, m.q, id, parentID, version, m.now.timestamp())
+}
+
+func (m *MariaDB) ListChildCollections(ctx context.Context, parentID int64, opts ListOptions) ([]Collection, string, error) {
+	return ListChildCollections(ctx, m.q, parentID, opts)
+}
+
+func (m *MariaDB) CollectionSubtree(ctx context.Context, id int64) ([]Collection, error) {
+	return CollectionSubtree(ctx, m.q, id)
+}
+
+func (m *MariaDB) DeleteCollectionTree(ctx context.Context, id int64, version int64) ([]int64, error) {
+	return DeleteCollectionTree(ctx, m.q, id, version, m.now.timestamp())
+}
+
+func (m *MariaDB) ListCollectionsOfItem(ctx context.Context, itemID int64, opts ListOptions) ([]Collection, string, error) {
+	return ListCollectionsOfItem(ctx, m.q, itemID, opts)
+}
+
+func (m *MariaDB) ItemCollectionIDs(ctx context.Context, itemIDs []int64) (map[int64][]int64, error) {
+	return ItemCollectionIDs(ctx, m.q, itemIDs)
+}
+
+func (m *MariaDB) InsertItemIntoCollection(ctx context.Context, collectionID, itemID int64, at Placement) error {
+	return InsertItemIntoCollection(ctx, m.q, collectionID, itemID, at, m.now.timestamp())
+}
+
+func (m *MariaDB) MoveItemInCollection(ctx context.Context, collectionID, itemID int64, to Placement) error {
+	return MoveItemInCollection(ctx, m.q, collectionID, itemID, to, m.now.timestamp())
+}
+
+func (m *MariaDB) AddItemsToCollection(ctx context.Context, collectionID int64, itemIDs []int64) ([]MembershipResult, error) {
+	return AddItemsToCollection(ctx, m.q, collectionID, itemIDs, m.now.timestamp())
+}
+
+func (m *MariaDB) RemoveItemsFromCollection(ctx context.Context, collectionID int64, itemIDs []int64) ([]MembershipResult, error) {
+	return RemoveItemsFromCollection(ctx, m.q, collectionID, itemIDs, m.now.timestamp())
+}
+
+func (m *MariaDB) ReplaceCollectionItems(ctx context.Context, collectionID int64, itemIDs []int64) ([]MembershipResult, error) {
+	return ReplaceCollectionItems(ctx, m.q, collectionID, itemIDs, m.now.timestamp())
+}
+
+func (m *MariaDB) ReplaceItemFields(ctx context.Context, id int64, fields map[string]any, version int64) (*Item, error) {
+	return ReplaceItemFields(ctx, m.q, id, fields, version, m.now.timestamp())
+}
+
+func (m *MariaDB) PatchItemFields(ctx context.Context, id int64, fields map[string]any, version int64) (*Item, error) {
+	return PatchItemFields(ctx, m.q, id, fields, version, m.now.timestamp())
+}
+
+func (m *MariaDB) SetCollectionSchema(ctx context.Context, id int64, schema []FieldDef, version int64) (*Collection, error) {
+	return SetCollectionSchema(ctx, m.q, id, schema, version, m.now.timestamp())
+}
+
+func (m *MariaDB) ListDeletedItems(ctx context.Context, opts ListOptions) ([]Item, string, error) {
+	return ListDeletedItems(ctx, m.q, opts)
+}
+
+func (m *MariaDB) ListDeletedCollections(ctx context.Context, opts ListOptions) ([]Collection, string, error) {
+	return ListDeletedCollections(ctx, m.q, opts)
+}
+
+func (m *MariaDB) RestoreItem(ctx context.Context, id int64, version int64) (*Item, error) {
+	return RestoreItem(ctx, m.q, id, version, m.now.timestamp())
+}
+
+func (m *MariaDB) RestoreCollection(ctx context.Context, id int64, version int64) (*Collection, error) {
+	return RestoreCollection(ctx, m.q, id, version, m.now.timestamp())
+}
+
+func (m *MariaDB) PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error) {
+	return PurgeDeleted(ctx, m.q, m.now.timestamp().Add(-retention))
+}
+
+func (m *MariaDB) AddItemTags(ctx context.Context, id int64, tags []string, version int64) (*Item, error) {
+	return AddItemTags(ctx, m.q, id, tags, version, m.now.timestamp())
+}
+
+func (m *MariaDB) RemoveItemTags(ctx context.Context, id int64, tags []string, version int64) (*Item, error) {
+	return RemoveItemTags(ctx, m.q, id, tags, version, m.now.timestamp())
+}
+
+func (m *MariaDB) AddCollectionTags(ctx context.Context, id int64, tags []string, version int64) (*Collection, error) {
+	return AddCollectionTags(ctx, m.q, id, tags, version, m.now.timestamp())
+}
+
+func (m *MariaDB) RemoveCollectionTags(ctx context.Context, id int64, tags []string, version int64) (*Collection, error) {
+	return RemoveCollectionTags(ctx, m.q, id, tags, version, m.now.timestamp())
+}
+
+func (m *MariaDB) ListTags(ctx context.Context, opts TagListOptions) ([]TagCount, error) {
+	return ListTags(ctx, m.q, opts)
+}
+
+func (m *MariaDB) ReserveIdempotencyKey(ctx context.Context, key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, error) {
+	return ReserveIdempotencyKey(ctx, m.q, key, fingerprint, ttl, m.now.timestamp())
+}
+
+func (m *MariaDB) CompleteIdempotencyKey(ctx context.Context, key string, status int, header map[string][]string, body []byte) error {
+	return CompleteIdempotencyKey(ctx, m.q, key, status, header, body)
+}
+
+func (m *MariaDB) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	return ReleaseIdempotencyKey(ctx, m.q, key)
+}
+
+func (m *MariaDB) PurgeIdempotencyKeys(ctx context.Context) (int64, error) {
+	return PurgeIdempotencyKeys(ctx, m.q, m.now.timestamp())
+}
+
+func (m *MariaDB) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	return withTx(ctx, m.q, func(q Querier) error {
+		return fn(&MariaDB{db: m.db, q: q, now: m.now})
+	})
+}
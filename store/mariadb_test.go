@@ -0,0 +1,105 @@
+package store_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/mmontes11/opencode-test/db"
+	"github.com/mmontes11/opencode-test/store"
+	"github.com/mmontes11/opencode-test/store/storetest"
+)
+
+// openTestDB connects to and migrates the database named by MARIADB_DSN, or
+// skips the test if it is not set.
+func openTestDB(t *testing.T) *sql.DB {
+	if os.Getenv("MARIADB_DSN") == "" {
+		t.Skip("MARIADB_DSN env var not set; skipping integration tests")
+	}
+	conn, err := db.Open()
+	if err != nil {
+		t.Fatalf("failed to init db: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if _, err := db.MigrateUp(context.Background(), conn); err != nil {
+		t.Fatalf("failed to migrate db: %v", err)
+	}
+	return conn
+}
+
+func TestMariaDBConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, now store.Clock) store.Store {
+		return store.NewMariaDBWithClock(openTestDB(t), now)
+	})
+}
+
+func TestFsck(t *testing.T) {
+	ctx := context.Background()
+	conn := openTestDB(t)
+	s := store.NewMariaDB(conn)
+	col, _ := s.CreateCollection(ctx, "fsck", "")
+	defer s.DeleteCollection(ctx, col.ID, 0)
+	item, _ := s.CreateItem(ctx, "fsck", "")
+	defer s.DeleteItem(ctx, item.ID, 0)
+	trashed, _ := s.CreateItem(ctx, "fsck trashed", "")
+	defer s.DeleteItem(ctx, trashed.ID, 0)
+	if err := s.AddItemToCollection(ctx, col.ID, trashed.ID); err != nil {
+		t.Fatalf("AddItemToCollection: %v", err)
+	}
+	// Insert orphans directly, bypassing the store's checks and the foreign
+	// keys, like data written by older versions.
+	const missing = int64(1) << 60
+	c, err := conn.Conn(ctx)
+	if err != nil {
+		t.Fatalf("Conn: %v", err)
+	}
+	for _, query := range []string{
+		"SET foreign_key_checks = 0",
+		fmt.Sprintf("INSERT INTO collection_items (collection_id, item_id) VALUES (%d, %d), (%d, %d)", col.ID, missing, missing, item.ID),
+		fmt.Sprintf("INSERT INTO deleted_collection_items (collection_id, item_id, position) VALUES (%d, %d, 0)", missing, item.ID),
+		"SET foreign_key_checks = 1",
+		fmt.Sprintf("UPDATE items SET deleted_at = NOW() WHERE id = %d", trashed.ID),
+	} {
+		if _, err := c.ExecContext(ctx, query); err != nil {
+			t.Fatalf("insert orphans: %v", err)
+		}
+	}
+	c.Close()
+
+	orphans, err := store.FindOrphans(ctx, conn)
+	if err != nil {
+		t.Fatalf("FindOrphans: %v", err)
+	}
+	want := map[store.Orphan]bool{
+		{CollectionID: col.ID, ItemID: missing, MissingItem: true}:                       true,
+		{CollectionID: missing, ItemID: item.ID, MissingCollection: true}:                true,
+		{CollectionID: missing, ItemID: item.ID, MissingCollection: true, Trashed: true}: true,
+		{CollectionID: col.ID, ItemID: trashed.ID, DeletedItem: true}:                    true,
+	}
+	found := 0
+	for _, o := range orphans {
+		if want[o] {
+			found++
+		}
+	}
+	if found != len(want) {
+		t.Fatalf("expected orphans %v, got %v", want, orphans)
+	}
+
+	if _, err := store.RepairOrphans(ctx, conn); err != nil {
+		t.Fatalf("RepairOrphans: %v", err)
+	}
+	if orphans, _ := store.FindOrphans(ctx, conn); len(orphans) != 0 {
+		t.Fatalf("expected no orphans after repair, got %v", orphans)
+	}
+	// The membership of the deleted item was moved to the trash, not lost.
+	if _, err := s.RestoreItem(ctx, trashed.ID, 0); err != nil {
+		t.Fatalf("RestoreItem: %v", err)
+	}
+	items, _, err := s.ListItemsInCollection(ctx, col.ID, store.ListOptions{})
+	if err != nil || len(items) != 1 || items[0].ID != trashed.ID {
+		t.Fatalf("members after restoring = %+v, %v; want item %d", items, err, trashed.ID)
+	}
+}
@@ -0,0 +1,1347 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// membership identifies a row of the collection_items join table.
+type membership struct {
+	collectionID int64
+	itemID       int64
+}
+
+// Memory is an in-process Store intended for tests and local development.
+// It mirrors the semantics of the MariaDB implementation: IDs are
+// auto-incremented, missing rows are reported as ErrNotFound, memberships
+// must reference existing rows, adding an existing membership is a no-op and
+// updates increment the row's version.
+// It is safe for concurrent use.
+//
+// Operations fail with the context's error if it is already done. WithTx
+// runs fn against a copy of the data while holding the write lock, and
+// publishes the copy only if fn succeeds.
+type Memory struct {
+	mu sync.RWMutex
+	memoryData
+	now Clock
+}
+
+// memoryData is the state of a Memory store, split out so that WithTx can
+// copy it.
+type memoryData struct {
+	items       map[int64]Item
+	collections map[int64]Collection
+	// memberships maps each membership to its position.
+	memberships map[membership]int64
+	// The trash holds deleted rows and the memberships they had, apart from
+	// the live ones. deletedWith maps each deleted collection to the
+	// collection whose deletion moved it to the trash.
+	deletedItems       map[int64]Item
+	deletedCollections map[int64]Collection
+	deletedMemberships map[membership]int64
+	deletedWith        map[int64]int64
+	idempotency        map[string]IdempotencyRecord
+	nextItemID         int64
+	nextColID          int64
+}
+
+var _ Store = (*Memory)(nil)
+
+// NewMemory returns an empty in-memory Store.
+func NewMemory() *Memory {
+	return NewMemoryWithClock(time.Now)
+}
+
+// NewMemoryWithClock is like NewMemory, but stamps writes with now.
+func NewMemoryWithClock(now Clock) *Memory {
+	return &Memory{
+		memoryData: memoryData{
+			items:              make(map[int64]Item),
+			collections:        make(map[int64]Collection),
+			memberships:        make(map[membership]int64),
+			deletedItems:       make(map[int64]Item),
+			deletedCollections: make(map[int64]Collection),
+			deletedMemberships: make(map[membership]int64),
+			deletedWith:        make(map[int64]int64),
+			idempotency:        make(map[string]IdempotencyRecord),
+		},
+		now: now,
+	}
+}
+
+func (d *memoryData) clone() memoryData {
+	c := *d
+	c.items = make(map[int64]Item, len(d.items))
+	for k, v := range d.items {
+		c.items[k] = v
+	}
+	c.collections = make(map[int64]Collection, len(d.collections))
+	for k, v := range d.collections {
+		c.collections[k] = v
+	}
+	c.memberships = make(map[membership]int64, len(d.memberships))
+	for k, v := range d.memberships {
+		c.memberships[k] = v
+	}
+	c.deletedItems = maps.Clone(d.deletedItems)
+	c.deletedCollections = maps.Clone(d.deletedCollections)
+	c.deletedMemberships = maps.Clone(d.deletedMemberships)
+	c.deletedWith = maps.Clone(d.deletedWith)
+	c.idempotency = make(map[string]IdempotencyRecord, len(d.idempotency))
+	for k, v := range d.idempotency {
+		c.idempotency[k] = v
+	}
+	return c
+}
+
+func (m *Memory) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tx := &Memory{memoryData: m.memoryData.clone(), now: m.now}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	m.memoryData = tx.memoryData
+	return nil
+}
+
+func (m *Memory) CreateItem(ctx context.Context, name, description string) (*Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextItemID++
+	now := m.now.timestamp()
+	item := Item{ID: m.nextItemID, Name: name, Description: description, CreatedAt: now, UpdatedAt: now, Version: 1}
+	m.items[item.ID] = item
+	return &item, nil
+}
+
+func (m *Memory) GetItem(ctx context.Context, id int64) (*Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	item, ok := m.items[id]
+	if !ok {
+		return nil, notFound("item", id)
+	}
+	return &item, nil
+}
+
+func (m *Memory) ListItems(ctx context.Context, opts ListOptions) ([]Item, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	items := make([]Item, 0, len(m.items))
+	for _, item := range m.items {
+		items = append(items, item)
+	}
+	return listRows(items, opts)
+}
+
+func (m *Memory) ItemsLastModified(ctx context.Context) (time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var last time.Time
+	for _, item := range m.items {
+		last = latest(last, item.UpdatedAt)
+	}
+	for _, item := range m.deletedItems {
+		last = latest(last, item.DeletedAt)
+	}
+	return last, nil
+}
+
+func (m *Memory) UpdateItem(ctx context.Context, id int64, name, description string, version int64) (*Item, error) {
+	return m.PatchItem(ctx, id, Patch{Name: &name, Description: &description}, version)
+}
+
+func (m *Memory) PatchItem(ctx context.Context, id int64, p Patch, version int64) (*Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if p.Rule != nil {
+		return nil, errItemRule
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, ok := m.items[id]
+	if !ok {
+		return nil, notFound("item", id)
+	}
+	if version != 0 && version != item.Version {
+		return nil, preconditionFailed("item", id, item.Version)
+	}
+	if p.empty() {
+		return &item, nil
+	}
+	if p.Name != nil {
+		item.Name = *p.Name
+	}
+	if p.Description != nil {
+		item.Description = *p.Description
+	}
+	item.UpdatedAt = m.now.timestamp()
+	item.Version++
+	m.items[id] = item
+	for ms := range m.memberships {
+		if ms.itemID == id {
+			m.touchMembers(ms.collectionID)
+		}
+	}
+	return &item, nil
+}
+
+func (m *Memory) DeleteItem(ctx context.Context, id int64, version int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, ok := m.items[id]
+	if !ok {
+		return notFound("item", id)
+	}
+	if version != 0 && version != item.Version {
+		return preconditionFailed("item", id, item.Version)
+	}
+	for ms, pos := range m.memberships {
+		if ms.itemID == id {
+			m.deletedMemberships[ms] = pos
+			delete(m.memberships, ms)
+			m.touchMembers(ms.collectionID)
+		}
+	}
+	item.DeletedAt = m.now.timestamp()
+	m.deletedItems[id] = item
+	delete(m.items, id)
+	return nil
+}
+
+func (m *Memory) CreateCollection(ctx context.Context, name, description string) (*Collection, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextColID++
+	now := m.now.timestamp()
+	col := Collection{ID: m.nextColID, Name: name, Description: description, CreatedAt: now, UpdatedAt: now, Version: 1, MembersUpdatedAt: now}
+	m.collections[col.ID] = col
+	return &col, nil
+}
+
+func (m *Memory) CreateSmartCollection(ctx context.Context, name, description, rule string) (*Collection, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := validateRule(rule); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextColID++
+	now := m.now.timestamp()
+	col := Collection{ID: m.nextColID, Name: name, Description: description, CreatedAt: now, UpdatedAt: now, Version: 1, MembersUpdatedAt: now, Rule: rule}
+	m.collections[col.ID] = col
+	return &col, nil
+}
+
+func (m *Memory) GetCollection(ctx context.Context, id int64) (*Collection, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	col, ok := m.collections[id]
+	if !ok {
+		return nil, notFound("collection", id)
+	}
+	return &col, nil
+}
+
+func (m *Memory) ListCollections(ctx context.Context, opts ListOptions) ([]Collection, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	cols := make([]Collection, 0, len(m.collections))
+	for _, col := range m.collections {
+		cols = append(cols, col)
+	}
+	return listRows(cols, opts)
+}
+
+func (m *Memory) CollectionsLastModified(ctx context.Context) (time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var last time.Time
+	for _, col := range m.collections {
+		last = latest(last, col.UpdatedAt)
+	}
+	for _, col := range m.deletedCollections {
+		last = latest(last, col.DeletedAt)
+	}
+	return last, nil
+}
+
+// latest returns the later of two times.
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+func (m *Memory) UpdateCollection(ctx context.Context, id int64, name, description string, version int64) (*Collection, error) {
+	return m.PatchCollection(ctx, id, Patch{Name: &name, Description: &description}, version)
+}
+
+func (m *Memory) PatchCollection(ctx context.Context, id int64, p Patch, version int64) (*Collection, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if p.Rule != nil && *p.Rule != "" {
+		if err := validateRule(*p.Rule); err != nil {
+			return nil, err
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	col, ok := m.collections[id]
+	if !ok {
+		return nil, notFound("collection", id)
+	}
+	if version != 0 && version != col.Version {
+		return nil, preconditionFailed("collection", id, col.Version)
+	}
+	if p.empty() {
+		return &col, nil
+	}
+	if p.Name != nil {
+		col.Name = *p.Name
+	}
+	if p.Description != nil {
+		col.Description = *p.Description
+	}
+	if p.Rule != nil {
+		col.Rule = *p.Rule
+		col.MembersUpdatedAt = m.now.timestamp()
+		if col.Rule != "" {
+			for ms := range m.memberships {
+				if ms.collectionID == id {
+					delete(m.memberships, ms)
+				}
+			}
+		}
+	}
+	col.UpdatedAt = m.now.timestamp()
+	col.Version++
+	m.collections[id] = col
+	return &col, nil
+}
+
+// DeleteCollection moves a collection and its memberships to the trash.
+// Like the MariaDB implementation, deleting a missing collection is not an
+// error unless a version is given.
+func (m *Memory) DeleteCollection(ctx context.Context, id int64, version int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if version != 0 {
+		col, ok := m.collections[id]
+		if !ok {
+			return notFound("collection", id)
+		}
+		if version != col.Version {
+			return preconditionFailed("collection", id, col.Version)
+		}
+	}
+	for _, col := range m.collections {
+		if col.ParentID == id {
+			return fmt.Errorf("%w: collection %d has child collections", ErrConflict, id)
+		}
+	}
+	if _, ok := m.collections[id]; ok {
+		m.deleteCollection(id, id, m.now.timestamp())
+	}
+	return nil
+}
+
+// deleteCollection moves a live collection and its memberships to the
+// trash as part of the deletion of the collection with, its own ID or an
+// ancestor's. The caller must hold the write lock.
+func (m *Memory) deleteCollection(id, with int64, now time.Time) {
+	for ms, pos := range m.memberships {
+		if ms.collectionID == id {
+			m.deletedMemberships[ms] = pos
+			delete(m.memberships, ms)
+		}
+	}
+	col := m.collections[id]
+	col.DeletedAt = now
+	m.deletedCollections[id] = col
+	m.deletedWith[id] = with
+	delete(m.collections, id)
+}
+
+func (m *Memory) MoveCollection(ctx context.Context, id, parentID int64, version int64) (*Collection, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	col, ok := m.collections[id]
+	if !ok {
+		return nil, notFound("collection", id)
+	}
+	if version != 0 && version != col.Version {
+		return nil, preconditionFailed("collection", id, col.Version)
+	}
+	for ancestor := parentID; ancestor != 0; {
+		if ancestor == id {
+			return nil, cycleError(id, parentID)
+		}
+		parent, ok := m.collections[ancestor]
+		if !ok {
+			return nil, notFound("collection", ancestor)
+		}
+		ancestor = parent.ParentID
+	}
+	col.ParentID = parentID
+	col.Version++
+	col.UpdatedAt = m.now.timestamp()
+	m.collections[id] = col
+	return &col, nil
+}
+
+func (m *Memory) ListChildCollections(ctx context.Context, parentID int64, opts ListOptions) ([]Collection, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.collections[parentID]; !ok {
+		return nil, "", notFound("collection", parentID)
+	}
+	var cols []Collection
+	for _, col := range m.collections {
+		if col.ParentID == parentID {
+			cols = append(cols, col)
+		}
+	}
+	return listRows(cols, opts)
+}
+
+func (m *Memory) CollectionSubtree(ctx context.Context, id int64) ([]Collection, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.collections[id]; !ok {
+		return nil, notFound("collection", id)
+	}
+	subtree := m.subtree(id)
+	cols := make([]Collection, 0, len(subtree))
+	for colID := range subtree {
+		cols = append(cols, m.collections[colID])
+	}
+	slices.SortFunc(cols, func(a, b Collection) int { return cmp.Compare(a.ID, b.ID) })
+	return cols, nil
+}
+
+func (m *Memory) DeleteCollectionTree(ctx context.Context, id int64, version int64) ([]int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	col, ok := m.collections[id]
+	if !ok {
+		if version != 0 {
+			return nil, notFound("collection", id)
+		}
+		return nil, nil
+	}
+	if version != 0 && version != col.Version {
+		return nil, preconditionFailed("collection", id, col.Version)
+	}
+	var ids []int64
+	now := m.now.timestamp()
+	for colID := range m.subtree(id) {
+		m.deleteCollection(colID, id, now)
+		ids = append(ids, colID)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// subtree returns the IDs of a collection and all its descendants. The
+// caller must hold the lock.
+func (m *Memory) subtree(id int64) map[int64]bool {
+	children := make(map[int64][]int64)
+	for _, col := range m.collections {
+		if col.ParentID != 0 {
+			children[col.ParentID] = append(children[col.ParentID], col.ID)
+		}
+	}
+	tree := map[int64]bool{id: true}
+	queue := []int64{id}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		for _, child := range children[next] {
+			tree[child] = true
+			queue = append(queue, child)
+		}
+	}
+	return tree
+}
+
+func (m *Memory) AddItemToCollection(ctx context.Context, collectionID, itemID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.mustBeManual(collectionID); err != nil {
+		return err
+	}
+	if _, ok := m.items[itemID]; !ok {
+		return notFound("item", itemID)
+	}
+	if err := m.mustMatchSchema(collectionID, itemID); err != nil {
+		return err
+	}
+	ms := membership{collectionID: collectionID, itemID: itemID}
+	if _, ok := m.memberships[ms]; !ok {
+		m.memberships[ms] = m.lastPosition(collectionID) + positionGap
+		m.touchMembers(collectionID)
+	}
+	return nil
+}
+
+func (m *Memory) ListItemsInCollection(ctx context.Context, collectionID int64, opts ListOptions) ([]Item, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	col, ok := m.collections[collectionID]
+	if !ok {
+		return nil, "", notFound("collection", collectionID)
+	}
+	var items []Item
+	if col.Rule != "" {
+		if opts.Recursive {
+			return nil, "", errRecursiveSmart
+		}
+		opts, err := ruleOptions(col.Rule, opts, m.now.timestamp())
+		if err != nil {
+			return nil, "", err
+		}
+		for _, item := range m.items {
+			items = append(items, item)
+		}
+		return listRows(items, opts)
+	}
+	if opts.Recursive {
+		tree := m.subtree(collectionID)
+		seen := make(map[int64]bool)
+		for ms := range m.memberships {
+			if !tree[ms.collectionID] || seen[ms.itemID] {
+				continue
+			}
+			if item, ok := m.items[ms.itemID]; ok {
+				seen[ms.itemID] = true
+				items = append(items, item)
+			}
+		}
+		return listRows(items, collectionOrder(opts))
+	}
+	for ms, pos := range m.memberships {
+		if ms.collectionID != collectionID {
+			continue
+		}
+		if item, ok := m.items[ms.itemID]; ok {
+			item.Position = pos
+			items = append(items, item)
+		}
+	}
+	return listRows(items, collectionOrder(opts))
+}
+
+func (m *Memory) ListCollectionsOfItem(ctx context.Context, itemID int64, opts ListOptions) ([]Collection, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.items[itemID]; !ok {
+		return nil, "", notFound("item", itemID)
+	}
+	var cols []Collection
+	for ms := range m.memberships {
+		if ms.itemID != itemID {
+			continue
+		}
+		if col, ok := m.collections[ms.collectionID]; ok {
+			cols = append(cols, col)
+		}
+	}
+	return listRows(cols, opts)
+}
+
+func (m *Memory) ItemCollectionIDs(ctx context.Context, itemIDs []int64) (map[int64][]int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	wanted := make(map[int64]bool, len(itemIDs))
+	for _, id := range itemIDs {
+		wanted[id] = true
+	}
+	out := make(map[int64][]int64)
+	for ms := range m.memberships {
+		if wanted[ms.itemID] {
+			out[ms.itemID] = append(out[ms.itemID], ms.collectionID)
+		}
+	}
+	for _, ids := range out {
+		slices.Sort(ids)
+	}
+	return out, nil
+}
+
+func (m *Memory) RemoveItemFromCollection(ctx context.Context, collectionID, itemID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.mustBeManual(collectionID); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	ms := membership{collectionID: collectionID, itemID: itemID}
+	if _, ok := m.memberships[ms]; ok {
+		delete(m.memberships, ms)
+		m.touchMembers(collectionID)
+	}
+	return nil
+}
+
+// bulkMembers applies a bulk membership change computed by results, which
+// receives the requested items that exist and the current members and
+// fills in the items to add and remove. Added items are appended, or with
+// reorder all the requested items are ranked in the order of itemIDs.
+func (m *Memory) bulkMembers(ctx context.Context, collectionID int64, itemIDs []int64, reorder bool, results func(existing, present map[int64]bool, invalid map[int64]error, added, removed *[]int64) []MembershipResult) ([]MembershipResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.mustBeManual(collectionID); err != nil {
+		return nil, err
+	}
+	existing := make(map[int64]bool)
+	for _, id := range itemIDs {
+		if _, ok := m.items[id]; ok {
+			existing[id] = true
+		}
+	}
+	present := make(map[int64]bool)
+	for ms := range m.memberships {
+		if ms.collectionID == collectionID {
+			present[ms.itemID] = true
+		}
+	}
+	invalid := m.schemaMismatches(collectionID, itemIDs)
+	var added, removed []int64
+	res := results(existing, present, invalid, &added, &removed)
+	for _, id := range removed {
+		delete(m.memberships, membership{collectionID: collectionID, itemID: id})
+	}
+	if reorder {
+		for i, id := range existingInOrder(itemIDs, existing, present, invalid) {
+			m.memberships[membership{collectionID: collectionID, itemID: id}] = int64(i+1) * positionGap
+		}
+	} else {
+		last := m.lastPosition(collectionID)
+		for i, id := range added {
+			m.memberships[membership{collectionID: collectionID, itemID: id}] = last + int64(i+1)*positionGap
+		}
+	}
+	if len(added)+len(removed) > 0 || reorder && len(existing) > 0 {
+		m.touchMembers(collectionID)
+	}
+	return res, nil
+}
+
+func (m *Memory) AddItemsToCollection(ctx context.Context, collectionID int64, itemIDs []int64) ([]MembershipResult, error) {
+	itemIDs = uniqueIDs(itemIDs)
+	return m.bulkMembers(ctx, collectionID, itemIDs, false, func(existing, present map[int64]bool, invalid map[int64]error, added, _ *[]int64) []MembershipResult {
+		return addResults(itemIDs, existing, present, invalid, added)
+	})
+}
+
+func (m *Memory) RemoveItemsFromCollection(ctx context.Context, collectionID int64, itemIDs []int64) ([]MembershipResult, error) {
+	itemIDs = uniqueIDs(itemIDs)
+	return m.bulkMembers(ctx, collectionID, itemIDs, false, func(_, present map[int64]bool, _ map[int64]error, _, removed *[]int64) []MembershipResult {
+		return removeResults(itemIDs, present, removed)
+	})
+}
+
+func (m *Memory) ReplaceCollectionItems(ctx context.Context, collectionID int64, itemIDs []int64) ([]MembershipResult, error) {
+	itemIDs = uniqueIDs(itemIDs)
+	return m.bulkMembers(ctx, collectionID, itemIDs, true, func(existing, present map[int64]bool, invalid map[int64]error, added, removed *[]int64) []MembershipResult {
+		return replaceResults(itemIDs, existing, present, invalid, added, removed)
+	})
+}
+
+func (m *Memory) InsertItemIntoCollection(ctx context.Context, collectionID, itemID int64, at Placement) error {
+	return m.placeItem(ctx, collectionID, itemID, at, true)
+}
+
+func (m *Memory) MoveItemInCollection(ctx context.Context, collectionID, itemID int64, to Placement) error {
+	return m.placeItem(ctx, collectionID, itemID, to, false)
+}
+
+func (m *Memory) placeItem(ctx context.Context, collectionID, itemID int64, at Placement, insert bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := at.validate(itemID); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.mustBeManual(collectionID); err != nil {
+		return err
+	}
+	ms := membership{collectionID: collectionID, itemID: itemID}
+	if _, ok := m.items[itemID]; insert && !ok {
+		return notFound("item", itemID)
+	}
+	if insert {
+		if err := m.mustMatchSchema(collectionID, itemID); err != nil {
+			return err
+		}
+	}
+	if _, ok := m.memberships[ms]; !insert && !ok {
+		return notMember(collectionID, itemID)
+	}
+	rank, ok, err := m.placementRank(collectionID, itemID, at)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		m.renumberMembers(collectionID)
+		rank, _, _ = m.placementRank(collectionID, itemID, at)
+	}
+	m.memberships[ms] = rank
+	m.touchMembers(collectionID)
+	return nil
+}
+
+// placementRank computes the rank that puts itemID at the placement among
+// the other members of the collection, like the package-level function.
+func (m *Memory) placementRank(collectionID, itemID int64, at Placement) (int64, bool, error) {
+	others := m.orderedMembers(collectionID, itemID)
+	next := len(others)
+	switch {
+	case at.Before != 0 || at.After != 0:
+		anchor := at.Before
+		if anchor == 0 {
+			anchor = at.After
+		}
+		next = slices.Index(others, anchor)
+		if next < 0 {
+			return 0, false, notMember(collectionID, anchor)
+		}
+		if at.After != 0 {
+			next++
+		}
+	case at.Index >= 0 && at.Index < len(others):
+		next = at.Index
+	}
+	var prevPos, nextPos *int64
+	if next > 0 {
+		pos := m.memberships[membership{collectionID: collectionID, itemID: others[next-1]}]
+		prevPos = &pos
+	}
+	if next < len(others) {
+		pos := m.memberships[membership{collectionID: collectionID, itemID: others[next]}]
+		nextPos = &pos
+	}
+	rank, ok := rankBetween(prevPos, nextPos)
+	return rank, ok, nil
+}
+
+// renumberMembers spreads the ranks of a collection's members evenly, like
+// the package-level function.
+func (m *Memory) renumberMembers(collectionID int64) {
+	for i, id := range m.orderedMembers(collectionID, 0) {
+		m.memberships[membership{collectionID: collectionID, itemID: id}] = int64(i+1) * positionGap
+	}
+}
+
+// orderedMembers returns the IDs of the members of a collection other than
+// except, in order.
+func (m *Memory) orderedMembers(collectionID, except int64) []int64 {
+	var ids []int64
+	for ms := range m.memberships {
+		if ms.collectionID == collectionID && ms.itemID != except {
+			ids = append(ids, ms.itemID)
+		}
+	}
+	slices.SortFunc(ids, func(a, b int64) int {
+		pa := m.memberships[membership{collectionID: collectionID, itemID: a}]
+		pb := m.memberships[membership{collectionID: collectionID, itemID: b}]
+		if c := cmp.Compare(pa, pb); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+	return ids
+}
+
+// lastPosition returns the highest position in a collection, or zero if it
+// is empty.
+func (m *Memory) lastPosition(collectionID int64) int64 {
+	var last int64
+	for ms, pos := range m.memberships {
+		if ms.collectionID == collectionID && pos > last {
+			last = pos
+		}
+	}
+	return last
+}
+
+// mustBeManual returns ErrNotFound unless the collection exists, and
+// ErrConflict if it is a smart collection. The caller must hold the lock.
+func (m *Memory) mustBeManual(collectionID int64) error {
+	col, ok := m.collections[collectionID]
+	if !ok {
+		return notFound("collection", collectionID)
+	}
+	if col.Rule != "" {
+		return smartError(collectionID)
+	}
+	return nil
+}
+
+// schemaMismatches returns why the custom fields of the items do not match
+// the schema of the collection, like the package-level function. The
+// caller must hold the lock.
+func (m *Memory) schemaMismatches(collectionID int64, ids []int64) map[int64]error {
+	fields := make(map[int64]map[string]any, len(ids))
+	for _, id := range ids {
+		fields[id] = m.items[id].Fields
+	}
+	return mismatches(m.collections[collectionID].Schema, ids, fields)
+}
+
+// mustMatchSchema returns ErrConflict if the custom fields of the item do
+// not match the schema of the collection. The caller must hold the lock.
+func (m *Memory) mustMatchSchema(collectionID, itemID int64) error {
+	if err := m.schemaMismatches(collectionID, []int64{itemID})[itemID]; err != nil {
+		return schemaMismatch(ErrConflict, itemID, collectionID, err)
+	}
+	return nil
+}
+
+// touchMembers records a change to the contents of a collection. The caller
+// must hold the write lock.
+func (m *Memory) touchMembers(collectionID int64) {
+	if col, ok := m.collections[collectionID]; ok {
+		col.MembersUpdatedAt = m.now.timestamp()
+		m.collections[collectionID] = col
+	}
+}
+
+func (m *Memory) AddItemTags(ctx context.Context, id int64, tags []string, version int64) (*Item, error) {
+	return m.changeItemTags(ctx, id, tags, true, version)
+}
+
+func (m *Memory) RemoveItemTags(ctx context.Context, id int64, tags []string, version int64) (*Item, error) {
+	return m.changeItemTags(ctx, id, tags, false, version)
+}
+
+func (m *Memory) changeItemTags(ctx context.Context, id int64, tags []string, add bool, version int64) (*Item, error) {
+	tags, err := NormalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, ok := m.items[id]
+	if !ok {
+		return nil, notFound("item", id)
+	}
+	if version != 0 && version != item.Version {
+		return nil, preconditionFailed("item", id, item.Version)
+	}
+	changed, ok := changeTagSet(item.Tags, tags, add)
+	if !ok {
+		return &item, nil
+	}
+	item.Tags = changed
+	item.UpdatedAt = m.now.timestamp()
+	item.Version++
+	m.items[id] = item
+	for ms := range m.memberships {
+		if ms.itemID == id {
+			m.touchMembers(ms.collectionID)
+		}
+	}
+	return &item, nil
+}
+
+func (m *Memory) ReplaceItemFields(ctx context.Context, id int64, fields map[string]any, version int64) (*Item, error) {
+	if err := validateFields(fields, false); err != nil {
+		return nil, err
+	}
+	return m.changeItemFields(ctx, id, version, func(map[string]any) map[string]any { return maps.Clone(fields) })
+}
+
+func (m *Memory) PatchItemFields(ctx context.Context, id int64, fields map[string]any, version int64) (*Item, error) {
+	if err := validateFields(fields, true); err != nil {
+		return nil, err
+	}
+	return m.changeItemFields(ctx, id, version, func(cur map[string]any) map[string]any {
+		return patchFields(cur, fields)
+	})
+}
+
+func (m *Memory) changeItemFields(ctx context.Context, id, version int64, change func(cur map[string]any) map[string]any) (*Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, ok := m.items[id]
+	if !ok {
+		return nil, notFound("item", id)
+	}
+	if version != 0 && version != item.Version {
+		return nil, preconditionFailed("item", id, item.Version)
+	}
+	fields := change(item.Fields)
+	if len(fields) > MaxFields {
+		return nil, fmt.Errorf("%w: an item must not have more than %d fields", ErrValidation, MaxFields)
+	}
+	if maps.Equal(fields, item.Fields) {
+		return &item, nil
+	}
+	var containing []int64
+	for ms := range m.memberships {
+		if ms.itemID == id {
+			containing = append(containing, ms.collectionID)
+		}
+	}
+	slices.Sort(containing)
+	for _, colID := range containing {
+		if err := checkSchema(m.collections[colID].Schema, fields); err != nil {
+			return nil, schemaMismatch(ErrValidation, id, colID, err)
+		}
+	}
+	if len(fields) == 0 {
+		fields = nil
+	}
+	item.Fields = fields
+	item.UpdatedAt = m.now.timestamp()
+	item.Version++
+	m.items[id] = item
+	for _, colID := range containing {
+		m.touchMembers(colID)
+	}
+	return &item, nil
+}
+
+func (m *Memory) SetCollectionSchema(ctx context.Context, id int64, schema []FieldDef, version int64) (*Collection, error) {
+	if err := validateSchema(schema); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	col, ok := m.collections[id]
+	if !ok {
+		return nil, notFound("collection", id)
+	}
+	if version != 0 && version != col.Version {
+		return nil, preconditionFailed("collection", id, col.Version)
+	}
+	var members []int64
+	fields := make(map[int64]map[string]any)
+	for ms := range m.memberships {
+		if ms.collectionID == id {
+			members = append(members, ms.itemID)
+			fields[ms.itemID] = m.items[ms.itemID].Fields
+		}
+	}
+	slices.Sort(members)
+	if err := firstMismatch(schema, members, fields, id); err != nil {
+		return nil, err
+	}
+	col.Schema = nil
+	if len(schema) > 0 {
+		col.Schema = slices.Clone(schema)
+	}
+	col.UpdatedAt = m.now.timestamp()
+	col.Version++
+	m.collections[id] = col
+	return &col, nil
+}
+
+func (m *Memory) ListDeletedItems(ctx context.Context, opts ListOptions) ([]Item, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return listRows(slices.Collect(maps.Values(m.deletedItems)), opts)
+}
+
+func (m *Memory) ListDeletedCollections(ctx context.Context, opts ListOptions) ([]Collection, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return listRows(slices.Collect(maps.Values(m.deletedCollections)), opts)
+}
+
+func (m *Memory) RestoreItem(ctx context.Context, id int64, version int64) (*Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, ok := m.deletedItems[id]
+	if !ok {
+		if _, ok := m.items[id]; ok {
+			return nil, notDeleted("item", id)
+		}
+		return nil, notFound("item", id)
+	}
+	if version != 0 && version != item.Version {
+		return nil, preconditionFailed("item", id, item.Version)
+	}
+	item.DeletedAt = time.Time{}
+	item.UpdatedAt = m.now.timestamp()
+	item.Version++
+	m.items[id] = item
+	delete(m.deletedItems, id)
+	m.restoreMemberships(func(ms membership) bool { return ms.itemID == id })
+	return &item, nil
+}
+
+func (m *Memory) RestoreCollection(ctx context.Context, id int64, version int64) (*Collection, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	col, ok := m.deletedCollections[id]
+	if !ok {
+		if _, ok := m.collections[id]; ok {
+			return nil, notDeleted("collection", id)
+		}
+		return nil, notFound("collection", id)
+	}
+	if version != 0 && version != col.Version {
+		return nil, preconditionFailed("collection", id, col.Version)
+	}
+	if _, ok := m.collections[col.ParentID]; col.ParentID != 0 && !ok {
+		return nil, fmt.Errorf("%w: parent collection %d is deleted; restore it first", ErrConflict, col.ParentID)
+	}
+	// Descendants that were already in the trash when the collection was
+	// deleted stay there.
+	children := make(map[int64][]int64)
+	for _, c := range m.deletedCollections {
+		children[c.ParentID] = append(children[c.ParentID], c.ID)
+	}
+	restored := make(map[int64]bool)
+	now := m.now.timestamp()
+	for queue := []int64{id}; len(queue) > 0; queue = queue[1:] {
+		next := m.deletedCollections[queue[0]]
+		if m.deletedWith[next.ID] == id {
+			restored[next.ID] = true
+			next.DeletedAt = time.Time{}
+			next.UpdatedAt = now
+			next.Version++
+			m.collections[next.ID] = next
+		}
+		queue = append(queue, children[next.ID]...)
+	}
+	for colID := range restored {
+		delete(m.deletedCollections, colID)
+		delete(m.deletedWith, colID)
+	}
+	m.restoreMemberships(func(ms membership) bool { return restored[ms.collectionID] })
+	col = m.collections[id]
+	return &col, nil
+}
+
+// restoreMemberships moves the deleted memberships selected by match back
+// to their former position if both their collection and item are live,
+// renumbering collections where that position has since been taken. Like
+// the MariaDB implementation, memberships of smart collections and of
+// collections whose schema the item does not match are dropped. The caller
+// must hold the write lock.
+func (m *Memory) restoreMemberships(match func(ms membership) bool) {
+	touched := make(map[int64]bool)
+	for ms, pos := range m.deletedMemberships {
+		if !match(ms) {
+			continue
+		}
+		col, colOK := m.collections[ms.collectionID]
+		item, itemOK := m.items[ms.itemID]
+		if !colOK || !itemOK {
+			continue
+		}
+		delete(m.deletedMemberships, ms)
+		if col.Rule != "" || checkSchema(col.Schema, item.Fields) != nil {
+			continue
+		}
+		if _, ok := m.memberships[ms]; !ok {
+			m.memberships[ms] = pos
+			m.touchMembers(ms.collectionID)
+			touched[ms.collectionID] = true
+		}
+	}
+	for colID := range touched {
+		taken := make(map[int64]bool)
+		for ms, pos := range m.memberships {
+			if ms.collectionID == colID {
+				if taken[pos] {
+					m.renumberMembers(colID)
+					break
+				}
+				taken[pos] = true
+			}
+		}
+	}
+}
+
+func (m *Memory) PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	before := m.now.timestamp().Add(-retention)
+	var n int64
+	for id, item := range m.deletedItems {
+		if !item.DeletedAt.After(before) {
+			delete(m.deletedItems, id)
+			n++
+		}
+	}
+	for id, col := range m.deletedCollections {
+		if !col.DeletedAt.After(before) {
+			delete(m.deletedCollections, id)
+			delete(m.deletedWith, id)
+			n++
+		}
+	}
+	for ms := range m.deletedMemberships {
+		_, item := m.deletedItems[ms.itemID]
+		_, col := m.deletedCollections[ms.collectionID]
+		if !item && !col {
+			delete(m.deletedMemberships, ms)
+		}
+	}
+	return n, nil
+}
+
+func (m *Memory) AddCollectionTags(ctx context.Context, id int64, tags []string, version int64) (*Collection, error) {
+	return m.changeCollectionTags(ctx, id, tags, true, version)
+}
+
+func (m *Memory) RemoveCollectionTags(ctx context.Context, id int64, tags []string, version int64) (*Collection, error) {
+	return m.changeCollectionTags(ctx, id, tags, false, version)
+}
+
+func (m *Memory) changeCollectionTags(ctx context.Context, id int64, tags []string, add bool, version int64) (*Collection, error) {
+	tags, err := NormalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	col, ok := m.collections[id]
+	if !ok {
+		return nil, notFound("collection", id)
+	}
+	if version != 0 && version != col.Version {
+		return nil, preconditionFailed("collection", id, col.Version)
+	}
+	changed, ok := changeTagSet(col.Tags, tags, add)
+	if !ok {
+		return &col, nil
+	}
+	col.Tags = changed
+	col.UpdatedAt = m.now.timestamp()
+	col.Version++
+	m.collections[id] = col
+	return &col, nil
+}
+
+// changeTagSet returns a new sorted slice with tags added to or removed
+// from cur, and whether that changed anything. cur is never modified, since
+// copies of the rows share it.
+func changeTagSet(cur, tags []string, add bool) ([]string, bool) {
+	var out []string
+	for _, tag := range cur {
+		if add || !slices.Contains(tags, tag) {
+			out = append(out, tag)
+		}
+	}
+	if add {
+		for _, tag := range tags {
+			if !slices.Contains(cur, tag) {
+				out = append(out, tag)
+			}
+		}
+		slices.Sort(out)
+	}
+	return out, len(out) != len(cur)
+}
+
+func (m *Memory) ListTags(ctx context.Context, opts TagListOptions) ([]TagCount, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	prefix := strings.ToLower(opts.Prefix)
+	byTag := make(map[string]*TagCount)
+	count := func(tags []string) []*TagCount {
+		var out []*TagCount
+		for _, tag := range tags {
+			if !strings.HasPrefix(tag, prefix) {
+				continue
+			}
+			if byTag[tag] == nil {
+				byTag[tag] = &TagCount{Tag: tag}
+			}
+			out = append(out, byTag[tag])
+		}
+		return out
+	}
+	for _, item := range m.items {
+		for _, c := range count(item.Tags) {
+			c.Items++
+		}
+	}
+	for _, col := range m.collections {
+		for _, c := range count(col.Tags) {
+			c.Collections++
+		}
+	}
+	counts := make([]TagCount, 0, len(byTag))
+	for _, c := range byTag {
+		counts = append(counts, *c)
+	}
+	sortTagCounts(counts)
+	if opts.Limit > 0 && len(counts) > opts.Limit {
+		counts = counts[:opts.Limit]
+	}
+	return counts, nil
+}
+
+func (m *Memory) ReserveIdempotencyKey(ctx context.Context, key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now.timestamp()
+	if rec, ok := m.idempotency[key]; ok {
+		abandoned := rec.StatusCode == 0 && !rec.CreatedAt.After(now.Add(-idempotencyAbandonAfter))
+		if rec.ExpiresAt.After(now) && !abandoned {
+			return &rec, nil
+		}
+	}
+	m.idempotency[key] = IdempotencyRecord{Key: key, Fingerprint: fingerprint, CreatedAt: now, ExpiresAt: now.Add(ttl)}
+	return nil, nil
+}
+
+func (m *Memory) CompleteIdempotencyKey(ctx context.Context, key string, status int, header map[string][]string, body []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if rec, ok := m.idempotency[key]; ok {
+		rec.StatusCode = status
+		rec.Header = header
+		rec.Body = body
+		m.idempotency[key] = rec
+	}
+	return nil
+}
+
+func (m *Memory) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.idempotency, key)
+	return nil
+}
+
+func (m *Memory) PurgeIdempotencyKeys(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now.timestamp()
+	var n int64
+	for key, rec := range m.idempotency {
+		if !rec.ExpiresAt.After(now) {
+			delete(m.idempotency, key)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,14 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/mmontes11/opencode-test/store"
+	"github.com/mmontes11/opencode-test/store/storetest"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, now store.Clock) store.Store {
+		return store.NewMemoryWithClock(now)
+	})
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/mmontes11/opencode-test/store/filter"
+)
+
+// ListOptions controls filtering, ordering and keyset pagination of list
+// operations. A zero Limit means no limit, a nil Filter matches everything
+// and an empty Sort orders by ID. Recursive only applies to
+// ListItemsInCollection, where it includes the items of descendant
+// collections.
+//
+// TagsAll and TagsAny hold normalized tags, see NormalizeTags. A row must
+// carry every tag in TagsAll and, unless TagsAny is empty, at least one tag
+// in TagsAny.
+type ListOptions struct {
+	Limit     int
+	Cursor    string
+	Filter    filter.Expr
+	Sort      []filter.SortKey
+	Recursive bool
+	TagsAll   []string
+	TagsAny   []string
+}
+
+// cursor is the decoded form of the opaque pagination cursor. It records the
+// sort order and the sort key values of the last row of the previous page.
+type cursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+}
+
+func encodeCursor(c cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor returns nil for an empty string, which starts from the first
+// row.
+func decodeCursor(s string, keys []filter.SortKey) (*cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if c.Sort != filter.FormatSort(keys) || len(c.Values) != len(keys) {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// sortKeys returns the requested sort keys with ID appended as a tiebreaker,
+// so that every row has a unique position.
+func sortKeys(keys []filter.SortKey) []filter.SortKey {
+	for i, k := range keys {
+		if k.Field == "id" {
+			return keys[:i+1]
+		}
+	}
+	out := make([]filter.SortKey, 0, len(keys)+1)
+	out = append(out, keys...)
+	return append(out, filter.SortKey{Field: "id"})
+}
+
+// collectionOrder makes the items of a collection list in their manual
+// order unless another sort is requested. Recursive listings span several
+// collections and have no manual order, so they keep the ID order.
+func collectionOrder(opts ListOptions) ListOptions {
+	if len(opts.Sort) == 0 && !opts.Recursive {
+		opts.Sort = []filter.SortKey{{Field: "position"}}
+	}
+	return opts
+}
+
+// row is implemented by the types returned from list operations.
+type row interface {
+	fieldValue(field string) string
+	tags() []string
+	// custom returns the value of a custom field, if the row has it.
+	custom(name string) (any, bool)
+}
+
+// paginate trims rows, which must be fetched with one row more than the
+// limit, and returns the cursor of the next page, if any.
+func paginate[T row](rows []T, opts ListOptions) ([]T, string) {
+	if opts.Limit <= 0 || len(rows) <= opts.Limit {
+		return rows, ""
+	}
+	rows = rows[:opts.Limit]
+	keys := sortKeys(opts.Sort)
+	c := cursor{Sort: filter.FormatSort(keys), Values: keyValues(rows[len(rows)-1], keys)}
+	return rows, encodeCursor(c)
+}
+
+// keyValues returns the values of the sort keys of a row.
+func keyValues(r row, keys []filter.SortKey) []string {
+	values := make([]string, len(keys))
+	for i, k := range keys {
+		values[i] = r.fieldValue(k.Field)
+	}
+	return values
+}
@@ -0,0 +1,182 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// positionGap is the distance between the ranks of consecutive items when
+// they are appended or renumbered. Moving an item gives it the midpoint of
+// its new neighbors, so a collection is only renumbered when two neighbors
+// have run out of room between them.
+const positionGap = 1024
+
+// Placement says where an item goes in an ordered collection. Before or
+// After names the item to place it next to; if neither is set, Index is
+// the zero-based position it should end up at. A negative Index, or one
+// past the last item, places it at the end.
+type Placement struct {
+	Index  int
+	Before int64
+	After  int64
+}
+
+// AtEnd places an item after the last one.
+var AtEnd = Placement{Index: -1}
+
+// validate rejects placements that name more than one anchor or place an
+// item next to itself.
+func (p Placement) validate(itemID int64) error {
+	if p.Before != 0 && p.After != 0 {
+		return fmt.Errorf("%w: only one of before and after may be given", ErrValidation)
+	}
+	if p.Before == itemID || p.After == itemID {
+		return fmt.Errorf("%w: item %d cannot be placed next to itself", ErrValidation, itemID)
+	}
+	return nil
+}
+
+// notMember reports an item that is not in the collection.
+func notMember(collectionID, itemID int64) error {
+	return fmt.Errorf("item %d is not in collection %d: %w", itemID, collectionID, ErrNotFound)
+}
+
+// rankBetween returns a rank between prev and next, where nil means there
+// is no neighbor on that side. It returns false if there is no room.
+func rankBetween(prev, next *int64) (int64, bool) {
+	switch {
+	case prev == nil && next == nil:
+		return positionGap, true
+	case next == nil:
+		return *prev + positionGap, true
+	case prev == nil:
+		return *next - positionGap, true
+	case *next-*prev > 1:
+		return *prev + (*next-*prev)/2, true
+	}
+	return 0, false
+}
+
+// InsertItemIntoCollection adds an item to a collection at the given
+// placement. An item that is already a member is moved there instead.
+func InsertItemIntoCollection(ctx context.Context, q Querier, collectionID, itemID int64, at Placement, now time.Time) error {
+	return placeItem(ctx, q, collectionID, itemID, at, true, now)
+}
+
+// MoveItemInCollection moves a member of a collection to the given
+// placement. It returns ErrNotFound if the item is not a member.
+func MoveItemInCollection(ctx context.Context, q Querier, collectionID, itemID int64, to Placement, now time.Time) error {
+	return placeItem(ctx, q, collectionID, itemID, to, false, now)
+}
+
+func placeItem(ctx context.Context, q Querier, collectionID, itemID int64, at Placement, insert bool, now time.Time) error {
+	if err := at.validate(itemID); err != nil {
+		return err
+	}
+	return withTx(ctx, q, func(q Querier) error {
+		// Locking the collection serializes reorderings, so that two moves
+		// cannot pick the same rank.
+		if err := mustBeManual(ctx, q, collectionID, "FOR UPDATE"); err != nil {
+			return err
+		}
+		if insert {
+			if err := mustExist(ctx, q, "item", itemID); err != nil {
+				return err
+			}
+			if err := mustMatchSchema(ctx, q, collectionID, itemID); err != nil {
+				return err
+			}
+		} else if _, err := memberPosition(ctx, q, collectionID, itemID); err != nil {
+			return err
+		}
+		rank, ok, err := placementRank(ctx, q, collectionID, itemID, at)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if err := renumberMembers(ctx, q, collectionID); err != nil {
+				return err
+			}
+			if rank, _, err = placementRank(ctx, q, collectionID, itemID, at); err != nil {
+				return err
+			}
+		}
+		_, err = q.ExecContext(ctx, "INSERT INTO collection_items (collection_id, item_id, position) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE position = VALUES(position)", collectionID, itemID, rank)
+		if err != nil {
+			return err
+		}
+		return bumpMembers(ctx, q, collectionID, true, now)
+	})
+}
+
+// memberPosition returns the rank of a member of a collection.
+func memberPosition(ctx context.Context, q Querier, collectionID, itemID int64) (int64, error) {
+	var pos int64
+	err := q.QueryRowContext(ctx, "SELECT position FROM collection_items WHERE collection_id = ? AND item_id = ?", collectionID, itemID).Scan(&pos)
+	if err == sql.ErrNoRows {
+		return 0, notMember(collectionID, itemID)
+	}
+	return pos, err
+}
+
+// placementRank computes the rank that puts itemID at the placement among
+// the other members of the collection.
+func placementRank(ctx context.Context, q Querier, collectionID, itemID int64, at Placement) (int64, bool, error) {
+	var next sql.NullInt64
+	switch {
+	case at.Before != 0:
+		pos, err := memberPosition(ctx, q, collectionID, at.Before)
+		if err != nil {
+			return 0, false, err
+		}
+		next = sql.NullInt64{Int64: pos, Valid: true}
+	case at.After != 0:
+		pos, err := memberPosition(ctx, q, collectionID, at.After)
+		if err != nil {
+			return 0, false, err
+		}
+		prev := pos
+		err = q.QueryRowContext(ctx, "SELECT MIN(position) FROM collection_items WHERE collection_id = ? AND item_id <> ? AND position > ?", collectionID, itemID, pos).Scan(&next)
+		if err != nil {
+			return 0, false, err
+		}
+		rank, ok := rankBetween(&prev, nullInt64(next))
+		return rank, ok, nil
+	case at.Index >= 0:
+		err := q.QueryRowContext(ctx, "SELECT position FROM collection_items WHERE collection_id = ? AND item_id <> ? ORDER BY position, item_id LIMIT 1 OFFSET ?", collectionID, itemID, at.Index).Scan(&next)
+		if err != nil && err != sql.ErrNoRows {
+			return 0, false, err
+		}
+	}
+	var prev sql.NullInt64
+	var err error
+	if next.Valid {
+		err = q.QueryRowContext(ctx, "SELECT MAX(position) FROM collection_items WHERE collection_id = ? AND item_id <> ? AND position < ?", collectionID, itemID, next.Int64).Scan(&prev)
+	} else {
+		err = q.QueryRowContext(ctx, "SELECT MAX(position) FROM collection_items WHERE collection_id = ? AND item_id <> ?", collectionID, itemID).Scan(&prev)
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	rank, ok := rankBetween(nullInt64(prev), nullInt64(next))
+	return rank, ok, nil
+}
+
+func nullInt64(n sql.NullInt64) *int64 {
+	if !n.Valid {
+		return nil
+	}
+	return &n.Int64
+}
+
+// renumberMembers spreads the ranks of a collection's members positionGap
+// apart, keeping their order.
+func renumberMembers(ctx context.Context, q Querier, collectionID int64) error {
+	_, err := q.ExecContext(ctx, `UPDATE collection_items ci
+		JOIN (SELECT item_id, ROW_NUMBER() OVER (ORDER BY position, item_id) AS n FROM collection_items WHERE collection_id = ?) r ON ci.item_id = r.item_id
+		SET ci.position = r.n * ?
+		WHERE ci.collection_id = ?`, collectionID, positionGap, collectionID)
+	return err
+}
@@ -0,0 +1,252 @@
+package store
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mmontes11/opencode-test/store/filter"
+)
+
+// selectQuery builds a list query from a base SELECT of rows of the given
+// kind, the conditions the caller already needs and their arguments. The
+// filter, tag, keyset and ordering clauses refer to columns through prefix,
+// e.g. "i." when items are joined, or through the table name if prefix is
+// empty. One row more than the limit is requested so that paginate can tell
+// whether a next page exists.
+func selectQuery(base, kind, prefix string, conds []string, args []any, opts ListOptions) (string, []any, error) {
+	keys := sortKeys(opts.Sort)
+	c, err := decodeCursor(opts.Cursor, keys)
+	if err != nil {
+		return "", nil, err
+	}
+	if opts.Filter != nil {
+		cond, fargs := compileFilter(opts.Filter, kind, prefix)
+		conds = append(conds, cond)
+		args = append(args, fargs...)
+	}
+	tconds, targs := tagConditions(kind, idColumn(kind, prefix), opts)
+	conds = append(conds, tconds...)
+	args = append(args, targs...)
+	if c != nil {
+		cond, kargs := keysetCondition(keys, c.Values, kind, prefix)
+		conds = append(conds, cond)
+		args = append(args, kargs...)
+	}
+	query := base
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	order := make([]string, len(keys))
+	for i, k := range keys {
+		order[i] = sortColumn(k.Field, kind, prefix)
+		if k.Desc {
+			order[i] += " DESC"
+		}
+	}
+	query += " ORDER BY " + strings.Join(order, ", ")
+	if opts.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, opts.Limit+1)
+	}
+	return query, args, nil
+}
+
+// idColumn returns the ID column of rows of the given kind, referred to
+// through prefix or, if it is empty, through the table name.
+func idColumn(kind, prefix string) string {
+	if prefix == "" {
+		return kind + "s.id"
+	}
+	return prefix + "id"
+}
+
+// compileFilter translates a filter AST over rows of the given kind into a
+// parameterized SQL condition. Field names come from the filter package's
+// whitelist, never from raw input.
+func compileFilter(e filter.Expr, kind, prefix string) (string, []any) {
+	switch e := e.(type) {
+	case *filter.And:
+		l, largs := compileFilter(e.Left, kind, prefix)
+		r, rargs := compileFilter(e.Right, kind, prefix)
+		return "(" + l + " AND " + r + ")", append(largs, rargs...)
+	case *filter.Or:
+		l, largs := compileFilter(e.Left, kind, prefix)
+		r, rargs := compileFilter(e.Right, kind, prefix)
+		return "(" + l + " OR " + r + ")", append(largs, rargs...)
+	case *filter.Not:
+		s, args := compileFilter(e.Expr, kind, prefix)
+		return "NOT " + s, args
+	case *filter.Comparison:
+		if e.Type == filter.Tag {
+			cond := hasTag(kind, idColumn(kind, prefix)) + "= ?)"
+			if e.Op == filter.Ne {
+				cond = "NOT " + cond
+			}
+			return cond, []any{e.Value}
+		}
+		if e.Type == filter.Custom {
+			return customCondition(e, idColumn(kind, prefix))
+		}
+		col := prefix + e.Field
+		switch e.Op {
+		case filter.Contains:
+			return col + " LIKE ?", []any{"%" + escapeLike(e.Value) + "%"}
+		case filter.NotContains:
+			return col + " NOT LIKE ?", []any{"%" + escapeLike(e.Value) + "%"}
+		case filter.Ne:
+			return col + " <> ?", []any{e.Value}
+		default:
+			return col + " " + string(e.Op) + " ?", []any{e.Value}
+		}
+	}
+	panic(fmt.Sprintf("store: unknown filter node %T", e))
+}
+
+// escapeLike escapes the LIKE wildcards so that the value matches literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// sortColumn returns the expression a field of rows of the given kind is
+// sorted by.
+func sortColumn(field, kind, prefix string) string {
+	if name, ok := filter.CustomName(field); ok {
+		return customSortColumn(name, idColumn(kind, prefix))
+	}
+	return prefix + field
+}
+
+// keysetCondition selects the rows that sort after the given key values:
+// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ..., with < for descending keys.
+func keysetCondition(keys []filter.SortKey, values []string, kind, prefix string) (string, []any) {
+	var ors []string
+	var args []any
+	for i, k := range keys {
+		var ands []string
+		for j := 0; j < i; j++ {
+			ands = append(ands, sortColumn(keys[j].Field, kind, prefix)+" = ?")
+			args = append(args, values[j])
+		}
+		op := " > ?"
+		if k.Desc {
+			op = " < ?"
+		}
+		ands = append(ands, sortColumn(k.Field, kind, prefix)+op)
+		args = append(args, values[i])
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
+
+// listRows filters, sorts and pages rows in memory with the same semantics as
+// selectQuery. String comparisons are case-insensitive, like MariaDB's
+// default collation.
+func listRows[T row](rows []T, opts ListOptions) ([]T, string, error) {
+	keys := sortKeys(opts.Sort)
+	c, err := decodeCursor(opts.Cursor, keys)
+	if err != nil {
+		return nil, "", err
+	}
+	var out []T
+	for _, r := range rows {
+		if opts.Filter != nil && !matchFilter(opts.Filter, r) {
+			continue
+		}
+		if !matchTags(r.tags(), opts) {
+			continue
+		}
+		if c != nil && compareKeys(keys, r, c.Values) <= 0 {
+			continue
+		}
+		out = append(out, r)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return compareKeys(keys, out[i], keyValues(out[j], keys)) < 0
+	})
+	out, next := paginate(out, opts)
+	return out, next, nil
+}
+
+// compareKeys compares a row with a tuple of sort key values, honoring the
+// direction of each key.
+func compareKeys(keys []filter.SortKey, r row, values []string) int {
+	for i, k := range keys {
+		c := compareField(k.Field, r.fieldValue(k.Field), values[i])
+		if k.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compareField(field, a, b string) int {
+	if field == "id" || field == "position" {
+		x, _ := strconv.ParseInt(a, 10, 64)
+		y, _ := strconv.ParseInt(b, 10, 64)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	if _, ok := filter.CustomName(field); ok || filter.DefaultFields[field] == filter.Time {
+		return strings.Compare(a, b)
+	}
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+func matchFilter(e filter.Expr, r row) bool {
+	switch e := e.(type) {
+	case *filter.And:
+		return matchFilter(e.Left, r) && matchFilter(e.Right, r)
+	case *filter.Or:
+		return matchFilter(e.Left, r) || matchFilter(e.Right, r)
+	case *filter.Not:
+		return !matchFilter(e.Expr, r)
+	case *filter.Comparison:
+		if e.Type == filter.Tag {
+			return slices.Contains(r.tags(), e.Value) == (e.Op == filter.Eq)
+		}
+		if e.Type == filter.Custom {
+			name, _ := filter.CustomName(e.Field)
+			v, ok := r.custom(name)
+			return matchCustom(e, v, ok)
+		}
+		v := r.fieldValue(e.Field)
+		switch e.Op {
+		case filter.Contains:
+			return strings.Contains(strings.ToLower(v), strings.ToLower(e.Value))
+		case filter.NotContains:
+			return !strings.Contains(strings.ToLower(v), strings.ToLower(e.Value))
+		}
+		return compareOp(compareField(e.Field, v, e.Value), e.Op)
+	}
+	panic(fmt.Sprintf("store: unknown filter node %T", e))
+}
+
+// compareOp reports whether the result c of a comparison satisfies op.
+func compareOp(c int, op filter.Op) bool {
+	switch op {
+	case filter.Eq:
+		return c == 0
+	case filter.Ne:
+		return c != 0
+	case filter.Lt:
+		return c < 0
+	case filter.Le:
+		return c <= 0
+	case filter.Gt:
+		return c > 0
+	case filter.Ge:
+		return c >= 0
+	}
+	return false
+}
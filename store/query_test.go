@@ -0,0 +1,94 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mmontes11/opencode-test/store/filter"
+)
+
+func TestSelectQuery(t *testing.T) {
+	expr, err := filter.Parse(`name~"50%" and not created_at>2026-01-01`, filter.DefaultFields)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	keys := []filter.SortKey{{Field: "created_at", Desc: true}, {Field: "name"}}
+	cur := encodeCursor(cursor{Sort: "-created_at,name,id", Values: []string{"2026-01-02 00:00:00", "b", "7"}})
+	opts := ListOptions{Limit: 10, Cursor: cur, Filter: expr, Sort: keys}
+
+	query, args, err := selectQuery("SELECT i.id FROM items i", "item", "i.", []string{"x = ?"}, []any{1}, opts)
+	if err != nil {
+		t.Fatalf("selectQuery: %v", err)
+	}
+	wantQuery := "SELECT i.id FROM items i WHERE x = ? AND (i.name LIKE ? AND NOT i.created_at > ?)" +
+		" AND ((i.created_at < ?) OR (i.created_at = ? AND i.name > ?) OR (i.created_at = ? AND i.name = ? AND i.id > ?))" +
+		" ORDER BY i.created_at DESC, i.name, i.id LIMIT ?"
+	if query != wantQuery {
+		t.Fatalf("query =\n%s\nwant\n%s", query, wantQuery)
+	}
+	wantArgs := []any{1, `%50\%%`, "2026-01-01 00:00:00",
+		"2026-01-02 00:00:00", "2026-01-02 00:00:00", "b", "2026-01-02 00:00:00", "b", "7", 11}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Fatalf("args = %#v, want %#v", args, wantArgs)
+	}
+
+	// A cursor issued for another sort order is rejected.
+	opts.Sort = nil
+	if _, _, err := selectQuery("SELECT id FROM items", "item", "", nil, nil, opts); err != ErrInvalidCursor {
+		t.Fatalf("expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestSelectQueryTags(t *testing.T) {
+	opts := ListOptions{TagsAll: []string{"a", "b"}, TagsAny: []string{"c", "d"}}
+	query, args, err := selectQuery("SELECT id FROM collections", "collection", "", nil, nil, opts)
+	if err != nil {
+		t.Fatalf("selectQuery: %v", err)
+	}
+	wantQuery := "SELECT id FROM collections WHERE" +
+		" EXISTS (SELECT 1 FROM collection_tags tg WHERE tg.collection_id = collections.id AND tg.tag = ?)" +
+		" AND EXISTS (SELECT 1 FROM collection_tags tg WHERE tg.collection_id = collections.id AND tg.tag = ?)" +
+		" AND EXISTS (SELECT 1 FROM collection_tags tg WHERE tg.collection_id = collections.id AND tg.tag IN (?, ?))" +
+		" ORDER BY id"
+	if query != wantQuery {
+		t.Fatalf("query =\n%s\nwant\n%s", query, wantQuery)
+	}
+	if want := []any{"a", "b", "c", "d"}; !reflect.DeepEqual(args, want) {
+		t.Fatalf("args = %#v, want %#v", args, want)
+	}
+}
+
+func TestSelectQueryCustomFields(t *testing.T) {
+	expr, err := filter.Parse(`fields.priority>=3 and fields.status!=done`, filter.ItemFields)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	keys, _ := filter.ParseSort("-fields.priority", filter.ItemFields)
+	cur := encodeCursor(cursor{Sort: "-fields.priority,id", Values: []string{"n4008000000000000", "7"}})
+	query, args, err := selectQuery("SELECT id FROM items", "item", "", nil, nil, ListOptions{Filter: expr, Sort: keys, Cursor: cur})
+	if err != nil {
+		t.Fatalf("selectQuery: %v", err)
+	}
+	sortKey := "COALESCE((SELECT f.sort_key FROM item_fields f WHERE f.item_id = items.id AND f.name = 'priority'), '')"
+	wantQuery := "SELECT id FROM items WHERE" +
+		" (EXISTS (SELECT 1 FROM item_fields f WHERE f.item_id = items.id AND f.name = ? AND IF(f.number IS NULL, f.value >= ?, f.number >= ?))" +
+		" AND NOT EXISTS (SELECT 1 FROM item_fields f WHERE f.item_id = items.id AND f.name = ? AND IF(f.number IS NULL, f.value = ?, f.number = ?)))" +
+		" AND ((" + sortKey + " < ?) OR (" + sortKey + " = ? AND id > ?))" +
+		" ORDER BY " + sortKey + " DESC, id"
+	if query != wantQuery {
+		t.Fatalf("query =\n%s\nwant\n%s", query, wantQuery)
+	}
+	wantArgs := []any{"priority", "3", 3.0, "status", "done", nil, "n4008000000000000", "n4008000000000000", "7"}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Fatalf("args = %#v, want %#v", args, wantArgs)
+	}
+}
+
+func TestFieldSortKey(t *testing.T) {
+	values := []any{nil, false, true, -1e9, -0.5, 0.0, 2.0, 10.0, "Apple", "banana"}
+	for i := 1; i < len(values); i++ {
+		if a, b := fieldSortKey(values[i-1]), fieldSortKey(values[i]); a >= b {
+			t.Fatalf("sort key of %v (%q) is not below that of %v (%q)", values[i-1], a, values[i], b)
+		}
+	}
+}
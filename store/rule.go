@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mmontes11/opencode-test/store/filter"
+)
+
+// MaxRuleLength is the maximum length of a smart collection's rule in
+// characters.
+const MaxRuleLength = 1024
+
+// validateRule returns ErrValidation unless rule is a valid filter
+// expression over the fields of items.
+func validateRule(rule string) error {
+	if utf8.RuneCountInString(rule) > MaxRuleLength {
+		return fmt.Errorf("%w: rule is longer than %d characters", ErrValidation, MaxRuleLength)
+	}
+	expr, err := filter.Parse(rule, filter.ItemFields)
+	if err != nil {
+		return fmt.Errorf("%w: invalid rule: %v", ErrValidation, err)
+	}
+	if expr == nil {
+		return fmt.Errorf("%w: rule must not be empty", ErrValidation)
+	}
+	return nil
+}
+
+// ruleOptions returns opts restricted to the items matching rule, with
+// relative times resolved against now.
+func ruleOptions(rule string, opts ListOptions, now time.Time) (ListOptions, error) {
+	expr, err := filter.ParseAt(rule, filter.ItemFields, now)
+	if err != nil {
+		return opts, fmt.Errorf("invalid stored rule %q: %w", rule, err)
+	}
+	if opts.Filter != nil {
+		expr = &filter.And{Left: expr, Right: opts.Filter}
+	}
+	opts.Filter = expr
+	return opts, nil
+}
+
+// errItemRule is returned when a patch of an item sets a rule.
+var errItemRule = fmt.Errorf("%w: items have no rule", ErrValidation)
+
+// errRecursiveSmart is returned when the items of a smart collection are
+// listed recursively.
+var errRecursiveSmart = fmt.Errorf("%w: the items of a smart collection cannot be listed recursively", ErrValidation)
+
+// smartError reports a membership change of a smart collection.
+func smartError(id int64) error {
+	return fmt.Errorf("%w: collection %d is smart; its items are selected by its rule", ErrConflict, id)
+}
+
+// mustBeManual returns ErrNotFound unless the collection exists, and
+// ErrConflict if it is a smart collection. lock is appended to the query,
+// e.g. "LOCK IN SHARE MODE".
+func mustBeManual(ctx context.Context, q Querier, id int64, lock string) error {
+	var rule sql.NullString
+	err := q.QueryRowContext(ctx, "SELECT rule FROM collections WHERE id = ? AND deleted_at IS NULL "+lock, id).Scan(&rule)
+	if err != nil {
+		return dbError(err, "collection", id)
+	}
+	if rule.String != "" {
+		return smartError(id)
+	}
+	return nil
+}
+
+// CreateSmartCollection inserts a collection whose items are the items
+// matching rule, a filter expression that is evaluated whenever the items
+// are listed. It returns ErrValidation if the rule is invalid.
+func CreateSmartCollection(ctx context.Context, q Querier, name, description, rule string, now time.Time) (*Collection, error) {
+	if err := validateRule(rule); err != nil {
+		return nil, err
+	}
+	var col *Collection
+	err := withTx(ctx, q, func(q Querier) error {
+		res, err := q.ExecContext(ctx, "INSERT INTO collections (name, description, rule, created_at, updated_at, members_updated_at) VALUES (?, ?, ?, ?, ?, ?)", name, description, rule, now, now, now)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		col, err = GetCollection(ctx, q, id)
+		return err
+	})
+	return col, err
+}
@@ -1,199 +1,704 @@
 package store
 
 import (
+	"context"
 	"database/sql"
-	// Removed time import
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/mmontes11/opencode-test/store/filter"
 )
 
+// Clock returns the current time. Stores stamp every write with it, so tests
+// can inject a deterministic clock.
+type Clock func() time.Time
+
+// timestamp returns the current time as it is stored: in UTC and truncated
+// to whole seconds, like the DATETIME columns.
+func (c Clock) timestamp() time.Time {
+	return c().UTC().Truncate(time.Second)
+}
+
+// Store is the persistence API used by the HTTP handlers. It covers items,
+// collections and the membership of items in collections.
+//
+// Every operation is atomic and honors cancellation of its context. WithTx
+// groups several operations into one transaction: fn receives a Store bound
+// to the transaction, which is committed if fn returns nil and rolled back
+// otherwise. Calling WithTx on a Store that is already bound to a
+// transaction runs fn in that same transaction.
+//
+// Items and collections carry a version that every update increments. The
+// update and delete operations take the version the caller last saw; if it
+// is non-zero and no longer current, they fail with ErrPreconditionFailed
+// without writing anything. A zero version skips the check.
+//
+// Deleting an item or collection moves it to the trash, where every other
+// operation treats it as missing. It can be restored until PurgeDeleted
+// removes it for good.
+type Store interface {
+	CreateItem(ctx context.Context, name, description string) (*Item, error)
+	GetItem(ctx context.Context, id int64) (*Item, error)
+	ListItems(ctx context.Context, opts ListOptions) ([]Item, string, error)
+	ItemsLastModified(ctx context.Context) (time.Time, error)
+	UpdateItem(ctx context.Context, id int64, name, description string, version int64) (*Item, error)
+	PatchItem(ctx context.Context, id int64, p Patch, version int64) (*Item, error)
+	DeleteItem(ctx context.Context, id int64, version int64) error
+
+	CreateCollection(ctx context.Context, name, description string) (*Collection, error)
+	CreateSmartCollection(ctx context.Context, name, description, rule string) (*Collection, error)
+	GetCollection(ctx context.Context, id int64) (*Collection, error)
+	ListCollections(ctx context.Context, opts ListOptions) ([]Collection, string, error)
+	CollectionsLastModified(ctx context.Context) (time.Time, error)
+	UpdateCollection(ctx context.Context, id int64, name, description string, version int64) (*Collection, error)
+	PatchCollection(ctx context.Context, id int64, p Patch, version int64) (*Collection, error)
+	DeleteCollection(ctx context.Context, id int64, version int64) error
+
+	AddItemToCollection(ctx context.Context, collectionID, itemID int64) error
+	ListItemsInCollection(ctx context.Context, collectionID int64, opts ListOptions) ([]Item, string, error)
+	RemoveItemFromCollection(ctx context.Context, collectionID, itemID int64) error
+	MoveCollection(ctx context.Context, id, parentID int64, version int64) (*Collection, error)
+	ListChildCollections(ctx context.Context, parentID int64, opts ListOptions) ([]Collection, string, error)
+	CollectionSubtree(ctx context.Context, id int64) ([]Collection, error)
+	DeleteCollectionTree(ctx context.Context, id int64, version int64) ([]int64, error)
+
+	ListCollectionsOfItem(ctx context.Context, itemID int64, opts ListOptions) ([]Collection, string, error)
+	ItemCollectionIDs(ctx context.Context, itemIDs []int64) (map[int64][]int64, error)
+	InsertItemIntoCollection(ctx context.Context, collectionID, itemID int64, at Placement) error
+	MoveItemInCollection(ctx context.Context, collectionID, itemID int64, to Placement) error
+	AddItemsToCollection(ctx context.Context, collectionID int64, itemIDs []int64) ([]MembershipResult, error)
+	RemoveItemsFromCollection(ctx context.Context, collectionID int64, itemIDs []int64) ([]MembershipResult, error)
+	ReplaceCollectionItems(ctx context.Context, collectionID int64, itemIDs []int64) ([]MembershipResult, error)
+
+	AddItemTags(ctx context.Context, id int64, tags []string, version int64) (*Item, error)
+	RemoveItemTags(ctx context.Context, id int64, tags []string, version int64) (*Item, error)
+	AddCollectionTags(ctx context.Context, id int64, tags []string, version int64) (*Collection, error)
+	RemoveCollectionTags(ctx context.Context, id int64, tags []string, version int64) (*Collection, error)
+	ListTags(ctx context.Context, opts TagListOptions) ([]TagCount, error)
+
+	ReplaceItemFields(ctx context.Context, id int64, fields map[string]any, version int64) (*Item, error)
+	PatchItemFields(ctx context.Context, id int64, fields map[string]any, version int64) (*Item, error)
+	SetCollectionSchema(ctx context.Context, id int64, schema []FieldDef, version int64) (*Collection, error)
+
+	ListDeletedItems(ctx context.Context, opts ListOptions) ([]Item, string, error)
+	ListDeletedCollections(ctx context.Context, opts ListOptions) ([]Collection, string, error)
+	RestoreItem(ctx context.Context, id int64, version int64) (*Item, error)
+	RestoreCollection(ctx context.Context, id int64, version int64) (*Collection, error)
+	PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error)
+
+	ReserveIdempotencyKey(ctx context.Context, key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, error)
+	CompleteIdempotencyKey(ctx context.Context, key string, status int, header map[string][]string, body []byte) error
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+	PurgeIdempotencyKeys(ctx context.Context) (int64, error)
+
+	WithTx(ctx context.Context, fn func(tx Store) error) error
+}
+
+// Patch is a partial update of an item or collection. Nil fields are left
+// unchanged. Rule only applies to collections: an empty rule makes the
+// collection manual, any other one makes it smart.
+type Patch struct {
+	Name        *string
+	Description *string
+	Rule        *string
+}
+
+// empty reports whether the patch changes nothing.
+func (p Patch) empty() bool {
+	return p.Name == nil && p.Description == nil && p.Rule == nil
+}
+
 // Item represents a simple record in the items table.
 // The table schema is:
 //   id BIGINT PRIMARY KEY AUTO_INCREMENT,
 //   name VARCHAR(255) NOT NULL,
-//   description TEXT,
-//   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
-// Note: The actual migration is handled externally; this file simply provides an API.
+//   description TEXT NOT NULL,
+//   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+//   version BIGINT NOT NULL DEFAULT 1,
+//   updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+// Note: The schema is created by the migrations in db/migrations; this file simply provides an API.
 
 // Item holds item data returned to clients.
-// The CreatedAt field is kept as a string to avoid timezone parsing complexities.
-// Tests assert the string value directly.
+// Timestamps are in UTC with whole seconds, the precision of the DATETIME
+// columns.
 type Item struct {
 	ID          int64
 	Name        string
 	Description string
-	CreatedAt   string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Version     int64
+	// Position is the rank of the item in the collection it was listed
+	// from by ListItemsInCollection, and zero otherwise.
+	Position int64
+	// Tags are the item's tags, sorted.
+	Tags []string
+	// Fields are the item's custom fields. Their values are strings,
+	// float64 numbers or booleans.
+	Fields map[string]any
+	// DeletedAt is when the item was moved to the trash. It is only set by
+	// ListDeletedItems.
+	DeletedAt time.Time
+}
+
+func (i Item) fieldValue(field string) string {
+	switch field {
+	case "id":
+		return strconv.FormatInt(i.ID, 10)
+	case "name":
+		return i.Name
+	case "description":
+		return i.Description
+	case "created_at":
+		return i.CreatedAt.Format(filter.TimeLayout)
+	case "updated_at":
+		return i.UpdatedAt.Format(filter.TimeLayout)
+	case "position":
+		return strconv.FormatInt(i.Position, 10)
+	}
+	if name, ok := filter.CustomName(field); ok {
+		return fieldSortKey(i.Fields[name])
+	}
+	return ""
+}
+
+func (i Item) tags() []string { return i.Tags }
+
+func (i Item) custom(name string) (any, bool) {
+	v, ok := i.Fields[name]
+	return v, ok
 }
 
 // CreateItem inserts a new item into the database and returns its details.
-func CreateItem(db *sql.DB, name, description string) (*Item, error) {
-	res, err := db.Exec("INSERT INTO items (name, description) VALUES (?, ?)", name, description)
-	if err != nil {
-		return nil, err
+func CreateItem(ctx context.Context, q Querier, name, description string, now time.Time) (*Item, error) {
+	var item *Item
+	err := withTx(ctx, q, func(q Querier) error {
+		res, err := q.ExecContext(ctx, "INSERT INTO items (name, description, created_at, updated_at) VALUES (?, ?, ?, ?)", name, description, now, now)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		item, err = GetItem(ctx, q, id)
+		return err
+	})
+	return item, err
+}
+
+// GetItem retrieves an item by its ID. It returns ErrNotFound if the item
+// does not exist.
+func GetItem(ctx context.Context, q Querier, id int64) (*Item, error) {
+	row := q.QueryRowContext(ctx, "SELECT id, name, description, created_at, updated_at, version FROM items WHERE id = ? AND deleted_at IS NULL", id)
+	var item Item
+	if err := row.Scan(&item.ID, &item.Name, &item.Description, &item.CreatedAt, &item.UpdatedAt, &item.Version); err != nil {
+		return nil, dbError(err, "item", id)
 	}
-	id, err := res.LastInsertId()
+	tags, err := tagsOf(ctx, q, "item", []int64{id})
 	if err != nil {
 		return nil, err
 	}
-	row := db.QueryRow("SELECT id, name, description, created_at FROM items WHERE id = ?", id)
-	var item Item
-	if err := row.Scan(&item.ID, &item.Name, &item.Description, &item.CreatedAt); err != nil {
+	item.Tags = tags[id]
+	fields, err := fieldsOf(ctx, q, []int64{id})
+	if err != nil {
 		return nil, err
 	}
+	item.Fields = fields[id]
 	return &item, nil
 }
 
-// GetItem retrieves an item by its ID.
-func GetItem(db *sql.DB, id int64) (*Item, error) {
-	row := db.QueryRow("SELECT id, name, description, created_at FROM items WHERE id = ?", id)
-	var item Item
-	if err := row.Scan(&item.ID, &item.Name, &item.Description, &item.CreatedAt); err != nil {
-		return nil, err
+// ListItems returns a filtered, sorted page of items along with the cursor of
+// the next page. The cursor is empty on the last page.
+func ListItems(ctx context.Context, q Querier, opts ListOptions) ([]Item, string, error) {
+	query, args, err := selectQuery("SELECT id, name, description, created_at, updated_at, version FROM items", "item", "", []string{"deleted_at IS NULL"}, nil, opts)
+	if err != nil {
+		return nil, "", err
 	}
-	return &item, nil
-}
-
-// ListItems returns all items.
-func ListItems(db *sql.DB) ([]Item, error) {
-	rows, err := db.Query("SELECT id, name, description, created_at FROM items")
+	rows, err := q.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer rows.Close()
 	var items []Item
 	for rows.Next() {
 		var itm Item
-		if err := rows.Scan(&itm.ID, &itm.Name, &itm.Description, &itm.CreatedAt); err != nil {
-			return nil, err
+		if err := rows.Scan(&itm.ID, &itm.Name, &itm.Description, &itm.CreatedAt, &itm.UpdatedAt, &itm.Version); err != nil {
+			return nil, "", err
 		}
 		items = append(items, itm)
 	}
-	return items, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	items, next := paginate(items, opts)
+	if err := loadItemTags(ctx, q, items); err != nil {
+		return nil, "", err
+	}
+	if err := loadItemFields(ctx, q, items); err != nil {
+		return nil, "", err
+	}
+	return items, next, nil
 }
 
-// UpdateItem modifies an existing item.
-func UpdateItem(db *sql.DB, id int64, name, description string) (*Item, error) {
-	_, err := db.Exec("UPDATE items SET name = ?, description = ? WHERE id = ?", name, description, id)
-	if err != nil {
-		return nil, err
+// ItemsLastModified returns when an item was last created, updated or
+// deleted, or the zero time if there are no items.
+func ItemsLastModified(ctx context.Context, q Querier) (time.Time, error) {
+	return lastModified(ctx, q, "items")
+}
+
+// UpdateItem modifies an existing item and increments its version. It
+// returns ErrNotFound if the item does not exist, and ErrPreconditionFailed
+// if version is non-zero and not the current one.
+func UpdateItem(ctx context.Context, q Querier, id int64, name, description string, version int64, now time.Time) (*Item, error) {
+	return PatchItem(ctx, q, id, Patch{Name: &name, Description: &description}, version, now)
+}
+
+// PatchItem updates the fields set in p and increments the item's version.
+// An empty patch only checks the version. It returns the same errors as
+// UpdateItem.
+func PatchItem(ctx context.Context, q Querier, id int64, p Patch, version int64, now time.Time) (*Item, error) {
+	if p.Rule != nil {
+		return nil, errItemRule
 	}
-	return GetItem(db, id)
+	var item *Item
+	err := withTx(ctx, q, func(q Querier) error {
+		if err := checkVersion(ctx, q, "item", id, version); err != nil {
+			return err
+		}
+		if !p.empty() {
+			// A nil pointer is sent as NULL, which COALESCE replaces with
+			// the current value.
+			if _, err := q.ExecContext(ctx, "UPDATE items SET name = COALESCE(?, name), description = COALESCE(?, description), version = version + 1, updated_at = ? WHERE id = ?", p.Name, p.Description, now, id); err != nil {
+				return err
+			}
+			if err := touchItemCollections(ctx, q, id, now); err != nil {
+				return err
+			}
+		}
+		var err error
+		item, err = GetItem(ctx, q, id)
+		return err
+	})
+	return item, err
 }
 
-func DeleteItem(db *sql.DB, id int64) error {
-	_, err := db.Exec("DELETE FROM items WHERE id = ?", id)
-	return err
+// DeleteItem moves an item to the trash, along with its collection
+// memberships; RestoreItem brings both back. It returns ErrNotFound if the
+// item does not exist or is already deleted, and ErrPreconditionFailed if
+// version is non-zero and not the current one.
+func DeleteItem(ctx context.Context, q Querier, id int64, version int64, now time.Time) error {
+	return withTx(ctx, q, func(q Querier) error {
+		if err := lockVersion(ctx, q, "item", id, version); err != nil {
+			return err
+		}
+		if err := touchItemCollections(ctx, q, id, now); err != nil {
+			return err
+		}
+		if err := trashMemberships(ctx, q, "item_id", []int64{id}); err != nil {
+			return err
+		}
+		_, err := q.ExecContext(ctx, "UPDATE items SET deleted_at = ? WHERE id = ?", now, id)
+		return err
+	})
 }
 
 // Collection represents a collection of items.
 // The table schema is:
 //   id BIGINT PRIMARY KEY AUTO_INCREMENT,
 //   name VARCHAR(255) NOT NULL,
-//   description TEXT,
-//   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
-// Note: The actual migration is handled externally; this file simply provides an API.
+//   description TEXT NOT NULL,
+//   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+//   version BIGINT NOT NULL DEFAULT 1,
+//   updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+//   members_updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+// Note: The schema is created by the migrations in db/migrations; this file simply provides an API.
 
 // Collection holds collection data returned to clients.
-// The CreatedAt field is kept as a string to avoid timezone parsing complexities.
-// Tests would assert the string value directly.
+// Timestamps are in UTC with whole seconds, the precision of the DATETIME
+// columns.
+//
+// MembersUpdatedAt changes whenever an item joins or leaves the collection,
+// or one of its items is updated. It is not part of the collection's
+// representation, but validates listings of its items.
 type Collection struct {
-	ID          int64
-	Name        string
-	Description string
-	CreatedAt   string
+	ID               int64
+	Name             string
+	Description      string
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	Version          int64
+	MembersUpdatedAt time.Time
+	// ParentID is the collection this one is nested in, or zero at the
+	// top level.
+	ParentID int64
+	// Tags are the collection's tags, sorted.
+	Tags []string
+	// Rule is the filter expression selecting the items of a smart
+	// collection, and empty for a manual collection.
+	Rule string
+	// Schema declares the custom fields the collection's items must have.
+	Schema []FieldDef
+	// DeletedAt is when the collection was moved to the trash, and zero
+	// for live collections.
+	DeletedAt time.Time
 }
 
+func (c Collection) fieldValue(field string) string {
+	switch field {
+	case "id":
+		return strconv.FormatInt(c.ID, 10)
+	case "name":
+		return c.Name
+	case "description":
+		return c.Description
+	case "created_at":
+		return c.CreatedAt.Format(filter.TimeLayout)
+	case "updated_at":
+		return c.UpdatedAt.Format(filter.TimeLayout)
+	}
+	return ""
+}
+
+func (c Collection) tags() []string { return c.Tags }
+
+func (c Collection) custom(string) (any, bool) { return nil, false }
+
 // CreateCollection inserts a new collection into the database and returns its details.
-func CreateCollection(db *sql.DB, name, description string) (*Collection, error) {
-	res, err := db.Exec("INSERT INTO collections (name, description) VALUES (?, ?)", name, description)
+func CreateCollection(ctx context.Context, q Querier, name, description string, now time.Time) (*Collection, error) {
+	var col *Collection
+	err := withTx(ctx, q, func(q Querier) error {
+		res, err := q.ExecContext(ctx, "INSERT INTO collections (name, description, created_at, updated_at, members_updated_at) VALUES (?, ?, ?, ?, ?)", name, description, now, now, now)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		col, err = GetCollection(ctx, q, id)
+		return err
+	})
+	return col, err
+}
+
+// GetCollection retrieves a collection by its ID. It returns ErrNotFound if
+// the collection does not exist.
+func GetCollection(ctx context.Context, q Querier, id int64) (*Collection, error) {
+	row := q.QueryRowContext(ctx, "SELECT id, name, description, created_at, updated_at, version, members_updated_at, parent_id, rule, field_schema, deleted_at FROM collections WHERE id = ? AND deleted_at IS NULL", id)
+	var col Collection
+	if err := scanCollection(row, &col); err != nil {
+		return nil, dbError(err, "collection", id)
+	}
+	tags, err := tagsOf(ctx, q, "collection", []int64{id})
 	if err != nil {
 		return nil, err
 	}
-	id, err := res.LastInsertId()
+	col.Tags = tags[id]
+	return &col, nil
+}
+
+// ListCollections returns a filtered, sorted page of collections along with
+// the cursor of the next page. The cursor is empty on the last page.
+func ListCollections(ctx context.Context, q Querier, opts ListOptions) ([]Collection, string, error) {
+	query, args, err := selectQuery("SELECT id, name, description, created_at, updated_at, version, members_updated_at, parent_id, rule, field_schema, deleted_at FROM collections", "collection", "", []string{"deleted_at IS NULL"}, nil, opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	row := db.QueryRow("SELECT id, name, description, created_at FROM collections WHERE id = ?", id)
-	var col Collection
-	if err := row.Scan(&col.ID, &col.Name, &col.Description, &col.CreatedAt); err != nil {
-		return nil, err
+	return queryCollections(ctx, q, query, args, opts)
+}
+
+// CollectionsLastModified returns when a collection was last created,
+// updated or deleted, or the zero time if there are no collections.
+func CollectionsLastModified(ctx context.Context, q Querier) (time.Time, error) {
+	return lastModified(ctx, q, "collections")
+}
+
+// lastModified returns the latest updated_at or deleted_at of a table.
+// Restores count as updates, so every change to the live rows moves it.
+func lastModified(ctx context.Context, q Querier, table string) (time.Time, error) {
+	var last sql.NullTime
+	if err := q.QueryRowContext(ctx, "SELECT MAX(GREATEST(updated_at, COALESCE(deleted_at, updated_at))) FROM "+table).Scan(&last); err != nil {
+		return time.Time{}, err
 	}
-	return &col, nil
+	return last.Time, nil
 }
 
-// GetCollection retrieves a collection by its ID.
-func GetCollection(db *sql.DB, id int64) (*Collection, error) {
-	row := db.QueryRow("SELECT id, name, description, created_at FROM collections WHERE id = ?", id)
-	var col Collection
-	if err := row.Scan(&col.ID, &col.Name, &col.Description, &col.CreatedAt); err != nil {
-		return nil, err
+// ListCollectionsOfItem retrieves a filtered, sorted page of the collections
+// that contain the specified item, along with the next cursor. It returns
+// ErrNotFound if the item does not exist.
+func ListCollectionsOfItem(ctx context.Context, q Querier, itemID int64, opts ListOptions) ([]Collection, string, error) {
+	if err := mustExist(ctx, q, "item", itemID); err != nil {
+		return nil, "", err
 	}
-	return &col, nil
+	query, args, err := selectQuery("SELECT c.id, c.name, c.description, c.created_at, c.updated_at, c.version, c.members_updated_at, c.parent_id, c.rule, c.field_schema, c.deleted_at FROM collections c JOIN collection_items ci ON c.id = ci.collection_id", "collection", "c.", []string{"ci.item_id = ?"}, []any{itemID}, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	return queryCollections(ctx, q, query, args, opts)
 }
 
-// ListCollections returns all collections.
-func ListCollections(db *sql.DB) ([]Collection, error) {
-	rows, err := db.Query("SELECT id, name, description, created_at FROM collections")
+// queryCollections runs a list query built by selectQuery and pages the
+// collections it returns.
+func queryCollections(ctx context.Context, q Querier, query string, args []any, opts ListOptions) ([]Collection, string, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer rows.Close()
 	var cols []Collection
 	for rows.Next() {
 		var col Collection
-		if err := rows.Scan(&col.ID, &col.Name, &col.Description, &col.CreatedAt); err != nil {
-			return nil, err
+		if err := scanCollection(rows, &col); err != nil {
+			return nil, "", err
 		}
 		cols = append(cols, col)
 	}
-	return cols, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	cols, next := paginate(cols, opts)
+	if err := loadCollectionTags(ctx, q, cols); err != nil {
+		return nil, "", err
+	}
+	return cols, next, nil
 }
 
-// UpdateCollection modifies an existing collection.
-func UpdateCollection(db *sql.DB, id int64, name, description string) (*Collection, error) {
-	_, err := db.Exec("UPDATE collections SET name = ?, description = ? WHERE id = ?", name, description, id)
-	if err != nil {
-		return nil, err
+// UpdateCollection modifies an existing collection and increments its
+// version. It returns ErrNotFound if the collection does not exist, and
+// ErrPreconditionFailed if version is non-zero and not the current one.
+func UpdateCollection(ctx context.Context, q Querier, id int64, name, description string, version int64, now time.Time) (*Collection, error) {
+	return PatchCollection(ctx, q, id, Patch{Name: &name, Description: &description}, version, now)
+}
+
+// PatchCollection updates the fields set in p and increments the
+// collection's version. An empty patch only checks the version. Setting a
+// rule removes the manual members of the collection. It returns the same
+// errors as UpdateCollection, and ErrValidation for an invalid rule.
+func PatchCollection(ctx context.Context, q Querier, id int64, p Patch, version int64, now time.Time) (*Collection, error) {
+	if p.Rule != nil && *p.Rule != "" {
+		if err := validateRule(*p.Rule); err != nil {
+			return nil, err
+		}
 	}
-	return GetCollection(db, id)
+	var col *Collection
+	err := withTx(ctx, q, func(q Querier) error {
+		if err := checkVersion(ctx, q, "collection", id, version); err != nil {
+			return err
+		}
+		if !p.empty() {
+			if _, err := q.ExecContext(ctx, "UPDATE collections SET name = COALESCE(?, name), description = COALESCE(?, description), version = version + 1, updated_at = ? WHERE id = ?", p.Name, p.Description, now, id); err != nil {
+				return err
+			}
+		}
+		if p.Rule != nil {
+			if _, err := q.ExecContext(ctx, "UPDATE collections SET rule = NULLIF(?, ''), members_updated_at = ? WHERE id = ?", *p.Rule, now, id); err != nil {
+				return err
+			}
+			if *p.Rule != "" {
+				if _, err := q.ExecContext(ctx, "DELETE FROM collection_items WHERE collection_id = ?", id); err != nil {
+					return err
+				}
+			}
+		}
+		var err error
+		col, err = GetCollection(ctx, q, id)
+		return err
+	})
+	return col, err
 }
 
-// DeleteCollection removes a collection by ID, and cleans up relationships.
-func DeleteCollection(db *sql.DB, id int64) error {
-	// Remove from join table first
-	if _, err := db.Exec("DELETE FROM collection_items WHERE collection_id = ?", id); err != nil {
+// DeleteCollection moves a collection to the trash, along with its
+// memberships; RestoreCollection brings both back. Deleting a missing or
+// already deleted collection is not an error unless a version is given, in
+// which case it returns ErrNotFound. A stale version yields
+// ErrPreconditionFailed, and a collection with child collections yields
+// ErrConflict; DeleteCollectionTree deletes those too.
+func DeleteCollection(ctx context.Context, q Querier, id int64, version int64, now time.Time) error {
+	return withTx(ctx, q, func(q Querier) error {
+		if err := checkVersion(ctx, q, "collection", id, version); err != nil {
+			return err
+		}
+		if err := mustBeLeaf(ctx, q, id); err != nil {
+			return err
+		}
+		if err := trashMemberships(ctx, q, "collection_id", []int64{id}); err != nil {
+			return err
+		}
+		_, err := q.ExecContext(ctx, "UPDATE collections SET deleted_at = ?, deleted_with = id WHERE id = ? AND deleted_at IS NULL", now, id)
 		return err
-	}
-	_, err := db.Exec("DELETE FROM collections WHERE id = ?", id)
+	})
+}
+
+// AddItemToCollection associates an item with a collection. It returns
+// ErrNotFound if either the collection or the item does not exist, and
+// ErrConflict if the item's custom fields do not match the collection's
+// schema.
+func AddItemToCollection(ctx context.Context, q Querier, collectionID, itemID int64, now time.Time) error {
+	return withTx(ctx, q, func(q Querier) error {
+		if err := mustBeManual(ctx, q, collectionID, "LOCK IN SHARE MODE"); err != nil {
+			return err
+		}
+		if err := mustExist(ctx, q, "item", itemID); err != nil {
+			return err
+		}
+		if err := mustMatchSchema(ctx, q, collectionID, itemID); err != nil {
+			return err
+		}
+		res, err := q.ExecContext(ctx, "INSERT IGNORE INTO collection_items (collection_id, item_id, position) SELECT ?, ?, COALESCE(MAX(position), 0) + ? FROM collection_items WHERE collection_id = ?", collectionID, itemID, positionGap, collectionID)
+		if err != nil {
+			return err
+		}
+		return touchMembers(ctx, q, res, collectionID, now)
+	})
+}
+
+// touchItemCollections bumps members_updated_at of every collection that
+// contains the item.
+func touchItemCollections(ctx context.Context, q Querier, itemID int64, now time.Time) error {
+	_, err := q.ExecContext(ctx, "UPDATE collections SET members_updated_at = ? WHERE id IN (SELECT collection_id FROM collection_items WHERE item_id = ?)", now, itemID)
 	return err
 }
 
-// AddItemToCollection associates an item with a collection.
-func AddItemToCollection(db *sql.DB, collectionID, itemID int64) error {
-	_, err := db.Exec("INSERT IGNORE INTO collection_items (collection_id, item_id) VALUES (?, ?)", collectionID, itemID)
+// touchMembers bumps members_updated_at of a collection if res, the result
+// of a membership write, changed any row.
+func touchMembers(ctx context.Context, q Querier, res sql.Result, collectionID int64, now time.Time) error {
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return err
+	}
+	_, err = q.ExecContext(ctx, "UPDATE collections SET members_updated_at = ? WHERE id = ?", now, collectionID)
 	return err
 }
 
-// ListItemsInCollection retrieves all items belonging to the specified collection.
-func ListItemsInCollection(db *sql.DB, collectionID int64) ([]Item, error) {
-	rows, err := db.Query("SELECT i.id, i.name, i.description, i.created_at FROM items i JOIN collection_items ci ON i.id = ci.item_id WHERE ci.collection_id = ?", collectionID)
+// mustExist returns ErrNotFound unless a row of the given kind exists.
+// kind is "item" or "collection", never client input. Inside a transaction
+// the row is share-locked, so it cannot be deleted before the commit.
+func mustExist(ctx context.Context, q Querier, kind string, id int64) error {
+	var one int
+	err := q.QueryRowContext(ctx, "SELECT 1 FROM "+kind+"s WHERE id = ? AND deleted_at IS NULL LOCK IN SHARE MODE", id).Scan(&one)
+	return dbError(err, kind, id)
+}
+
+// checkVersion returns ErrPreconditionFailed unless version is zero or the
+// current version of the row. The row is locked until the transaction ends,
+// so the write that follows cannot race with another one.
+func checkVersion(ctx context.Context, q Querier, kind string, id, version int64) error {
+	if version == 0 {
+		return nil
+	}
+	return lockVersion(ctx, q, kind, id, version)
+}
+
+// lockVersion is like checkVersion, but locks the row and checks that it
+// exists even if version is zero, so that concurrent changes serialize.
+func lockVersion(ctx context.Context, q Querier, kind string, id, version int64) error {
+	var current int64
+	err := q.QueryRowContext(ctx, "SELECT version FROM "+kind+"s WHERE id = ? AND deleted_at IS NULL FOR UPDATE", id).Scan(&current)
 	if err != nil {
-		return nil, err
+		return dbError(err, kind, id)
+	}
+	if version != 0 && current != version {
+		return preconditionFailed(kind, id, current)
+	}
+	return nil
+}
+
+// ListItemsInCollection retrieves a filtered, sorted page of the items
+// belonging to the specified collection, or with opts.Recursive to it and
+// its descendants, along with the next cursor. The items of a smart
+// collection are the items matching its rule at now; they cannot be listed
+// recursively. It returns ErrNotFound if the collection does not exist.
+func ListItemsInCollection(ctx context.Context, q Querier, collectionID int64, opts ListOptions, now time.Time) ([]Item, string, error) {
+	var rule sql.NullString
+	if err := q.QueryRowContext(ctx, "SELECT rule FROM collections WHERE id = ? AND deleted_at IS NULL LOCK IN SHARE MODE", collectionID).Scan(&rule); err != nil {
+		return nil, "", dbError(err, "collection", collectionID)
+	}
+	base := "SELECT i.id, i.name, i.description, i.created_at, i.updated_at, i.version, i.position FROM (SELECT items.*, ci.position FROM items JOIN collection_items ci ON items.id = ci.item_id WHERE ci.collection_id = ?) i"
+	args := []any{collectionID}
+	var conds []string
+	switch {
+	case rule.String != "":
+		if opts.Recursive {
+			return nil, "", errRecursiveSmart
+		}
+		var err error
+		if opts, err = ruleOptions(rule.String, opts, now); err != nil {
+			return nil, "", err
+		}
+		base, args = "SELECT i.id, i.name, i.description, i.created_at, i.updated_at, i.version, 0 FROM items i", nil
+		conds = []string{"i.deleted_at IS NULL"}
+	case opts.Recursive:
+		// Items in several collections of the subtree are listed once.
+		base = subtreeCTE + "SELECT i.id, i.name, i.description, i.created_at, i.updated_at, i.version, i.position FROM (SELECT items.*, 0 AS position FROM items WHERE id IN (SELECT ci.item_id FROM collection_items ci JOIN tree ON ci.collection_id = tree.id)) i"
+	default:
+		opts = collectionOrder(opts)
+	}
+	query, args, err := selectQuery(base, "item", "i.", conds, args, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
 	}
 	defer rows.Close()
 	var items []Item
 	for rows.Next() {
 		var itm Item
-		if err := rows.Scan(&itm.ID, &itm.Name, &itm.Description, &itm.CreatedAt); err != nil {
-			return nil, err
+		if err := rows.Scan(&itm.ID, &itm.Name, &itm.Description, &itm.CreatedAt, &itm.UpdatedAt, &itm.Version, &itm.Position); err != nil {
+			return nil, "", err
 		}
 		items = append(items, itm)
 	}
-	return items, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	items, next := paginate(items, opts)
+	if err := loadItemTags(ctx, q, items); err != nil {
+		return nil, "", err
+	}
+	if err := loadItemFields(ctx, q, items); err != nil {
+		return nil, "", err
+	}
+	return items, next, nil
 }
 
-// RemoveItemFromCollection disassociates an item from a collection.
-func RemoveItemFromCollection(db *sql.DB, collectionID, itemID int64) error {
-	_, err := db.Exec("DELETE FROM collection_items WHERE collection_id = ? AND item_id = ?", collectionID, itemID)
-	return err
+// ItemCollectionIDs returns the IDs of the collections that contain each of
+// the given items, in ascending order. Items in no collection, or that do not
+// exist, are absent from the map.
+func ItemCollectionIDs(ctx context.Context, q Querier, itemIDs []int64) (map[int64][]int64, error) {
+	out := make(map[int64][]int64)
+	if len(itemIDs) == 0 {
+		return out, nil
+	}
+	rows, err := q.QueryContext(ctx, "SELECT item_id, collection_id FROM collection_items WHERE item_id IN ("+placeholders(len(itemIDs), "?")+") ORDER BY item_id, collection_id", idArgs(itemIDs)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var itemID, colID int64
+		if err := rows.Scan(&itemID, &colID); err != nil {
+			return nil, err
+		}
+		out[itemID] = append(out[itemID], colID)
+	}
+	return out, rows.Err()
 }
 
-// Duplicate DeleteItem removed
+// RemoveItemFromCollection disassociates an item from a collection. It
+// returns ErrConflict for a smart collection.
+func RemoveItemFromCollection(ctx context.Context, q Querier, collectionID, itemID int64, now time.Time) error {
+	return withTx(ctx, q, func(q Querier) error {
+		if err := mustBeManual(ctx, q, collectionID, "LOCK IN SHARE MODE"); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		res, err := q.ExecContext(ctx, "DELETE FROM collection_items WHERE collection_id = ? AND item_id = ?", collectionID, itemID)
+		if err != nil {
+			return err
+		}
+		return touchMembers(ctx, q, res, collectionID, now)
+	})
+}
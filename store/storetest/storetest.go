@@ -0,0 +1,1652 @@
+// Package storetest provides a conformance suite that every store.Store
+// implementation is expected to pass.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mmontes11/opencode-test/store"
+	"github.com/mmontes11/opencode-test/store/filter"
+)
+
+// Run executes the conformance suite against stores returned by newStore,
+// which must stamp writes with the given clock. The suite does not assume an
+// empty store, so it can run against a shared database.
+func Run(t *testing.T, newStoreWithClock func(t *testing.T, now store.Clock) store.Store) {
+	newStore := func(t *testing.T) store.Store { return newStoreWithClock(t, time.Now) }
+	t.Run("ItemCRUD", func(t *testing.T) { testItemCRUD(t, newStore(t)) })
+	t.Run("CollectionCRUD", func(t *testing.T) { testCollectionCRUD(t, newStore(t)) })
+	t.Run("Membership", func(t *testing.T) { testMembership(t, newStore(t)) })
+	t.Run("BulkMembership", func(t *testing.T) { testBulkMembership(t, newStore(t)) })
+	t.Run("Ordering", func(t *testing.T) { testOrdering(t, newStore(t)) })
+	t.Run("ReverseLookup", func(t *testing.T) { testReverseLookup(t, newStore(t)) })
+	t.Run("Nesting", func(t *testing.T) { testNesting(t, newStore(t)) })
+	t.Run("Tags", func(t *testing.T) { testTags(t, newStore(t)) })
+	t.Run("SmartCollections", func(t *testing.T) { testSmartCollections(t, newStoreWithClock) })
+	t.Run("CustomFields", func(t *testing.T) { testCustomFields(t, newStore(t)) })
+	t.Run("Trash", func(t *testing.T) { testTrash(t, newStoreWithClock) })
+	t.Run("RestoreRanks", func(t *testing.T) { testRestoreRanks(t, newStore(t)) })
+	t.Run("DeleteCollectionCleansUp", func(t *testing.T) { testDeleteCollectionCleansUp(t, newStore(t)) })
+	t.Run("ReferentialIntegrity", func(t *testing.T) { testReferentialIntegrity(t, newStore(t)) })
+	t.Run("Pagination", func(t *testing.T) { testPagination(t, newStore(t)) })
+	t.Run("FilterAndSort", func(t *testing.T) { testFilterAndSort(t, newStore(t)) })
+	t.Run("ConcurrentCreates", func(t *testing.T) { testConcurrentCreates(t, newStore(t)) })
+	t.Run("Patch", func(t *testing.T) { testPatch(t, newStore(t)) })
+	t.Run("Versions", func(t *testing.T) { testVersions(t, newStore(t)) })
+	t.Run("Timestamps", func(t *testing.T) { testTimestamps(t, newStoreWithClock) })
+	t.Run("IdempotencyKeys", func(t *testing.T) { testIdempotencyKeys(t, newStoreWithClock) })
+	t.Run("Transactions", func(t *testing.T) { testTransactions(t, newStore(t)) })
+	t.Run("CanceledContext", func(t *testing.T) { testCanceledContext(t, newStore(t)) })
+}
+
+func testItemCRUD(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	item, err := s.CreateItem(ctx, "item", "desc")
+	if err != nil {
+		t.Fatalf("CreateItem: %v", err)
+	}
+	if item.ID == 0 || item.Name != "item" || item.Description != "desc" || item.CreatedAt.IsZero() {
+		t.Fatalf("unexpected item: %+v", item)
+	}
+	other, err := s.CreateItem(ctx, "other", "")
+	if err != nil {
+		t.Fatalf("CreateItem: %v", err)
+	}
+	if other.ID <= item.ID {
+		t.Fatalf("expected increasing IDs, got %d after %d", other.ID, item.ID)
+	}
+	defer s.DeleteItem(ctx, other.ID, 0)
+
+	got, err := s.GetItem(ctx, item.ID)
+	if err != nil {
+		t.Fatalf("GetItem: %v", err)
+	}
+	if !reflect.DeepEqual(got, item) {
+		t.Fatalf("GetItem = %+v, want %+v", got, item)
+	}
+
+	items, _, err := s.ListItems(ctx, store.ListOptions{})
+	if err != nil {
+		t.Fatalf("ListItems: %v", err)
+	}
+	if !containsItem(items, item.ID) || !containsItem(items, other.ID) {
+		t.Fatalf("ListItems missing created items: %+v", items)
+	}
+
+	updated, err := s.UpdateItem(ctx, item.ID, "renamed", "", 0)
+	if err != nil {
+		t.Fatalf("UpdateItem: %v", err)
+	}
+	if updated.Name != "renamed" || updated.Description != "" || !updated.CreatedAt.Equal(item.CreatedAt) {
+		t.Fatalf("unexpected updated item: %+v", updated)
+	}
+
+	if err := s.DeleteItem(ctx, item.ID, 0); err != nil {
+		t.Fatalf("DeleteItem: %v", err)
+	}
+	if _, err := s.GetItem(ctx, item.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetItem after delete: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.UpdateItem(ctx, item.ID, "x", "", 0); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("UpdateItem after delete: got %v, want ErrNotFound", err)
+	}
+	if err := s.DeleteItem(ctx, item.ID, 0); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("DeleteItem after delete: got %v, want ErrNotFound", err)
+	}
+}
+
+func testCollectionCRUD(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	col, err := s.CreateCollection(ctx, "collection", "desc")
+	if err != nil {
+		t.Fatalf("CreateCollection: %v", err)
+	}
+	if col.ID == 0 || col.Name != "collection" || col.CreatedAt.IsZero() {
+		t.Fatalf("unexpected collection: %+v", col)
+	}
+
+	got, err := s.GetCollection(ctx, col.ID)
+	if err != nil {
+		t.Fatalf("GetCollection: %v", err)
+	}
+	if !reflect.DeepEqual(got, col) {
+		t.Fatalf("GetCollection = %+v, want %+v", got, col)
+	}
+
+	cols, _, err := s.ListCollections(ctx, store.ListOptions{})
+	if err != nil {
+		t.Fatalf("ListCollections: %v", err)
+	}
+	found := false
+	for _, c := range cols {
+		found = found || c.ID == col.ID
+	}
+	if !found {
+		t.Fatalf("ListCollections missing %d", col.ID)
+	}
+
+	updated, err := s.UpdateCollection(ctx, col.ID, "renamed", "new", 0)
+	if err != nil {
+		t.Fatalf("UpdateCollection: %v", err)
+	}
+	if updated.Name != "renamed" || updated.Description != "new" {
+		t.Fatalf("unexpected updated collection: %+v", updated)
+	}
+
+	if err := s.DeleteCollection(ctx, col.ID, 0); err != nil {
+		t.Fatalf("DeleteCollection: %v", err)
+	}
+	if _, err := s.GetCollection(ctx, col.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetCollection after delete: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.UpdateCollection(ctx, col.ID, "x", "", 0); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("UpdateCollection after delete: got %v, want ErrNotFound", err)
+	}
+}
+
+func testMembership(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	col, _ := s.CreateCollection(ctx, "members", "")
+	defer s.DeleteCollection(ctx, col.ID, 0)
+	item1, _ := s.CreateItem(ctx, "one", "")
+	defer s.DeleteItem(ctx, item1.ID, 0)
+	item2, _ := s.CreateItem(ctx, "two", "")
+	defer s.DeleteItem(ctx, item2.ID, 0)
+
+	for _, id := range []int64{item1.ID, item2.ID, item1.ID} {
+		if err := s.AddItemToCollection(ctx, col.ID, id); err != nil {
+			t.Fatalf("AddItemToCollection(%d): %v", id, err)
+		}
+	}
+	items, _, err := s.ListItemsInCollection(ctx, col.ID, store.ListOptions{})
+	if err != nil {
+		t.Fatalf("ListItemsInCollection: %v", err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("expected 2 items after idempotent add, got %d", len(items))
+	}
+
+	if err := s.RemoveItemFromCollection(ctx, col.ID, item1.ID); err != nil {
+		t.Fatalf("RemoveItemFromCollection: %v", err)
+	}
+	items, _, _ = s.ListItemsInCollection(ctx, col.ID, store.ListOptions{})
+	if len(items) != 1 || items[0].ID != item2.ID {
+		t.Fatalf("expected only item %d, got %+v", item2.ID, items)
+	}
+}
+
+func testBulkMembership(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	col, _ := s.CreateCollection(ctx, "bulk", "")
+	defer s.DeleteCollection(ctx, col.ID, 0)
+	var ids []int64
+	for _, name := range []string{"one", "two", "three", "gone"} {
+		item, _ := s.CreateItem(ctx, name, "")
+		defer s.DeleteItem(ctx, item.ID, 0)
+		ids = append(ids, item.ID)
+	}
+	one, two, three, gone := ids[0], ids[1], ids[2], ids[3]
+	s.DeleteItem(ctx, gone, 0)
+	check := func(op string, got []store.MembershipResult, err error, want ...store.MembershipResult) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %v", op, err)
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("%s = %+v, want %+v", op, got, want)
+		}
+	}
+	members := func() []int64 {
+		t.Helper()
+		items, _, err := s.ListItemsInCollection(ctx, col.ID, store.ListOptions{})
+		if err != nil {
+			t.Fatalf("ListItemsInCollection: %v", err)
+		}
+		var ids []int64
+		for _, item := range items {
+			ids = append(ids, item.ID)
+		}
+		return ids
+	}
+
+	if err := s.AddItemToCollection(ctx, col.ID, two); err != nil {
+		t.Fatalf("AddItemToCollection: %v", err)
+	}
+	res, err := s.AddItemsToCollection(ctx, col.ID, []int64{one, two, gone, one})
+	check("AddItemsToCollection", res, err,
+		store.MembershipResult{ItemID: one, Status: store.MembershipAdded},
+		store.MembershipResult{ItemID: two, Status: store.MembershipAlreadyPresent},
+		store.MembershipResult{ItemID: gone, Status: store.MembershipMissingItem})
+	if got := members(); !slices.Equal(got, []int64{two, one}) {
+		t.Fatalf("members after add = %v, want %v", got, []int64{two, one})
+	}
+
+	res, err = s.ReplaceCollectionItems(ctx, col.ID, []int64{three, two})
+	check("ReplaceCollectionItems", res, err,
+		store.MembershipResult{ItemID: three, Status: store.MembershipAdded},
+		store.MembershipResult{ItemID: two, Status: store.MembershipAlreadyPresent},
+		store.MembershipResult{ItemID: one, Status: store.MembershipRemoved})
+	if got := members(); !slices.Equal(got, []int64{three, two}) {
+		t.Fatalf("members after replace = %v, want %v", got, []int64{three, two})
+	}
+
+	res, err = s.RemoveItemsFromCollection(ctx, col.ID, []int64{one, three})
+	check("RemoveItemsFromCollection", res, err,
+		store.MembershipResult{ItemID: one, Status: store.MembershipNotPresent},
+		store.MembershipResult{ItemID: three, Status: store.MembershipRemoved})
+	res, err = s.ReplaceCollectionItems(ctx, col.ID, []int64{})
+	check("ReplaceCollectionItems(empty)", res, err,
+		store.MembershipResult{ItemID: two, Status: store.MembershipRemoved})
+	if got := members(); len(got) != 0 {
+		t.Fatalf("members after clearing = %v, want none", got)
+	}
+
+	if _, err := s.AddItemsToCollection(ctx, col.ID+1000000, []int64{one}); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("AddItemsToCollection on missing collection: got %v, want ErrNotFound", err)
+	}
+}
+
+func testOrdering(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	col, _ := s.CreateCollection(ctx, "ordered", "")
+	defer s.DeleteCollection(ctx, col.ID, 0)
+	var ids []int64
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		item, _ := s.CreateItem(ctx, name, "")
+		defer s.DeleteItem(ctx, item.ID, 0)
+		ids = append(ids, item.ID)
+	}
+	a, b, c, d, e := ids[0], ids[1], ids[2], ids[3], ids[4]
+	// Adding in reverse ID order shows that the manual order wins.
+	for _, id := range []int64{d, c, b, a} {
+		if err := s.AddItemToCollection(ctx, col.ID, id); err != nil {
+			t.Fatalf("AddItemToCollection(%d): %v", id, err)
+		}
+	}
+	expect := func(op string, want ...int64) {
+		t.Helper()
+		var got []int64
+		opts := store.ListOptions{Limit: 2}
+		for {
+			items, next, err := s.ListItemsInCollection(ctx, col.ID, opts)
+			if err != nil {
+				t.Fatalf("ListItemsInCollection: %v", err)
+			}
+			for _, item := range items {
+				got = append(got, item.ID)
+			}
+			if next == "" {
+				break
+			}
+			opts.Cursor = next
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("order after %s = %v, want %v", op, got, want)
+		}
+	}
+	move := func(id int64, to store.Placement) {
+		t.Helper()
+		if err := s.MoveItemInCollection(ctx, col.ID, id, to); err != nil {
+			t.Fatalf("MoveItemInCollection(%d, %+v): %v", id, to, err)
+		}
+	}
+	expect("adding", d, c, b, a)
+
+	move(a, store.Placement{Index: 0})
+	expect("moving to index 0", a, d, c, b)
+	move(a, store.Placement{After: c})
+	expect("moving after", d, c, a, b)
+	move(b, store.Placement{Before: c})
+	expect("moving before", d, b, c, a)
+	move(d, store.AtEnd)
+	expect("moving to the end", b, c, a, d)
+	if err := s.InsertItemIntoCollection(ctx, col.ID, e, store.Placement{Index: 2}); err != nil {
+		t.Fatalf("InsertItemIntoCollection: %v", err)
+	}
+	expect("inserting", b, c, e, a, d)
+
+	// Repeatedly splitting the same gap exhausts it and forces the ranks
+	// to be spread out again.
+	order := []int64{b, c, e, a, d}
+	for i := 0; i < 24; i++ {
+		id := []int64{a, d}[i%2]
+		move(id, store.Placement{Index: 1})
+		order = slices.DeleteFunc(order, func(x int64) bool { return x == id })
+		order = slices.Insert(order, 1, id)
+	}
+	expect("splitting a gap", order...)
+
+	if err := s.MoveItemInCollection(ctx, col.ID, ids[4]+1000000, store.AtEnd); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("moving a missing item: got %v, want ErrNotFound", err)
+	}
+	if err := s.RemoveItemFromCollection(ctx, col.ID, e); err != nil {
+		t.Fatalf("RemoveItemFromCollection: %v", err)
+	}
+	if err := s.MoveItemInCollection(ctx, col.ID, e, store.AtEnd); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("moving a non-member: got %v, want ErrNotFound", err)
+	}
+	if err := s.MoveItemInCollection(ctx, col.ID, a, store.Placement{Before: e}); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("moving before a non-member: got %v, want ErrNotFound", err)
+	}
+	if err := s.MoveItemInCollection(ctx, col.ID, a, store.Placement{After: a}); !errors.Is(err, store.ErrValidation) {
+		t.Fatalf("moving after itself: got %v, want ErrValidation", err)
+	}
+}
+
+func testReverseLookup(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	item, _ := s.CreateItem(ctx, "member", "")
+	defer s.DeleteItem(ctx, item.ID, 0)
+	loner, _ := s.CreateItem(ctx, "loner", "")
+	defer s.DeleteItem(ctx, loner.ID, 0)
+	var colIDs []int64
+	for _, name := range []string{"first", "second", "third"} {
+		col, _ := s.CreateCollection(ctx, name, "")
+		defer s.DeleteCollection(ctx, col.ID, 0)
+		colIDs = append(colIDs, col.ID)
+		if name != "second" {
+			if err := s.AddItemToCollection(ctx, col.ID, item.ID); err != nil {
+				t.Fatalf("AddItemToCollection: %v", err)
+			}
+		}
+	}
+	want := []int64{colIDs[0], colIDs[2]}
+
+	var got []int64
+	opts := store.ListOptions{Limit: 1}
+	for {
+		cols, next, err := s.ListCollectionsOfItem(ctx, item.ID, opts)
+		if err != nil {
+			t.Fatalf("ListCollectionsOfItem: %v", err)
+		}
+		for _, col := range cols {
+			got = append(got, col.ID)
+		}
+		if next == "" {
+			break
+		}
+		opts.Cursor = next
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("ListCollectionsOfItem = %v, want %v", got, want)
+	}
+	cols, _, err := s.ListCollectionsOfItem(ctx, item.ID, store.ListOptions{Sort: []filter.SortKey{{Field: "name", Desc: true}}})
+	if err != nil || len(cols) != 2 || cols[0].Name != "third" {
+		t.Fatalf("sorted ListCollectionsOfItem = %+v, %v", cols, err)
+	}
+	if _, _, err := s.ListCollectionsOfItem(ctx, loner.ID+1000000, store.ListOptions{}); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("ListCollectionsOfItem of missing item: got %v, want ErrNotFound", err)
+	}
+
+	ids, err := s.ItemCollectionIDs(ctx, []int64{item.ID, loner.ID})
+	if err != nil {
+		t.Fatalf("ItemCollectionIDs: %v", err)
+	}
+	if !slices.Equal(ids[item.ID], want) || len(ids[loner.ID]) != 0 {
+		t.Fatalf("ItemCollectionIDs = %v, want %v for item %d and nothing for %d", ids, want, item.ID, loner.ID)
+	}
+}
+
+func testNesting(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	var cols []*store.Collection
+	for _, name := range []string{"root", "child", "grandchild", "other"} {
+		col, err := s.CreateCollection(ctx, name, "")
+		if err != nil {
+			t.Fatalf("CreateCollection: %v", err)
+		}
+		defer s.DeleteCollectionTree(ctx, col.ID, 0)
+		cols = append(cols, col)
+	}
+	root, child, grandchild, other := cols[0], cols[1], cols[2], cols[3]
+	if root.ParentID != 0 {
+		t.Fatalf("new collection has parent %d", root.ParentID)
+	}
+	moved, err := s.MoveCollection(ctx, child.ID, root.ID, child.Version)
+	if err != nil {
+		t.Fatalf("MoveCollection: %v", err)
+	}
+	if moved.ParentID != root.ID || moved.Version != child.Version+1 {
+		t.Fatalf("MoveCollection returned %+v", moved)
+	}
+	if _, err := s.MoveCollection(ctx, grandchild.ID, child.ID, 0); err != nil {
+		t.Fatalf("MoveCollection: %v", err)
+	}
+	if _, err := s.MoveCollection(ctx, child.ID, 0, child.Version); !errors.Is(err, store.ErrPreconditionFailed) {
+		t.Fatalf("MoveCollection with stale version: got %v, want ErrPreconditionFailed", err)
+	}
+	for _, parent := range []int64{root.ID, grandchild.ID} {
+		if _, err := s.MoveCollection(ctx, root.ID, parent, 0); !errors.Is(err, store.ErrConflict) {
+			t.Fatalf("MoveCollection(root under %d): got %v, want ErrConflict", parent, err)
+		}
+	}
+	if _, err := s.MoveCollection(ctx, other.ID, other.ID+1000000, 0); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("MoveCollection under a missing parent: got %v, want ErrNotFound", err)
+	}
+
+	children, _, err := s.ListChildCollections(ctx, root.ID, store.ListOptions{})
+	if err != nil || len(children) != 1 || children[0].ID != child.ID {
+		t.Fatalf("ListChildCollections = %+v, %v", children, err)
+	}
+	subtree, err := s.CollectionSubtree(ctx, root.ID)
+	if err != nil {
+		t.Fatalf("CollectionSubtree: %v", err)
+	}
+	var ids []int64
+	for _, col := range subtree {
+		ids = append(ids, col.ID)
+	}
+	if !slices.Equal(ids, []int64{root.ID, child.ID, grandchild.ID}) {
+		t.Fatalf("CollectionSubtree = %v", ids)
+	}
+
+	shared, _ := s.CreateItem(ctx, "shared", "")
+	defer s.DeleteItem(ctx, shared.ID, 0)
+	deep, _ := s.CreateItem(ctx, "deep", "")
+	defer s.DeleteItem(ctx, deep.ID, 0)
+	for _, m := range [][2]int64{{root.ID, shared.ID}, {grandchild.ID, shared.ID}, {grandchild.ID, deep.ID}, {other.ID, deep.ID}} {
+		if err := s.AddItemToCollection(ctx, m[0], m[1]); err != nil {
+			t.Fatalf("AddItemToCollection: %v", err)
+		}
+	}
+	items, _, err := s.ListItemsInCollection(ctx, root.ID, store.ListOptions{Recursive: true, Limit: 1})
+	if err != nil || len(items) != 1 || items[0].ID != shared.ID {
+		t.Fatalf("recursive ListItemsInCollection page = %+v, %v", items, err)
+	}
+	items, _, err = s.ListItemsInCollection(ctx, root.ID, store.ListOptions{Recursive: true})
+	if err != nil || len(items) != 2 || items[1].ID != deep.ID {
+		t.Fatalf("recursive ListItemsInCollection = %+v, %v", items, err)
+	}
+	if items, _, _ := s.ListItemsInCollection(ctx, root.ID, store.ListOptions{}); len(items) != 1 {
+		t.Fatalf("non-recursive ListItemsInCollection = %+v", items)
+	}
+
+	if err := s.DeleteCollection(ctx, child.ID, 0); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("DeleteCollection of a parent: got %v, want ErrConflict", err)
+	}
+	deleted, err := s.DeleteCollectionTree(ctx, child.ID, 0)
+	if err != nil || !slices.Equal(deleted, []int64{child.ID, grandchild.ID}) {
+		t.Fatalf("DeleteCollectionTree = %v, %v", deleted, err)
+	}
+	if _, err := s.GetCollection(ctx, grandchild.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetCollection of deleted descendant: got %v, want ErrNotFound", err)
+	}
+	if cols, _, _ := s.ListCollectionsOfItem(ctx, deep.ID, store.ListOptions{}); len(cols) != 1 || cols[0].ID != other.ID {
+		t.Fatalf("memberships of deleted descendants were kept: %+v", cols)
+	}
+	if err := s.DeleteCollection(ctx, root.ID, 0); err != nil {
+		t.Fatalf("DeleteCollection of an emptied parent: %v", err)
+	}
+}
+
+func testTags(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	var items []*store.Item
+	for _, name := range []string{"a", "b", "c"} {
+		item, err := s.CreateItem(ctx, name, "")
+		if err != nil {
+			t.Fatalf("CreateItem: %v", err)
+		}
+		defer s.DeleteItem(ctx, item.ID, 0)
+		items = append(items, item)
+	}
+	a, b, c := items[0], items[1], items[2]
+	col, _ := s.CreateCollection(ctx, "tagged", "")
+	defer s.DeleteCollection(ctx, col.ID, 0)
+	// The tags are unique to this run, since the store may be shared.
+	p := fmt.Sprintf("tags%d-", a.ID)
+	red, blue, green := p+"red", p+"blue", p+"green"
+
+	tagged, err := s.AddItemTags(ctx, a.ID, []string{" " + p + "Red", blue, red}, a.Version)
+	if err != nil {
+		t.Fatalf("AddItemTags: %v", err)
+	}
+	if !slices.Equal(tagged.Tags, []string{blue, red}) || tagged.Version != a.Version+1 {
+		t.Fatalf("AddItemTags returned %+v", tagged)
+	}
+	if again, err := s.AddItemTags(ctx, a.ID, []string{red}, 0); err != nil || again.Version != tagged.Version {
+		t.Fatalf("re-adding a tag: got %+v, %v, want an unchanged version", again, err)
+	}
+	if got, _ := s.GetItem(ctx, a.ID); !slices.Equal(got.Tags, []string{blue, red}) {
+		t.Fatalf("GetItem tags = %v", got.Tags)
+	}
+	if _, err := s.AddItemTags(ctx, a.ID, []string{"two words"}, 0); !errors.Is(err, store.ErrValidation) {
+		t.Fatalf("malformed tag: got %v, want ErrValidation", err)
+	}
+	if _, err := s.AddItemTags(ctx, a.ID, []string{green}, a.Version); !errors.Is(err, store.ErrPreconditionFailed) {
+		t.Fatalf("stale version: got %v, want ErrPreconditionFailed", err)
+	}
+	if _, err := s.AddItemTags(ctx, a.ID+c.ID+1000000, []string{green}, 0); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("missing item: got %v, want ErrNotFound", err)
+	}
+	s.AddItemTags(ctx, b.ID, []string{red}, 0)
+	s.AddItemTags(ctx, c.ID, []string{blue, green}, 0)
+	if tagged, err := s.AddCollectionTags(ctx, col.ID, []string{red}, col.Version); err != nil || !slices.Equal(tagged.Tags, []string{red}) || tagged.Version != col.Version+1 {
+		t.Fatalf("AddCollectionTags = %+v, %v", tagged, err)
+	}
+	for _, item := range items {
+		s.AddItemToCollection(ctx, col.ID, item.ID)
+	}
+
+	ids := func(items []store.Item, err error) []int64 {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		var ids []int64
+		for _, item := range items {
+			ids = append(ids, item.ID)
+		}
+		return ids
+	}
+	for _, tc := range []struct {
+		all, any []string
+		want     []int64
+	}{
+		{all: []string{red}, want: []int64{a.ID, b.ID}},
+		{all: []string{blue, red}, want: []int64{a.ID}},
+		{any: []string{green, red}, want: []int64{a.ID, b.ID, c.ID}},
+		{all: []string{blue}, any: []string{green}, want: []int64{c.ID}},
+		{all: []string{p + "none"}, want: nil},
+	} {
+		opts := store.ListOptions{TagsAll: tc.all, TagsAny: tc.any}
+		got, _, err := s.ListItems(ctx, opts)
+		if ids := ids(got, err); !slices.Equal(ids, tc.want) {
+			t.Fatalf("ListItems(all %v, any %v) = %v, want %v", tc.all, tc.any, ids, tc.want)
+		}
+		got, _, err = s.ListItemsInCollection(ctx, col.ID, opts)
+		if ids := ids(got, err); !slices.Equal(ids, tc.want) {
+			t.Fatalf("ListItemsInCollection(all %v, any %v) = %v, want %v", tc.all, tc.any, ids, tc.want)
+		}
+	}
+	page, next, err := s.ListItems(ctx, store.ListOptions{TagsAny: []string{red}, Limit: 1})
+	if err != nil || len(page) != 1 || page[0].ID != a.ID || !slices.Equal(page[0].Tags, []string{blue, red}) {
+		t.Fatalf("first page = %+v, %v", page, err)
+	}
+	if page, _, _ := s.ListItems(ctx, store.ListOptions{TagsAny: []string{red}, Limit: 1, Cursor: next}); len(page) != 1 || page[0].ID != b.ID {
+		t.Fatalf("second page = %+v", page)
+	}
+	if cols, _, err := s.ListCollections(ctx, store.ListOptions{TagsAll: []string{red}}); err != nil || len(cols) != 1 || cols[0].ID != col.ID || !slices.Equal(cols[0].Tags, []string{red}) {
+		t.Fatalf("ListCollections by tag = %+v, %v", cols, err)
+	}
+	if cols, _, err := s.ListCollectionsOfItem(ctx, a.ID, store.ListOptions{TagsAll: []string{blue}}); err != nil || len(cols) != 0 {
+		t.Fatalf("ListCollectionsOfItem by tag = %+v, %v", cols, err)
+	}
+
+	counts, err := s.ListTags(ctx, store.TagListOptions{Prefix: p})
+	want := []store.TagCount{{Tag: red, Items: 2, Collections: 1}, {Tag: blue, Items: 2}, {Tag: green, Items: 1}}
+	if err != nil || !slices.Equal(counts, want) {
+		t.Fatalf("ListTags = %+v, %v, want %+v", counts, err, want)
+	}
+	if counts, _ := s.ListTags(ctx, store.TagListOptions{Prefix: p + "g", Limit: 1}); !slices.Equal(counts, want[2:]) {
+		t.Fatalf("ListTags by prefix = %+v", counts)
+	}
+
+	untagged, err := s.RemoveItemTags(ctx, a.ID, []string{red, p + "none"}, tagged.Version)
+	if err != nil || !slices.Equal(untagged.Tags, []string{blue}) || untagged.Version != tagged.Version+1 {
+		t.Fatalf("RemoveItemTags = %+v, %v", untagged, err)
+	}
+	if col, err := s.RemoveCollectionTags(ctx, col.ID, []string{red}, 0); err != nil || len(col.Tags) != 0 {
+		t.Fatalf("RemoveCollectionTags = %+v, %v", col, err)
+	}
+	if err := s.DeleteItem(ctx, c.ID, 0); err != nil {
+		t.Fatalf("DeleteItem: %v", err)
+	}
+	counts, _ = s.ListTags(ctx, store.TagListOptions{Prefix: p})
+	want = []store.TagCount{{Tag: blue, Items: 1}, {Tag: red, Items: 1}}
+	if !slices.Equal(counts, want) {
+		t.Fatalf("ListTags after removals = %+v, want %+v", counts, want)
+	}
+}
+
+func testSmartCollections(t *testing.T, newStore func(t *testing.T, now store.Clock) store.Store) {
+	ctx := context.Background()
+	clock := &manualClock{now: time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)}
+	s := newStore(t, clock.read)
+	create := func(name string) *store.Item {
+		t.Helper()
+		item, err := s.CreateItem(ctx, name, "")
+		if err != nil {
+			t.Fatalf("CreateItem: %v", err)
+		}
+		t.Cleanup(func() { s.DeleteItem(ctx, item.ID, 0) })
+		return item
+	}
+	old := create("old")
+	clock.advance(10 * 24 * time.Hour)
+	recent, untagged := create("recent"), create("untagged")
+	// The tag is unique to this run, since the store may be shared.
+	tag := fmt.Sprintf("smart%d", old.ID)
+	for _, item := range []*store.Item{old, recent} {
+		if _, err := s.AddItemTags(ctx, item.ID, []string{tag}, 0); err != nil {
+			t.Fatalf("AddItemTags: %v", err)
+		}
+	}
+	list := func(id int64, opts store.ListOptions) []int64 {
+		t.Helper()
+		opts.Limit = 10
+		items, _, err := s.ListItemsInCollection(ctx, id, opts)
+		if err != nil {
+			t.Fatalf("ListItemsInCollection: %v", err)
+		}
+		var ids []int64
+		for _, item := range items {
+			ids = append(ids, item.ID)
+		}
+		return ids
+	}
+
+	rule := "tag=" + tag + " and created_at>=now-7d"
+	col, err := s.CreateSmartCollection(ctx, "recent", "", rule)
+	if err != nil {
+		t.Fatalf("CreateSmartCollection: %v", err)
+	}
+	defer s.DeleteCollection(ctx, col.ID, 0)
+	if got, _ := s.GetCollection(ctx, col.ID); got.Rule != rule {
+		t.Fatalf("GetCollection rule = %q, want %q", got.Rule, rule)
+	}
+	if got := list(col.ID, store.ListOptions{}); !slices.Equal(got, []int64{recent.ID}) {
+		t.Fatalf("smart collection lists %v, want [%d]", got, recent.ID)
+	}
+	named, _ := filter.Parse("name=old", filter.DefaultFields)
+	if got := list(col.ID, store.ListOptions{Filter: named}); len(got) != 0 {
+		t.Fatalf("smart collection with a filter lists %v, want none", got)
+	}
+	clock.advance(10 * 24 * time.Hour)
+	if got := list(col.ID, store.ListOptions{}); len(got) != 0 {
+		t.Fatalf("rule was not reevaluated: got %v, want none", got)
+	}
+
+	for _, rule := range []string{"", "tag=", "color=red", "tag>x"} {
+		if _, err := s.CreateSmartCollection(ctx, "bad", "", rule); !errors.Is(err, store.ErrValidation) {
+			t.Fatalf("CreateSmartCollection(%q): got %v, want ErrValidation", rule, err)
+		}
+	}
+	if err := s.AddItemToCollection(ctx, col.ID, untagged.ID); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("AddItemToCollection of a smart collection: got %v, want ErrConflict", err)
+	}
+	if err := s.RemoveItemFromCollection(ctx, col.ID, recent.ID); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("RemoveItemFromCollection of a smart collection: got %v, want ErrConflict", err)
+	}
+	if _, err := s.AddItemsToCollection(ctx, col.ID, []int64{untagged.ID}); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("AddItemsToCollection of a smart collection: got %v, want ErrConflict", err)
+	}
+	if err := s.MoveItemInCollection(ctx, col.ID, recent.ID, store.AtEnd); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("MoveItemInCollection of a smart collection: got %v, want ErrConflict", err)
+	}
+	if _, _, err := s.ListItemsInCollection(ctx, col.ID, store.ListOptions{Limit: 10, Recursive: true}); !errors.Is(err, store.ErrValidation) {
+		t.Fatalf("recursive listing of a smart collection: got %v, want ErrValidation", err)
+	}
+
+	// Giving a manual collection a rule replaces its members, and removing
+	// the rule leaves it empty.
+	manual, err := s.CreateCollection(ctx, "manual", "")
+	if err != nil {
+		t.Fatalf("CreateCollection: %v", err)
+	}
+	defer s.DeleteCollection(ctx, manual.ID, 0)
+	s.AddItemToCollection(ctx, manual.ID, untagged.ID)
+	tagRule := "tag=" + tag
+	smart, err := s.PatchCollection(ctx, manual.ID, store.Patch{Rule: &tagRule}, manual.Version)
+	if err != nil || smart.Rule != tagRule || smart.Version != manual.Version+1 {
+		t.Fatalf("PatchCollection rule: got %+v, %v", smart, err)
+	}
+	if got := list(manual.ID, store.ListOptions{}); !slices.Equal(got, []int64{old.ID, recent.ID}) {
+		t.Fatalf("patched collection lists %v, want [%d %d]", got, old.ID, recent.ID)
+	}
+	none := ""
+	if _, err := s.PatchCollection(ctx, manual.ID, store.Patch{Rule: &none}, 0); err != nil {
+		t.Fatalf("removing the rule: %v", err)
+	}
+	if got := list(manual.ID, store.ListOptions{}); len(got) != 0 {
+		t.Fatalf("collection without a rule lists %v, want none", got)
+	}
+	if err := s.AddItemToCollection(ctx, manual.ID, untagged.ID); err != nil {
+		t.Fatalf("AddItemToCollection after removing the rule: %v", err)
+	}
+	bad := "name~"
+	if _, err := s.PatchCollection(ctx, manual.ID, store.Patch{Rule: &bad}, 0); !errors.Is(err, store.ErrValidation) {
+		t.Fatalf("PatchCollection with an invalid rule: got %v, want ErrValidation", err)
+	}
+	if _, err := s.PatchItem(ctx, old.ID, store.Patch{Rule: &tagRule}, 0); !errors.Is(err, store.ErrValidation) {
+		t.Fatalf("PatchItem with a rule: got %v, want ErrValidation", err)
+	}
+}
+
+func testCustomFields(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	var items []*store.Item
+	for _, name := range []string{"low", "high", "none", "text"} {
+		item, err := s.CreateItem(ctx, name, "")
+		if err != nil {
+			t.Fatalf("CreateItem: %v", err)
+		}
+		defer s.DeleteItem(ctx, item.ID, 0)
+		items = append(items, item)
+	}
+	low, high, none, text := items[0], items[1], items[2], items[3]
+	// The field name is unique to this run, since the store may be shared.
+	rank := fmt.Sprintf("rank%d", low.ID)
+
+	got, err := s.ReplaceItemFields(ctx, low.ID, map[string]any{rank: 2.0, "done": false}, low.Version)
+	if err != nil {
+		t.Fatalf("ReplaceItemFields: %v", err)
+	}
+	if want := map[string]any{rank: 2.0, "done": false}; !reflect.DeepEqual(got.Fields, want) || got.Version != low.Version+1 {
+		t.Fatalf("ReplaceItemFields = %v (version %d), want %v (version %d)", got.Fields, got.Version, want, low.Version+1)
+	}
+	got, err = s.PatchItemFields(ctx, low.ID, map[string]any{"done": nil, "due": "2026-05-01"}, 0)
+	if err != nil {
+		t.Fatalf("PatchItemFields: %v", err)
+	}
+	if want := map[string]any{rank: 2.0, "due": "2026-05-01"}; !reflect.DeepEqual(got.Fields, want) {
+		t.Fatalf("PatchItemFields = %v, want %v", got.Fields, want)
+	}
+	if got, _ := s.GetItem(ctx, low.ID); !reflect.DeepEqual(got.Fields, map[string]any{rank: 2.0, "due": "2026-05-01"}) {
+		t.Fatalf("GetItem fields = %v", got.Fields)
+	}
+	if _, err := s.PatchItemFields(ctx, low.ID, map[string]any{"done": true}, low.Version); !errors.Is(err, store.ErrPreconditionFailed) {
+		t.Fatalf("PatchItemFields with a stale version: got %v, want ErrPreconditionFailed", err)
+	}
+	for _, fields := range []map[string]any{{"Bad": 1.0}, {"x": []any{1.0}}, {"x": nil}} {
+		if _, err := s.ReplaceItemFields(ctx, none.ID, fields, 0); !errors.Is(err, store.ErrValidation) {
+			t.Fatalf("ReplaceItemFields(%v): got %v, want ErrValidation", fields, err)
+		}
+	}
+	if _, err := s.ReplaceItemFields(ctx, high.ID, map[string]any{rank: 10.0}, 0); err != nil {
+		t.Fatalf("ReplaceItemFields: %v", err)
+	}
+	if _, err := s.ReplaceItemFields(ctx, text.ID, map[string]any{rank: "many"}, 0); err != nil {
+		t.Fatalf("ReplaceItemFields: %v", err)
+	}
+
+	col, err := s.CreateCollection(ctx, "typed", "")
+	if err != nil {
+		t.Fatalf("CreateCollection: %v", err)
+	}
+	defer s.DeleteCollection(ctx, col.ID, 0)
+	if err := s.AddItemToCollection(ctx, col.ID, text.ID); err != nil {
+		t.Fatalf("AddItemToCollection: %v", err)
+	}
+	schema := []store.FieldDef{
+		{Name: rank, Type: store.FieldNumber, Required: true},
+		{Name: "status", Type: store.FieldEnum, Values: []string{"open", "done"}},
+	}
+	if _, err := s.SetCollectionSchema(ctx, col.ID, schema, 0); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("SetCollectionSchema with a mismatching member: got %v, want ErrConflict", err)
+	}
+	if err := s.RemoveItemFromCollection(ctx, col.ID, text.ID); err != nil {
+		t.Fatalf("RemoveItemFromCollection: %v", err)
+	}
+	for _, bad := range [][]store.FieldDef{
+		{{Name: "x", Type: "color"}},
+		{{Name: "x", Type: store.FieldEnum}},
+		{{Name: "x", Type: store.FieldBool}, {Name: "x", Type: store.FieldDate}},
+	} {
+		if _, err := s.SetCollectionSchema(ctx, col.ID, bad, 0); !errors.Is(err, store.ErrValidation) {
+			t.Fatalf("SetCollectionSchema(%v): got %v, want ErrValidation", bad, err)
+		}
+	}
+	updated, err := s.SetCollectionSchema(ctx, col.ID, schema, col.Version)
+	if err != nil {
+		t.Fatalf("SetCollectionSchema: %v", err)
+	}
+	if !reflect.DeepEqual(updated.Schema, schema) || updated.Version != col.Version+1 {
+		t.Fatalf("SetCollectionSchema = %+v (version %d), want %+v", updated.Schema, updated.Version, schema)
+	}
+	if got, _ := s.GetCollection(ctx, col.ID); !reflect.DeepEqual(got.Schema, schema) {
+		t.Fatalf("GetCollection schema = %+v, want %+v", got.Schema, schema)
+	}
+
+	if err := s.AddItemToCollection(ctx, col.ID, none.ID); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("AddItemToCollection of a mismatching item: got %v, want ErrConflict", err)
+	}
+	res, err := s.AddItemsToCollection(ctx, col.ID, []int64{low.ID, text.ID, high.ID})
+	if err != nil {
+		t.Fatalf("AddItemsToCollection: %v", err)
+	}
+	want := []store.MembershipResult{
+		{ItemID: low.ID, Status: store.MembershipAdded},
+		{ItemID: text.ID, Status: store.MembershipInvalidFields},
+		{ItemID: high.ID, Status: store.MembershipAdded},
+	}
+	if !slices.Equal(res, want) {
+		t.Fatalf("AddItemsToCollection = %+v, want %+v", res, want)
+	}
+	for _, fields := range []map[string]any{{rank: "two"}, {rank: nil}, {"status": "closed"}} {
+		if _, err := s.PatchItemFields(ctx, low.ID, fields, 0); !errors.Is(err, store.ErrValidation) {
+			t.Fatalf("PatchItemFields(%v) of a member: got %v, want ErrValidation", fields, err)
+		}
+	}
+	if _, err := s.PatchItemFields(ctx, low.ID, map[string]any{"status": "done"}, 0); err != nil {
+		t.Fatalf("PatchItemFields: %v", err)
+	}
+
+	list := func(query, sort string) []int64 {
+		t.Helper()
+		expr, err := filter.Parse(query, filter.ItemFields)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", query, err)
+		}
+		keys, err := filter.ParseSort(sort, filter.ItemFields)
+		if err != nil {
+			t.Fatalf("ParseSort(%q): %v", sort, err)
+		}
+		opts := store.ListOptions{Filter: expr, Sort: keys, Limit: 1}
+		var ids []int64
+		for {
+			page, next, err := s.ListItems(ctx, opts)
+			if err != nil {
+				t.Fatalf("ListItems(%q, %q): %v", query, sort, err)
+			}
+			for _, item := range page {
+				ids = append(ids, item.ID)
+			}
+			if next == "" {
+				return ids
+			}
+			opts.Cursor = next
+		}
+	}
+	if got := list("fields."+rank+">=2 and fields."+rank+"<=10", "fields."+rank); !slices.Equal(got, []int64{low.ID, high.ID}) {
+		t.Fatalf("numeric filter lists %v, want %v", got, []int64{low.ID, high.ID})
+	}
+	if got := list("fields."+rank+"=MANY", ""); !slices.Equal(got, []int64{text.ID}) {
+		t.Fatalf("text filter lists %v, want %v", got, []int64{text.ID})
+	}
+	for _, item := range items {
+		if _, err := s.AddItemTags(ctx, item.ID, []string{rank}, 0); err != nil {
+			t.Fatalf("AddItemTags: %v", err)
+		}
+	}
+	all := "tag=" + rank
+	if got := list(all+" and fields.status!=done", ""); !slices.Equal(got, []int64{high.ID, none.ID, text.ID}) {
+		t.Fatalf("negated filter lists %v, want %v", got, []int64{high.ID, none.ID, text.ID})
+	}
+	if got := list(all, "-fields."+rank); !slices.Equal(got, []int64{text.ID, high.ID, low.ID, none.ID}) {
+		t.Fatalf("descending sort lists %v, want %v", got, []int64{text.ID, high.ID, low.ID, none.ID})
+	}
+	members, _, err := s.ListItemsInCollection(ctx, col.ID, store.ListOptions{})
+	if err != nil || len(members) != 2 || members[0].Fields == nil {
+		t.Fatalf("ListItemsInCollection = %+v, %v; want two members with fields", members, err)
+	}
+}
+
+func testTrash(t *testing.T, newStore func(t *testing.T, now store.Clock) store.Store) {
+	ctx := context.Background()
+	clock := &manualClock{now: time.Date(2026, 4, 1, 8, 0, 0, 0, time.UTC)}
+	s := newStore(t, clock.read)
+	var items []*store.Item
+	for _, name := range []string{"first", "second", "third"} {
+		item, err := s.CreateItem(ctx, name, "")
+		if err != nil {
+			t.Fatalf("CreateItem: %v", err)
+		}
+		t.Cleanup(func() { s.DeleteItem(ctx, item.ID, 0) })
+		items = append(items, item)
+	}
+	first, second, third := items[0], items[1], items[2]
+	// The tag is unique to this run, since the store may be shared.
+	tag := fmt.Sprintf("trash%d", first.ID)
+	for _, item := range items {
+		if _, err := s.AddItemTags(ctx, item.ID, []string{tag}, 0); err != nil {
+			t.Fatalf("AddItemTags: %v", err)
+		}
+	}
+	parent, _ := s.CreateCollection(ctx, "parent", "")
+	child, _ := s.CreateCollection(ctx, "child", "")
+	t.Cleanup(func() { s.DeleteCollectionTree(ctx, parent.ID, 0) })
+	if _, err := s.MoveCollection(ctx, child.ID, parent.ID, 0); err != nil {
+		t.Fatalf("MoveCollection: %v", err)
+	}
+	if _, err := s.AddItemsToCollection(ctx, parent.ID, []int64{first.ID, second.ID, third.ID}); err != nil {
+		t.Fatalf("AddItemsToCollection: %v", err)
+	}
+	if err := s.AddItemToCollection(ctx, child.ID, second.ID); err != nil {
+		t.Fatalf("AddItemToCollection: %v", err)
+	}
+	members := func(id int64) []int64 {
+		t.Helper()
+		items, _, err := s.ListItemsInCollection(ctx, id, store.ListOptions{})
+		if err != nil {
+			t.Fatalf("ListItemsInCollection(%d): %v", id, err)
+		}
+		var ids []int64
+		for _, item := range items {
+			ids = append(ids, item.ID)
+		}
+		return ids
+	}
+	tagged, _ := filter.Parse("tag="+tag, filter.ItemFields)
+	listIDs := func(list func(context.Context, store.ListOptions) ([]store.Item, string, error)) []int64 {
+		t.Helper()
+		items, _, err := list(ctx, store.ListOptions{Filter: tagged, Limit: 10})
+		if err != nil {
+			t.Fatalf("listing items: %v", err)
+		}
+		var ids []int64
+		for _, item := range items {
+			ids = append(ids, item.ID)
+		}
+		return ids
+	}
+
+	clock.advance(time.Hour)
+	if err := s.DeleteItem(ctx, second.ID, second.Version+1); err != nil {
+		t.Fatalf("DeleteItem: %v", err)
+	}
+	if _, err := s.GetItem(ctx, second.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetItem of a deleted item: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.PatchItemFields(ctx, second.ID, map[string]any{"x": 1.0}, 0); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("PatchItemFields of a deleted item: got %v, want ErrNotFound", err)
+	}
+	if err := s.DeleteItem(ctx, second.ID, 0); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("deleting an item twice: got %v, want ErrNotFound", err)
+	}
+	if err := s.AddItemToCollection(ctx, parent.ID, second.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("AddItemToCollection of a deleted item: got %v, want ErrNotFound", err)
+	}
+	if got := listIDs(s.ListItems); !slices.Equal(got, []int64{first.ID, third.ID}) {
+		t.Fatalf("ListItems = %v, want %v", got, []int64{first.ID, third.ID})
+	}
+	if got := members(parent.ID); !slices.Equal(got, []int64{first.ID, third.ID}) {
+		t.Fatalf("members after deleting an item = %v, want %v", got, []int64{first.ID, third.ID})
+	}
+	deleted, _, err := s.ListDeletedItems(ctx, store.ListOptions{Filter: tagged})
+	if err != nil || len(deleted) != 1 || deleted[0].ID != second.ID || !deleted[0].DeletedAt.Equal(clock.read()) {
+		t.Fatalf("ListDeletedItems = %+v, %v; want item %d deleted at %v", deleted, err, second.ID, clock.read())
+	}
+	if last, err := s.ItemsLastModified(ctx); err != nil || last.Before(clock.read()) {
+		t.Fatalf("ItemsLastModified after a delete = %v, %v; want at least %v", last, err, clock.read())
+	}
+
+	if _, err := s.RestoreItem(ctx, second.ID, second.Version); !errors.Is(err, store.ErrPreconditionFailed) {
+		t.Fatalf("RestoreItem with a stale version: got %v, want ErrPreconditionFailed", err)
+	}
+	if _, err := s.RestoreItem(ctx, first.ID, 0); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("RestoreItem of a live item: got %v, want ErrConflict", err)
+	}
+	clock.advance(time.Minute)
+	restored, err := s.RestoreItem(ctx, second.ID, 0)
+	if err != nil {
+		t.Fatalf("RestoreItem: %v", err)
+	}
+	if !restored.DeletedAt.IsZero() || !slices.Equal(restored.Tags, []string{tag}) {
+		t.Fatalf("RestoreItem = %+v, want it live with its tags", restored)
+	}
+	if restored.Version != deleted[0].Version+1 || !restored.UpdatedAt.Equal(clock.read()) {
+		t.Fatalf("RestoreItem = version %d updated at %v, want version %d updated at %v", restored.Version, restored.UpdatedAt, deleted[0].Version+1, clock.read())
+	}
+	if last, err := s.ItemsLastModified(ctx); err != nil || last.Before(clock.read()) {
+		t.Fatalf("ItemsLastModified after a restore = %v, %v; want at least %v", last, err, clock.read())
+	}
+	if got := members(parent.ID); !slices.Equal(got, []int64{first.ID, second.ID, third.ID}) {
+		t.Fatalf("members after restoring = %v, want the former order", got)
+	}
+	if got := members(child.ID); !slices.Equal(got, []int64{second.ID}) {
+		t.Fatalf("child members after restoring = %v, want [%d]", got, second.ID)
+	}
+
+	clock.advance(time.Hour)
+	if err := s.DeleteCollection(ctx, parent.ID, 0); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("DeleteCollection with a child: got %v, want ErrConflict", err)
+	}
+	ids, err := s.DeleteCollectionTree(ctx, parent.ID, 0)
+	if err != nil || !slices.Equal(ids, []int64{parent.ID, child.ID}) {
+		t.Fatalf("DeleteCollectionTree = %v, %v", ids, err)
+	}
+	if _, err := s.GetCollection(ctx, child.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetCollection of a deleted collection: got %v, want ErrNotFound", err)
+	}
+	if cols, _, _ := s.ListCollectionsOfItem(ctx, second.ID, store.ListOptions{}); len(cols) != 0 {
+		t.Fatalf("ListCollectionsOfItem lists deleted collections: %+v", cols)
+	}
+	cols, _, err := s.ListDeletedCollections(ctx, store.ListOptions{Limit: 1000})
+	found := 0
+	for _, col := range cols {
+		if col.ID == parent.ID || col.ID == child.ID {
+			found++
+		}
+	}
+	if err != nil || found != 2 {
+		t.Fatalf("ListDeletedCollections = %+v, %v; want both collections", cols, err)
+	}
+	// Deleting an item while its collections are in the trash keeps its
+	// memberships for when both are back.
+	if err := s.DeleteItem(ctx, third.ID, 0); err != nil {
+		t.Fatalf("DeleteItem: %v", err)
+	}
+	if _, err := s.RestoreCollection(ctx, child.ID, 0); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("RestoreCollection with a deleted parent: got %v, want ErrConflict", err)
+	}
+	if _, err := s.RestoreCollection(ctx, parent.ID, 0); err != nil {
+		t.Fatalf("RestoreCollection: %v", err)
+	}
+	if got, err := s.GetCollection(ctx, child.ID); err != nil || got.ParentID != parent.ID {
+		t.Fatalf("child after restoring the parent = %+v, %v", got, err)
+	}
+	if got := members(parent.ID); !slices.Equal(got, []int64{first.ID, second.ID}) {
+		t.Fatalf("members after restoring the collection = %v, want %v", got, []int64{first.ID, second.ID})
+	}
+	if _, err := s.RestoreItem(ctx, third.ID, 0); err != nil {
+		t.Fatalf("RestoreItem: %v", err)
+	}
+	if got := members(parent.ID); !slices.Equal(got, []int64{first.ID, second.ID, third.ID}) {
+		t.Fatalf("members after restoring both = %v, want all three", got)
+	}
+
+	// A child deleted on its own stays in the trash when its parent is
+	// deleted and restored, even within the same second.
+	loner, _ := s.CreateCollection(ctx, "loner", "")
+	if _, err := s.MoveCollection(ctx, loner.ID, parent.ID, 0); err != nil {
+		t.Fatalf("MoveCollection: %v", err)
+	}
+	if err := s.DeleteCollection(ctx, loner.ID, 0); err != nil {
+		t.Fatalf("DeleteCollection: %v", err)
+	}
+	if ids, err := s.DeleteCollectionTree(ctx, parent.ID, 0); err != nil || !slices.Equal(ids, []int64{parent.ID, child.ID}) {
+		t.Fatalf("DeleteCollectionTree = %v, %v; want [%d %d]", ids, err, parent.ID, child.ID)
+	}
+	if _, err := s.RestoreCollection(ctx, parent.ID, 0); err != nil {
+		t.Fatalf("RestoreCollection: %v", err)
+	}
+	if _, err := s.GetCollection(ctx, loner.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetCollection of a child deleted before its parent: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetCollection(ctx, child.ID); err != nil {
+		t.Fatalf("GetCollection of a child deleted with its parent: %v", err)
+	}
+	if _, err := s.RestoreCollection(ctx, loner.ID, 0); err != nil {
+		t.Fatalf("RestoreCollection of the separately deleted child: %v", err)
+	}
+
+	if err := s.DeleteItem(ctx, first.ID, 0); err != nil {
+		t.Fatalf("DeleteItem: %v", err)
+	}
+	clock.advance(24 * time.Hour)
+	if err := s.DeleteItem(ctx, third.ID, 0); err != nil {
+		t.Fatalf("DeleteItem: %v", err)
+	}
+	n, err := s.PurgeDeleted(ctx, 12*time.Hour)
+	if err != nil || n < 1 {
+		t.Fatalf("PurgeDeleted = %d, %v; want at least 1", n, err)
+	}
+	if got := listIDs(s.ListDeletedItems); !slices.Equal(got, []int64{third.ID}) {
+		t.Fatalf("trash after purging = %v, want [%d]", got, third.ID)
+	}
+	if _, err := s.RestoreItem(ctx, first.ID, 0); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("RestoreItem of a purged item: got %v, want ErrNotFound", err)
+	}
+}
+
+// testRestoreRanks restores a membership whose former rank has been given
+// to another item, and checks that moves still land where asked.
+func testRestoreRanks(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	col, _ := s.CreateCollection(ctx, "ranks", "")
+	t.Cleanup(func() { s.DeleteCollection(ctx, col.ID, 0) })
+	var ids []int64
+	for _, name := range []string{"restored", "kept", "added"} {
+		item, err := s.CreateItem(ctx, name, "")
+		if err != nil {
+			t.Fatalf("CreateItem: %v", err)
+		}
+		t.Cleanup(func() { s.DeleteItem(ctx, item.ID, 0) })
+		ids = append(ids, item.ID)
+	}
+	restored, kept, added := ids[0], ids[1], ids[2]
+	if _, err := s.AddItemsToCollection(ctx, col.ID, []int64{restored, kept}); err != nil {
+		t.Fatalf("AddItemsToCollection: %v", err)
+	}
+	if err := s.DeleteItem(ctx, restored, 0); err != nil {
+		t.Fatalf("DeleteItem: %v", err)
+	}
+	// Inserting at the front takes the rank the deleted item had.
+	if err := s.InsertItemIntoCollection(ctx, col.ID, added, store.Placement{Index: 0}); err != nil {
+		t.Fatalf("InsertItemIntoCollection: %v", err)
+	}
+	if _, err := s.RestoreItem(ctx, restored, 0); err != nil {
+		t.Fatalf("RestoreItem: %v", err)
+	}
+	order := func() []int64 {
+		t.Helper()
+		items, _, err := s.ListItemsInCollection(ctx, col.ID, store.ListOptions{})
+		if err != nil {
+			t.Fatalf("ListItemsInCollection: %v", err)
+		}
+		var got []int64
+		for _, item := range items {
+			got = append(got, item.ID)
+		}
+		return got
+	}
+	if got, want := order(), []int64{restored, added, kept}; !slices.Equal(got, want) {
+		t.Fatalf("order after restoring = %v, want %v", got, want)
+	}
+	if err := s.MoveItemInCollection(ctx, col.ID, kept, store.Placement{After: restored}); err != nil {
+		t.Fatalf("MoveItemInCollection: %v", err)
+	}
+	if got, want := order(), []int64{restored, kept, added}; !slices.Equal(got, want) {
+		t.Fatalf("order after moving = %v, want %v", got, want)
+	}
+}
+
+func testDeleteCollectionCleansUp(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	col, _ := s.CreateCollection(ctx, "to delete", "")
+	item, _ := s.CreateItem(ctx, "member", "")
+	defer s.DeleteItem(ctx, item.ID, 0)
+	if err := s.AddItemToCollection(ctx, col.ID, item.ID); err != nil {
+		t.Fatalf("AddItemToCollection: %v", err)
+	}
+	if err := s.DeleteCollection(ctx, col.ID, 0); err != nil {
+		t.Fatalf("DeleteCollection: %v", err)
+	}
+	if _, _, err := s.ListItemsInCollection(ctx, col.ID, store.ListOptions{}); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("ListItemsInCollection after delete: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetItem(ctx, item.ID); err != nil {
+		t.Fatalf("item should survive collection delete: %v", err)
+	}
+}
+
+func testReferentialIntegrity(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	col, _ := s.CreateCollection(ctx, "integrity", "")
+	defer s.DeleteCollection(ctx, col.ID, 0)
+	item, _ := s.CreateItem(ctx, "member", "")
+	other, _ := s.CreateItem(ctx, "survivor", "")
+	defer s.DeleteItem(ctx, other.ID, 0)
+	missingCol, _ := s.CreateCollection(ctx, "gone", "")
+	s.DeleteCollection(ctx, missingCol.ID, 0)
+
+	if err := s.AddItemToCollection(ctx, missingCol.ID, item.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("add to missing collection: got %v, want ErrNotFound", err)
+	}
+	if err := s.AddItemToCollection(ctx, col.ID, item.ID+other.ID+1000); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("add missing item: got %v, want ErrNotFound", err)
+	}
+
+	s.AddItemToCollection(ctx, col.ID, item.ID)
+	s.AddItemToCollection(ctx, col.ID, other.ID)
+	if err := s.DeleteItem(ctx, item.ID, 0); err != nil {
+		t.Fatalf("DeleteItem: %v", err)
+	}
+	items, _, err := s.ListItemsInCollection(ctx, col.ID, store.ListOptions{})
+	if err != nil {
+		t.Fatalf("ListItemsInCollection: %v", err)
+	}
+	if len(items) != 1 || items[0].ID != other.ID {
+		t.Fatalf("expected only item %d after cascade, got %+v", other.ID, items)
+	}
+}
+
+func testPagination(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	col, _ := s.CreateCollection(ctx, "paged", "")
+	defer s.DeleteCollection(ctx, col.ID, 0)
+	var want []int64
+	for i := 0; i < 5; i++ {
+		item, _ := s.CreateItem(ctx, "paged item", "")
+		defer s.DeleteItem(ctx, item.ID, 0)
+		s.AddItemToCollection(ctx, col.ID, item.ID)
+		want = append(want, item.ID)
+	}
+
+	var got []int64
+	opts := store.ListOptions{Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages > len(want) {
+			t.Fatalf("pagination did not terminate")
+		}
+		items, next, err := s.ListItemsInCollection(ctx, col.ID, opts)
+		if err != nil {
+			t.Fatalf("ListItemsInCollection: %v", err)
+		}
+		if len(items) > opts.Limit {
+			t.Fatalf("page has %d items, limit is %d", len(items), opts.Limit)
+		}
+		for _, item := range items {
+			got = append(got, item.ID)
+		}
+		if next == "" {
+			break
+		}
+		opts.Cursor = next
+	}
+	if len(got) != len(want) {
+		t.Fatalf("paged IDs = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("paged IDs = %v, want %v", got, want)
+		}
+	}
+
+	// Paging over all items must also visit the created ones exactly once.
+	seen := make(map[int64]int)
+	opts = store.ListOptions{Limit: 3}
+	for {
+		items, next, err := s.ListItems(ctx, opts)
+		if err != nil {
+			t.Fatalf("ListItems: %v", err)
+		}
+		for _, item := range items {
+			seen[item.ID]++
+		}
+		if next == "" {
+			break
+		}
+		opts.Cursor = next
+	}
+	for _, id := range want {
+		if seen[id] != 1 {
+			t.Fatalf("item %d seen %d times while paging", id, seen[id])
+		}
+	}
+
+	if _, _, err := s.ListCollections(ctx, store.ListOptions{Cursor: "not-a-cursor"}); !errors.Is(err, store.ErrInvalidCursor) {
+		t.Fatalf("ListCollections with bad cursor: got %v, want ErrInvalidCursor", err)
+	}
+}
+
+func testFilterAndSort(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	col, _ := s.CreateCollection(ctx, "filtered", "")
+	defer s.DeleteCollection(ctx, col.ID, 0)
+	names := []string{"apple", "Banana", "apricot", "cherry", "avocado"}
+	ids := make(map[string]int64)
+	for _, name := range names {
+		item, _ := s.CreateItem(ctx, name, "fruit "+name)
+		defer s.DeleteItem(ctx, item.ID, 0)
+		s.AddItemToCollection(ctx, col.ID, item.ID)
+		ids[name] = item.ID
+	}
+
+	expr, err := filter.Parse(`name~"A" and not name="apple"`, filter.DefaultFields)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	sortKeys, _ := filter.ParseSort("-name", filter.DefaultFields)
+	opts := store.ListOptions{Limit: 2, Filter: expr, Sort: sortKeys}
+	var got []string
+	for {
+		items, next, err := s.ListItemsInCollection(ctx, col.ID, opts)
+		if err != nil {
+			t.Fatalf("ListItemsInCollection: %v", err)
+		}
+		for _, item := range items {
+			got = append(got, item.Name)
+		}
+		if next == "" {
+			break
+		}
+		opts.Cursor = next
+	}
+	want := []string{"Banana", "avocado", "apricot"}
+	if len(got) != len(want) {
+		t.Fatalf("filtered names = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("filtered names = %v, want %v", got, want)
+		}
+	}
+
+	expr, _ = filter.Parse(`created_at>=2000-01-01 and description="fruit cherry"`, filter.DefaultFields)
+	items, _, err := s.ListItems(ctx, store.ListOptions{Filter: expr})
+	if err != nil {
+		t.Fatalf("ListItems: %v", err)
+	}
+	if !containsItem(items, ids["cherry"]) || containsItem(items, ids["apple"]) {
+		t.Fatalf("unexpected filtered items: %+v", items)
+	}
+}
+
+func testConcurrentCreates(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	const n = 20
+	ids := make(chan int64, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			item, err := s.CreateItem(ctx, "concurrent", "")
+			if err != nil {
+				t.Errorf("CreateItem: %v", err)
+				return
+			}
+			ids <- item.ID
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	seen := make(map[int64]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("duplicate ID %d", id)
+		}
+		seen[id] = true
+		s.DeleteItem(ctx, id, 0)
+	}
+}
+
+func testPatch(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	item, err := s.CreateItem(ctx, "patch", "before")
+	if err != nil {
+		t.Fatalf("CreateItem: %v", err)
+	}
+	defer s.DeleteItem(ctx, item.ID, 0)
+	desc := "after"
+	got, err := s.PatchItem(ctx, item.ID, store.Patch{Description: &desc}, item.Version)
+	if err != nil {
+		t.Fatalf("PatchItem: %v", err)
+	}
+	if got.Name != "patch" || got.Description != "after" || got.Version <= item.Version {
+		t.Fatalf("unexpected patched item: %+v", got)
+	}
+	same, err := s.PatchItem(ctx, item.ID, store.Patch{}, 0)
+	if err != nil || same.Version != got.Version {
+		t.Fatalf("empty PatchItem: got %+v, %v", same, err)
+	}
+	if _, err := s.PatchItem(ctx, item.ID, store.Patch{Description: &desc}, item.Version); !errors.Is(err, store.ErrPreconditionFailed) {
+		t.Fatalf("PatchItem with stale version: got %v, want ErrPreconditionFailed", err)
+	}
+
+	col, err := s.CreateCollection(ctx, "patch", "before")
+	if err != nil {
+		t.Fatalf("CreateCollection: %v", err)
+	}
+	defer s.DeleteCollection(ctx, col.ID, 0)
+	name := "renamed"
+	gotCol, err := s.PatchCollection(ctx, col.ID, store.Patch{Name: &name}, 0)
+	if err != nil {
+		t.Fatalf("PatchCollection: %v", err)
+	}
+	if gotCol.Name != "renamed" || gotCol.Description != "before" {
+		t.Fatalf("unexpected patched collection: %+v", gotCol)
+	}
+	if _, err := s.PatchCollection(ctx, -1, store.Patch{Name: &name}, 0); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("PatchCollection of missing collection: got %v, want ErrNotFound", err)
+	}
+}
+
+func testVersions(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	item, err := s.CreateItem(ctx, "versioned", "")
+	if err != nil {
+		t.Fatalf("CreateItem: %v", err)
+	}
+	updated, err := s.UpdateItem(ctx, item.ID, "v2", "", item.Version)
+	if err != nil {
+		t.Fatalf("UpdateItem: %v", err)
+	}
+	if updated.Version <= item.Version {
+		t.Fatalf("expected version to increase, got %d after %d", updated.Version, item.Version)
+	}
+	if _, err := s.UpdateItem(ctx, item.ID, "stale", "", item.Version); !errors.Is(err, store.ErrPreconditionFailed) {
+		t.Fatalf("UpdateItem with stale version: got %v, want ErrPreconditionFailed", err)
+	}
+	if got, _ := s.GetItem(ctx, item.ID); got.Name != "v2" {
+		t.Fatalf("stale update was applied: %+v", got)
+	}
+	if err := s.DeleteItem(ctx, item.ID, item.Version); !errors.Is(err, store.ErrPreconditionFailed) {
+		t.Fatalf("DeleteItem with stale version: got %v, want ErrPreconditionFailed", err)
+	}
+	if err := s.DeleteItem(ctx, item.ID, updated.Version); err != nil {
+		t.Fatalf("DeleteItem: %v", err)
+	}
+
+	col, err := s.CreateCollection(ctx, "versioned", "")
+	if err != nil {
+		t.Fatalf("CreateCollection: %v", err)
+	}
+	if _, err := s.UpdateCollection(ctx, col.ID, "v2", "", col.Version); err != nil {
+		t.Fatalf("UpdateCollection: %v", err)
+	}
+	if _, err := s.UpdateCollection(ctx, col.ID, "stale", "", col.Version); !errors.Is(err, store.ErrPreconditionFailed) {
+		t.Fatalf("UpdateCollection with stale version: got %v, want ErrPreconditionFailed", err)
+	}
+	if err := s.DeleteCollection(ctx, col.ID, col.Version); !errors.Is(err, store.ErrPreconditionFailed) {
+		t.Fatalf("DeleteCollection with stale version: got %v, want ErrPreconditionFailed", err)
+	}
+	if err := s.DeleteCollection(ctx, col.ID, 0); err != nil {
+		t.Fatalf("DeleteCollection: %v", err)
+	}
+	if err := s.DeleteCollection(ctx, col.ID, col.Version); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("DeleteCollection of missing collection with version: got %v, want ErrNotFound", err)
+	}
+}
+
+// stepClock returns a clock that starts at start and advances by a minute on
+// every reading.
+func stepClock(start time.Time) store.Clock {
+	var mu sync.Mutex
+	next := start
+	return func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		now := next
+		next = next.Add(time.Minute)
+		return now
+	}
+}
+
+func testTimestamps(t *testing.T, newStore func(t *testing.T, now store.Clock) store.Store) {
+	ctx := context.Background()
+	start := time.Date(2026, 3, 1, 9, 30, 0, 0, time.FixedZone("CET", 3600))
+	s := newStore(t, stepClock(start.Add(500*time.Millisecond)))
+
+	item, err := s.CreateItem(ctx, "stamped", "")
+	if err != nil {
+		t.Fatalf("CreateItem: %v", err)
+	}
+	defer s.DeleteItem(ctx, item.ID, 0)
+	want := start.UTC()
+	if !item.CreatedAt.Equal(want) || !item.UpdatedAt.Equal(want) || item.CreatedAt.Location() != time.UTC {
+		t.Fatalf("CreateItem stamped %v / %v, want %v in UTC", item.CreatedAt, item.UpdatedAt, want)
+	}
+	updated, err := s.UpdateItem(ctx, item.ID, "restamped", "", 0)
+	if err != nil {
+		t.Fatalf("UpdateItem: %v", err)
+	}
+	if !updated.CreatedAt.Equal(want) || !updated.UpdatedAt.After(updated.CreatedAt) {
+		t.Fatalf("UpdateItem stamped %v / %v, want created_at %v and a later updated_at", updated.CreatedAt, updated.UpdatedAt, want)
+	}
+	got, err := s.GetItem(ctx, item.ID)
+	if err != nil {
+		t.Fatalf("GetItem: %v", err)
+	}
+	if !got.CreatedAt.Equal(updated.CreatedAt) || !got.UpdatedAt.Equal(updated.UpdatedAt) {
+		t.Fatalf("GetItem returned %v / %v, want %v / %v", got.CreatedAt, got.UpdatedAt, updated.CreatedAt, updated.UpdatedAt)
+	}
+
+	col, err := s.CreateCollection(ctx, "stamped", "")
+	if err != nil {
+		t.Fatalf("CreateCollection: %v", err)
+	}
+	defer s.DeleteCollection(ctx, col.ID, 0)
+	if err := s.AddItemToCollection(ctx, col.ID, item.ID); err != nil {
+		t.Fatalf("AddItemToCollection: %v", err)
+	}
+	after, err := s.GetCollection(ctx, col.ID)
+	if err != nil {
+		t.Fatalf("GetCollection: %v", err)
+	}
+	if !after.UpdatedAt.Equal(col.UpdatedAt) || !after.MembersUpdatedAt.After(col.MembersUpdatedAt) {
+		t.Fatalf("membership change stamped %+v, created as %+v", after, col)
+	}
+}
+
+// manualClock is a clock that only moves when advanced.
+type manualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *manualClock) read() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *manualClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func testIdempotencyKeys(t *testing.T, newStore func(t *testing.T, now store.Clock) store.Store) {
+	ctx := context.Background()
+	clock := &manualClock{now: time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)}
+	s := newStore(t, clock.read)
+	// Keys are unique per run, since the store may be shared.
+	key := fmt.Sprintf("conformance-%d", time.Now().UnixNano())
+	reserve := func(key, fingerprint string) *store.IdempotencyRecord {
+		t.Helper()
+		rec, err := s.ReserveIdempotencyKey(ctx, key, fingerprint, time.Hour)
+		if err != nil {
+			t.Fatalf("ReserveIdempotencyKey(%q): %v", key, err)
+		}
+		return rec
+	}
+
+	if rec := reserve(key, "a"); rec != nil {
+		t.Fatalf("first reservation returned %+v, want nil", rec)
+	}
+	if rec := reserve(key, "b"); rec == nil || rec.Fingerprint != "a" || rec.StatusCode != 0 {
+		t.Fatalf("reservation in progress returned %+v", rec)
+	}
+	// Keys are compared exactly, so keys differing only in case are distinct.
+	upper := strings.ToUpper(key)
+	if rec := reserve(upper, "b"); rec != nil {
+		t.Fatalf("reservation of %q returned %+v, want nil", upper, rec)
+	}
+	if err := s.ReleaseIdempotencyKey(ctx, upper); err != nil {
+		t.Fatalf("ReleaseIdempotencyKey: %v", err)
+	}
+	header := map[string][]string{"Content-Type": {"application/json"}}
+	if err := s.CompleteIdempotencyKey(ctx, key, 201, header, []byte(`{"id":1}`)); err != nil {
+		t.Fatalf("CompleteIdempotencyKey: %v", err)
+	}
+	clock.advance(30 * time.Minute)
+	rec := reserve(key, "a")
+	if rec == nil || rec.StatusCode != 201 || string(rec.Body) != `{"id":1}` || rec.Header["Content-Type"][0] != "application/json" {
+		t.Fatalf("completed reservation returned %+v", rec)
+	}
+
+	// Expired keys can be reused, and abandoned reservations are taken over.
+	clock.advance(time.Hour)
+	if rec := reserve(key, "b"); rec != nil {
+		t.Fatalf("reservation of expired key returned %+v, want nil", rec)
+	}
+	clock.advance(2 * time.Minute)
+	if rec := reserve(key, "c"); rec != nil {
+		t.Fatalf("reservation of abandoned key returned %+v, want nil", rec)
+	}
+	if err := s.ReleaseIdempotencyKey(ctx, key); err != nil {
+		t.Fatalf("ReleaseIdempotencyKey: %v", err)
+	}
+	if rec := reserve(key, "d"); rec != nil {
+		t.Fatalf("reservation of released key returned %+v, want nil", rec)
+	}
+	if err := s.CompleteIdempotencyKey(ctx, key, 200, nil, nil); err != nil {
+		t.Fatalf("CompleteIdempotencyKey: %v", err)
+	}
+
+	clock.advance(2 * time.Hour)
+	n, err := s.PurgeIdempotencyKeys(ctx)
+	if err != nil || n < 1 {
+		t.Fatalf("PurgeIdempotencyKeys = %d, %v, want at least 1", n, err)
+	}
+	if rec := reserve(key, "e"); rec != nil {
+		t.Fatalf("reservation of purged key returned %+v, want nil", rec)
+	}
+	s.ReleaseIdempotencyKey(ctx, key)
+}
+
+func testTransactions(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	errAbort := errors.New("abort")
+	var rolledBack int64
+	err := s.WithTx(ctx, func(tx store.Store) error {
+		item, err := tx.CreateItem(ctx, "rolled back", "")
+		if err != nil {
+			return err
+		}
+		rolledBack = item.ID
+		if _, err := tx.GetItem(ctx, item.ID); err != nil {
+			t.Errorf("GetItem inside transaction: %v", err)
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("WithTx returned %v, want errAbort", err)
+	}
+	if _, err := s.GetItem(ctx, rolledBack); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetItem after rollback: got %v, want ErrNotFound", err)
+	}
+
+	var item *store.Item
+	var col *store.Collection
+	err = s.WithTx(ctx, func(tx store.Store) error {
+		var err error
+		if item, err = tx.CreateItem(ctx, "committed", ""); err != nil {
+			return err
+		}
+		if col, err = tx.CreateCollection(ctx, "committed", ""); err != nil {
+			return err
+		}
+		// A nested WithTx joins the outer transaction.
+		return tx.WithTx(ctx, func(tx store.Store) error {
+			return tx.AddItemToCollection(ctx, col.ID, item.ID)
+		})
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+	defer s.DeleteItem(ctx, item.ID, 0)
+	defer s.DeleteCollection(ctx, col.ID, 0)
+	items, _, err := s.ListItemsInCollection(ctx, col.ID, store.ListOptions{})
+	if err != nil {
+		t.Fatalf("ListItemsInCollection: %v", err)
+	}
+	if len(items) != 1 || items[0].ID != item.ID {
+		t.Fatalf("unexpected items after commit: %+v", items)
+	}
+}
+
+func testCanceledContext(t *testing.T, s store.Store) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.CreateItem(ctx, "canceled", ""); !errors.Is(err, context.Canceled) {
+		t.Fatalf("CreateItem: got %v, want context.Canceled", err)
+	}
+	if _, _, err := s.ListItems(ctx, store.ListOptions{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ListItems: got %v, want context.Canceled", err)
+	}
+	err := s.WithTx(ctx, func(tx store.Store) error { return nil })
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("WithTx: got %v, want context.Canceled", err)
+	}
+}
+
+func containsItem(items []store.Item, id int64) bool {
+	for _, item := range items {
+		if item.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,289 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxTagLength is the maximum length of a tag in characters.
+const MaxTagLength = 64
+
+// TagCount reports how many items and collections carry a tag.
+type TagCount struct {
+	Tag         string
+	Items       int
+	Collections int
+}
+
+// TagListOptions controls ListTags. An empty Prefix matches every tag and a
+// zero Limit means no limit.
+type TagListOptions struct {
+	Prefix string
+	Limit  int
+}
+
+// NormalizeTags lowercases and trims the tags, and returns them sorted and
+// without duplicates. A tag is made of letters, digits and the characters
+// "-", "_", ":" and ".", so that it can be used as a URL path segment. It
+// returns ErrValidation for an empty, too long or otherwise malformed tag.
+func NormalizeTags(tags []string) ([]string, error) {
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			return nil, fmt.Errorf("%w: tags must not be empty", ErrValidation)
+		}
+		if utf8.RuneCountInString(tag) > MaxTagLength {
+			return nil, fmt.Errorf("%w: tag %q is longer than %d characters", ErrValidation, tag, MaxTagLength)
+		}
+		for _, r := range tag {
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("-_:.", r) {
+				return nil, fmt.Errorf("%w: tag %q contains %q", ErrValidation, tag, r)
+			}
+		}
+		out = append(out, tag)
+	}
+	slices.Sort(out)
+	return slices.Compact(out), nil
+}
+
+// tagsOf returns the tags of the rows of the given kind, keyed by ID.
+func tagsOf(ctx context.Context, q Querier, kind string, ids []int64) (map[int64][]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	rows, err := q.QueryContext(ctx, "SELECT "+kind+"_id, tag FROM "+kind+"_tags WHERE "+kind+"_id IN ("+placeholders(len(ids), "?")+") ORDER BY tag", idArgs(ids)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := make(map[int64][]string)
+	for rows.Next() {
+		var id int64
+		var tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return nil, err
+		}
+		tags[id] = append(tags[id], tag)
+	}
+	return tags, rows.Err()
+}
+
+// loadItemTags fills in the tags of the items.
+func loadItemTags(ctx context.Context, q Querier, items []Item) error {
+	ids := make([]int64, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	tags, err := tagsOf(ctx, q, "item", ids)
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		items[i].Tags = tags[items[i].ID]
+	}
+	return nil
+}
+
+// loadCollectionTags fills in the tags of the collections.
+func loadCollectionTags(ctx context.Context, q Querier, cols []Collection) error {
+	ids := make([]int64, len(cols))
+	for i, col := range cols {
+		ids[i] = col.ID
+	}
+	tags, err := tagsOf(ctx, q, "collection", ids)
+	if err != nil {
+		return err
+	}
+	for i := range cols {
+		cols[i].Tags = tags[cols[i].ID]
+	}
+	return nil
+}
+
+// hasTag returns the start of an EXISTS condition on the tags of the row of
+// the given kind whose ID is ref. The caller completes the comparison of
+// tg.tag and closes the parenthesis.
+func hasTag(kind, ref string) string {
+	return "EXISTS (SELECT 1 FROM " + kind + "_tags tg WHERE tg." + kind + "_id = " + ref + " AND tg.tag "
+}
+
+// tagConditions returns the conditions that restrict a list of rows of the
+// given kind to opts.TagsAll and opts.TagsAny. ref is the ID column of the
+// listed rows.
+func tagConditions(kind, ref string, opts ListOptions) ([]string, []any) {
+	var conds []string
+	var args []any
+	has := hasTag(kind, ref)
+	for _, tag := range opts.TagsAll {
+		conds = append(conds, has+"= ?)")
+		args = append(args, tag)
+	}
+	if len(opts.TagsAny) > 0 {
+		conds = append(conds, has+"IN ("+placeholders(len(opts.TagsAny), "?")+"))")
+		for _, tag := range opts.TagsAny {
+			args = append(args, tag)
+		}
+	}
+	return conds, args
+}
+
+// matchTags reports whether tags satisfy opts.TagsAll and opts.TagsAny.
+func matchTags(tags []string, opts ListOptions) bool {
+	for _, tag := range opts.TagsAll {
+		if !slices.Contains(tags, tag) {
+			return false
+		}
+	}
+	if len(opts.TagsAny) == 0 {
+		return true
+	}
+	for _, tag := range opts.TagsAny {
+		if slices.Contains(tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddItemTags tags an item and returns it. Tags the item already has are
+// ignored; if any tag is new, the item's version is incremented. It returns
+// ErrValidation for a malformed tag, ErrNotFound if the item does not exist
+// and ErrPreconditionFailed if version is non-zero and not the current one.
+func AddItemTags(ctx context.Context, q Querier, id int64, tags []string, version int64, now time.Time) (*Item, error) {
+	return changeItemTags(ctx, q, id, tags, true, version, now)
+}
+
+// RemoveItemTags removes tags from an item and returns it. Tags the item
+// does not have are ignored. It returns the same errors as AddItemTags.
+func RemoveItemTags(ctx context.Context, q Querier, id int64, tags []string, version int64, now time.Time) (*Item, error) {
+	return changeItemTags(ctx, q, id, tags, false, version, now)
+}
+
+func changeItemTags(ctx context.Context, q Querier, id int64, tags []string, add bool, version int64, now time.Time) (*Item, error) {
+	var item *Item
+	err := withTx(ctx, q, func(q Querier) error {
+		changed, err := changeTags(ctx, q, "item", id, tags, add, version, now)
+		if err != nil {
+			return err
+		}
+		if changed {
+			// Listings of the item's collections show its tags.
+			if err := touchItemCollections(ctx, q, id, now); err != nil {
+				return err
+			}
+		}
+		item, err = GetItem(ctx, q, id)
+		return err
+	})
+	return item, err
+}
+
+// AddCollectionTags tags a collection and returns it, like AddItemTags.
+func AddCollectionTags(ctx context.Context, q Querier, id int64, tags []string, version int64, now time.Time) (*Collection, error) {
+	return changeCollectionTags(ctx, q, id, tags, true, version, now)
+}
+
+// RemoveCollectionTags removes tags from a collection and returns it, like
+// RemoveItemTags.
+func RemoveCollectionTags(ctx context.Context, q Querier, id int64, tags []string, version int64, now time.Time) (*Collection, error) {
+	return changeCollectionTags(ctx, q, id, tags, false, version, now)
+}
+
+func changeCollectionTags(ctx context.Context, q Querier, id int64, tags []string, add bool, version int64, now time.Time) (*Collection, error) {
+	var col *Collection
+	err := withTx(ctx, q, func(q Querier) error {
+		if _, err := changeTags(ctx, q, "collection", id, tags, add, version, now); err != nil {
+			return err
+		}
+		var err error
+		col, err = GetCollection(ctx, q, id)
+		return err
+	})
+	return col, err
+}
+
+// changeTags adds or removes the tags of a row of the given kind, and
+// increments its version if that changed anything. The row is locked first,
+// whether or not a version is given, so that concurrent changes serialize.
+func changeTags(ctx context.Context, q Querier, kind string, id int64, tags []string, add bool, version int64, now time.Time) (bool, error) {
+	tags, err := NormalizeTags(tags)
+	if err != nil {
+		return false, err
+	}
+	if err := lockVersion(ctx, q, kind, id, version); err != nil {
+		return false, err
+	}
+	if len(tags) == 0 {
+		return false, nil
+	}
+	args := make([]any, 0, 2*len(tags))
+	var query string
+	if add {
+		for _, tag := range tags {
+			args = append(args, id, tag)
+		}
+		query = "INSERT IGNORE INTO " + kind + "_tags (" + kind + "_id, tag) VALUES " + placeholders(len(tags), "(?, ?)")
+	} else {
+		args = append(args, id)
+		for _, tag := range tags {
+			args = append(args, tag)
+		}
+		query = "DELETE FROM " + kind + "_tags WHERE " + kind + "_id = ? AND tag IN (" + placeholders(len(tags), "?") + ")"
+	}
+	res, err := q.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	_, err = q.ExecContext(ctx, "UPDATE "+kind+"s SET version = version + 1, updated_at = ? WHERE id = ?", now, id)
+	return true, err
+}
+
+// ListTags returns the tags in use that start with opts.Prefix, with the
+// number of items and collections carrying each. The most used tags come
+// first, and tags used equally often are sorted by name.
+func ListTags(ctx context.Context, q Querier, opts TagListOptions) ([]TagCount, error) {
+	like := strings.ToLower(escapeLike(opts.Prefix)) + "%"
+	query := "SELECT tag, SUM(items), SUM(collections) FROM (" +
+		"SELECT t.tag, 1 AS items, 0 AS collections FROM item_tags t JOIN items i ON i.id = t.item_id WHERE i.deleted_at IS NULL AND t.tag LIKE ? " +
+		"UNION ALL SELECT t.tag, 0, 1 FROM collection_tags t JOIN collections c ON c.id = t.collection_id WHERE c.deleted_at IS NULL AND t.tag LIKE ?" +
+		") t GROUP BY tag ORDER BY SUM(items) + SUM(collections) DESC, tag"
+	args := []any{like, like}
+	if opts.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, opts.Limit)
+	}
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var counts []TagCount
+	for rows.Next() {
+		var c TagCount
+		if err := rows.Scan(&c.Tag, &c.Items, &c.Collections); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// sortTagCounts orders tag counts like ListTags.
+func sortTagCounts(counts []TagCount) {
+	slices.SortFunc(counts, func(a, b TagCount) int {
+		if c := (b.Items + b.Collections) - (a.Items + a.Collections); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Tag, b.Tag)
+	})
+}
@@ -0,0 +1,268 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Deleted items and collections stay in their tables with deleted_at set,
+// and are hidden from every other operation. A deleted collection's
+// deleted_with is the collection whose deletion moved it to the trash: its
+// own ID, or an ancestor's for a recursive delete. Their memberships are moved
+// to deleted_collection_items, so that collection_items only relates live
+// rows, and moved back when both sides are live again.
+
+// notDeleted reports a restore of a row that is not in the trash.
+func notDeleted(kind string, id int64) error {
+	return fmt.Errorf("%w: %s %d is not deleted", ErrConflict, kind, id)
+}
+
+// lockDeleted locks a deleted row of the given kind. It returns ErrNotFound
+// if the row does not exist, ErrConflict if it is not deleted, and
+// ErrPreconditionFailed if version is non-zero and not the current one.
+func lockDeleted(ctx context.Context, q Querier, kind string, id, version int64) error {
+	var current int64
+	var deleted sql.NullTime
+	err := q.QueryRowContext(ctx, "SELECT version, deleted_at FROM "+kind+"s WHERE id = ? FOR UPDATE", id).Scan(&current, &deleted)
+	if err != nil {
+		return dbError(err, kind, id)
+	}
+	if !deleted.Valid {
+		return notDeleted(kind, id)
+	}
+	if version != 0 && current != version {
+		return preconditionFailed(kind, id, current)
+	}
+	return nil
+}
+
+// trashMemberships moves the memberships whose column, "item_id" or
+// "collection_id", is one of ids to deleted_collection_items.
+func trashMemberships(ctx context.Context, q Querier, column string, ids []int64) error {
+	in := column + " IN (" + placeholders(len(ids), "?") + ")"
+	if _, err := q.ExecContext(ctx, "INSERT IGNORE INTO deleted_collection_items (collection_id, item_id, position) SELECT collection_id, item_id, position FROM collection_items WHERE "+in, idArgs(ids)...); err != nil {
+		return err
+	}
+	_, err := q.ExecContext(ctx, "DELETE FROM collection_items WHERE "+in, idArgs(ids)...)
+	return err
+}
+
+// trashedMember is a deleted membership about to be restored.
+type trashedMember struct {
+	itemID   int64
+	position int64
+}
+
+// restoreMemberships moves the deleted memberships whose column, "item_id"
+// or "collection_id", is one of ids back to collection_items, at their
+// former position, if both their collection and item are live. A
+// collection where that position has since been taken is renumbered, so
+// that ranks stay unique. Memberships of collections that have since become
+// smart, or whose schema the item no longer matches, are dropped.
+func restoreMemberships(ctx context.Context, q Querier, column string, ids []int64, now time.Time) error {
+	live := " FROM deleted_collection_items d JOIN collections c ON c.id = d.collection_id JOIN items i ON i.id = d.item_id WHERE d." + column + " IN (" + placeholders(len(ids), "?") + ") AND c.deleted_at IS NULL AND i.deleted_at IS NULL"
+	rows, err := q.QueryContext(ctx, "SELECT d.collection_id, d.item_id, d.position, c.rule"+live+" ORDER BY d.collection_id, d.item_id FOR UPDATE", idArgs(ids)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	members := make(map[int64][]trashedMember)
+	var order []int64
+	for rows.Next() {
+		var colID int64
+		var m trashedMember
+		var rule sql.NullString
+		if err := rows.Scan(&colID, &m.itemID, &m.position, &rule); err != nil {
+			return err
+		}
+		if rule.String != "" {
+			continue
+		}
+		if _, ok := members[colID]; !ok {
+			order = append(order, colID)
+		}
+		members[colID] = append(members[colID], m)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, colID := range order {
+		itemIDs := make([]int64, len(members[colID]))
+		for i, m := range members[colID] {
+			itemIDs[i] = m.itemID
+		}
+		invalid, err := schemaMismatches(ctx, q, colID, itemIDs)
+		if err != nil {
+			return err
+		}
+		var args []any
+		for _, m := range members[colID] {
+			if invalid[m.itemID] == nil {
+				args = append(args, colID, m.itemID, m.position)
+			}
+		}
+		if len(args) == 0 {
+			continue
+		}
+		res, err := q.ExecContext(ctx, "INSERT IGNORE INTO collection_items (collection_id, item_id, position) VALUES "+placeholders(len(args)/3, "(?, ?, ?)"), args...)
+		if err != nil {
+			return err
+		}
+		if err := touchMembers(ctx, q, res, colID, now); err != nil {
+			return err
+		}
+		var collide bool
+		if err := q.QueryRowContext(ctx, "SELECT COUNT(*) <> COUNT(DISTINCT position) FROM collection_items WHERE collection_id = ?", colID).Scan(&collide); err != nil {
+			return err
+		}
+		if collide {
+			if err := renumberMembers(ctx, q, colID); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = q.ExecContext(ctx, "DELETE d"+live, idArgs(ids)...)
+	return err
+}
+
+// ListDeletedItems returns a filtered, sorted page of the items in the
+// trash, with their DeletedAt set, along with the next cursor.
+func ListDeletedItems(ctx context.Context, q Querier, opts ListOptions) ([]Item, string, error) {
+	query, args, err := selectQuery("SELECT id, name, description, created_at, updated_at, version, deleted_at FROM items", "item", "", []string{"deleted_at IS NOT NULL"}, nil, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	var items []Item
+	for rows.Next() {
+		var itm Item
+		if err := rows.Scan(&itm.ID, &itm.Name, &itm.Description, &itm.CreatedAt, &itm.UpdatedAt, &itm.Version, &itm.DeletedAt); err != nil {
+			return nil, "", err
+		}
+		items = append(items, itm)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	items, next := paginate(items, opts)
+	if err := loadItemTags(ctx, q, items); err != nil {
+		return nil, "", err
+	}
+	if err := loadItemFields(ctx, q, items); err != nil {
+		return nil, "", err
+	}
+	return items, next, nil
+}
+
+// ListDeletedCollections returns a filtered, sorted page of the
+// collections in the trash along with the next cursor.
+func ListDeletedCollections(ctx context.Context, q Querier, opts ListOptions) ([]Collection, string, error) {
+	query, args, err := selectQuery("SELECT id, name, description, created_at, updated_at, version, members_updated_at, parent_id, rule, field_schema, deleted_at FROM collections", "collection", "", []string{"deleted_at IS NOT NULL"}, nil, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	return queryCollections(ctx, q, query, args, opts)
+}
+
+// RestoreItem takes an item out of the trash, together with its memberships
+// of live collections, and increments its version. It returns ErrNotFound if
+// the item does not exist, ErrConflict if it is not deleted, and
+// ErrPreconditionFailed if version is non-zero and not the current one.
+func RestoreItem(ctx context.Context, q Querier, id, version int64, now time.Time) (*Item, error) {
+	var item *Item
+	err := withTx(ctx, q, func(q Querier) error {
+		if err := lockDeleted(ctx, q, "item", id, version); err != nil {
+			return err
+		}
+		if _, err := q.ExecContext(ctx, "UPDATE items SET deleted_at = NULL, version = version + 1, updated_at = ? WHERE id = ?", now, id); err != nil {
+			return err
+		}
+		if err := restoreMemberships(ctx, q, "item_id", []int64{id}, now); err != nil {
+			return err
+		}
+		var err error
+		item, err = GetItem(ctx, q, id)
+		return err
+	})
+	return item, err
+}
+
+// RestoreCollection takes a collection out of the trash, together with the
+// descendants deleted along with it and the memberships of live items, and
+// increments their versions. It returns the same errors as RestoreItem, and
+// ErrConflict if the parent collection is deleted.
+func RestoreCollection(ctx context.Context, q Querier, id, version int64, now time.Time) (*Collection, error) {
+	var col *Collection
+	err := withTx(ctx, q, func(q Querier) error {
+		if err := lockDeleted(ctx, q, "collection", id, version); err != nil {
+			return err
+		}
+		var parent sql.NullInt64
+		if err := q.QueryRowContext(ctx, "SELECT parent_id FROM collections WHERE id = ?", id).Scan(&parent); err != nil {
+			return err
+		}
+		if parent.Valid {
+			if err := mustExist(ctx, q, "collection", parent.Int64); err != nil {
+				return fmt.Errorf("%w: parent collection %d is deleted; restore it first", ErrConflict, parent.Int64)
+			}
+		}
+		found, err := selectIDs(ctx, q, subtreeCTE+"SELECT id FROM collections WHERE id IN (SELECT id FROM tree) AND deleted_with = ? FOR UPDATE", id, id)
+		if err != nil {
+			return err
+		}
+		ids := make([]int64, 0, len(found))
+		for colID := range found {
+			ids = append(ids, colID)
+		}
+		if _, err := q.ExecContext(ctx, "UPDATE collections SET deleted_at = NULL, deleted_with = NULL, version = version + 1, updated_at = ? WHERE id IN ("+placeholders(len(ids), "?")+")", idArgs(ids, now)...); err != nil {
+			return err
+		}
+		if err := restoreMemberships(ctx, q, "collection_id", ids, now); err != nil {
+			return err
+		}
+		col, err = GetCollection(ctx, q, id)
+		return err
+	})
+	return col, err
+}
+
+// PurgeDeleted permanently removes the items and collections that were
+// deleted at or before before, with their tags, custom fields and deleted
+// memberships, and returns how many rows were removed.
+func PurgeDeleted(ctx context.Context, q Querier, before time.Time) (int64, error) {
+	var purged int64
+	err := withTx(ctx, q, func(q Querier) error {
+		for _, query := range []string{
+			"DELETE t FROM item_tags t JOIN items i ON i.id = t.item_id WHERE i.deleted_at <= ?",
+			"DELETE f FROM item_fields f JOIN items i ON i.id = f.item_id WHERE i.deleted_at <= ?",
+			"DELETE d FROM deleted_collection_items d JOIN items i ON i.id = d.item_id WHERE i.deleted_at <= ?",
+			"DELETE t FROM collection_tags t JOIN collections c ON c.id = t.collection_id WHERE c.deleted_at <= ?",
+			"DELETE d FROM deleted_collection_items d JOIN collections c ON c.id = d.collection_id WHERE c.deleted_at <= ?",
+		} {
+			if _, err := q.ExecContext(ctx, query, before); err != nil {
+				return err
+			}
+		}
+		for _, table := range []string{"items", "collections"} {
+			res, err := q.ExecContext(ctx, "DELETE FROM "+table+" WHERE deleted_at <= ?", before)
+			if err != nil {
+				return err
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			purged += n
+		}
+		return nil
+	})
+	return purged, err
+}
@@ -0,0 +1,154 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// subtreeCTE is a recursive common table expression named tree that holds
+// the ID of a collection, its first placeholder, and of all its descendants.
+const subtreeCTE = "WITH RECURSIVE tree AS (SELECT id FROM collections WHERE id = ? UNION ALL SELECT c.id FROM collections c JOIN tree t ON c.parent_id = t.id) "
+
+// scanner is implemented by *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanCollection scans the columns id, name, description, created_at,
+// updated_at, version, members_updated_at, parent_id, rule, field_schema
+// and deleted_at.
+func scanCollection(row scanner, col *Collection) error {
+	var parent sql.NullInt64
+	var rule, schema sql.NullString
+	var deleted sql.NullTime
+	if err := row.Scan(&col.ID, &col.Name, &col.Description, &col.CreatedAt, &col.UpdatedAt, &col.Version, &col.MembersUpdatedAt, &parent, &rule, &schema, &deleted); err != nil {
+		return err
+	}
+	col.ParentID = parent.Int64
+	col.Rule = rule.String
+	col.DeletedAt = deleted.Time
+	var err error
+	col.Schema, err = scanSchema(schema)
+	return err
+}
+
+// cycleError reports a move that would nest a collection inside itself.
+func cycleError(id, parentID int64) error {
+	return fmt.Errorf("%w: moving collection %d under collection %d would create a cycle", ErrConflict, id, parentID)
+}
+
+// mustBeLeaf returns ErrConflict if the collection has live child
+// collections. The collection row is locked first, so that no child can be
+// added until the transaction ends.
+func mustBeLeaf(ctx context.Context, q Querier, id int64) error {
+	var one int
+	err := q.QueryRowContext(ctx, "SELECT 1 FROM collections WHERE id = ? AND deleted_at IS NULL FOR UPDATE", id).Scan(&one)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = q.QueryRowContext(ctx, "SELECT 1 FROM collections WHERE parent_id = ? AND deleted_at IS NULL LIMIT 1", id).Scan(&one)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: collection %d has child collections", ErrConflict, id)
+}
+
+// MoveCollection nests a collection under parentID, or moves it to the top
+// level if parentID is zero, and increments its version. It returns
+// ErrNotFound if either collection does not exist, ErrConflict if the
+// parent is the collection itself or one of its descendants, and
+// ErrPreconditionFailed if version is non-zero and not the current one.
+func MoveCollection(ctx context.Context, q Querier, id, parentID int64, version int64, now time.Time) (*Collection, error) {
+	var col *Collection
+	err := withTx(ctx, q, func(q Querier) error {
+		if err := lockVersion(ctx, q, "collection", id, version); err != nil {
+			return err
+		}
+		// Walking up from the new parent finds the collection itself if the
+		// move would create a cycle. The ancestors stay locked, so that a
+		// concurrent move cannot create one either.
+		for ancestor := parentID; ancestor != 0; {
+			if ancestor == id {
+				return cycleError(id, parentID)
+			}
+			var next sql.NullInt64
+			if err := q.QueryRowContext(ctx, "SELECT parent_id FROM collections WHERE id = ? AND deleted_at IS NULL FOR UPDATE", ancestor).Scan(&next); err != nil {
+				return dbError(err, "collection", ancestor)
+			}
+			ancestor = next.Int64
+		}
+		parent := sql.NullInt64{Int64: parentID, Valid: parentID != 0}
+		if _, err := q.ExecContext(ctx, "UPDATE collections SET parent_id = ?, version = version + 1, updated_at = ? WHERE id = ?", parent, now, id); err != nil {
+			return err
+		}
+		var err error
+		col, err = GetCollection(ctx, q, id)
+		return err
+	})
+	return col, err
+}
+
+// ListChildCollections returns a filtered, sorted page of the collections
+// nested directly in parentID, along with the next cursor. It returns
+// ErrNotFound if the parent does not exist.
+func ListChildCollections(ctx context.Context, q Querier, parentID int64, opts ListOptions) ([]Collection, string, error) {
+	if err := mustExist(ctx, q, "collection", parentID); err != nil {
+		return nil, "", err
+	}
+	query, args, err := selectQuery("SELECT id, name, description, created_at, updated_at, version, members_updated_at, parent_id, rule, field_schema, deleted_at FROM collections", "collection", "", []string{"parent_id = ?", "deleted_at IS NULL"}, []any{parentID}, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	return queryCollections(ctx, q, query, args, opts)
+}
+
+// CollectionSubtree returns a collection and all its descendants, ordered
+// by ID. It returns ErrNotFound if the collection does not exist.
+func CollectionSubtree(ctx context.Context, q Querier, id int64) ([]Collection, error) {
+	cols, _, err := queryCollections(ctx, q, subtreeCTE+"SELECT id, name, description, created_at, updated_at, version, members_updated_at, parent_id, rule, field_schema, deleted_at FROM collections WHERE id IN (SELECT id FROM tree) AND deleted_at IS NULL ORDER BY id", []any{id}, ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if len(cols) == 0 {
+		return nil, notFound("collection", id)
+	}
+	return cols, nil
+}
+
+// DeleteCollectionTree moves a collection together with all its descendants
+// and their memberships to the trash, and returns the IDs of the deleted
+// collections. They are marked as deleted with the collection, so that
+// restoring it restores them too, but not descendants already in the trash.
+// Like DeleteCollection, a missing collection is not an error unless a
+// version is given.
+func DeleteCollectionTree(ctx context.Context, q Querier, id int64, version int64, now time.Time) ([]int64, error) {
+	var ids []int64
+	err := withTx(ctx, q, func(q Querier) error {
+		if err := checkVersion(ctx, q, "collection", id, version); err != nil {
+			return err
+		}
+		found, err := selectIDs(ctx, q, subtreeCTE+"SELECT id FROM collections WHERE id IN (SELECT id FROM tree) AND deleted_at IS NULL FOR UPDATE", id)
+		if err != nil || len(found) == 0 {
+			return err
+		}
+		for colID := range found {
+			ids = append(ids, colID)
+		}
+		slices.Sort(ids)
+		if err := trashMemberships(ctx, q, "collection_id", ids); err != nil {
+			return err
+		}
+		_, err = q.ExecContext(ctx, "UPDATE collections SET deleted_at = ?, deleted_with = ? WHERE id IN ("+placeholders(len(ids), "?")+")", idArgs(ids, now, id)...)
+		return err
+	})
+	return ids, err
+}
@@ -0,0 +1,32 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Querier is implemented by *sql.DB and *sql.Tx. The package-level functions
+// accept it so that they can run either standalone or inside a transaction.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// withTx runs fn in a transaction. If q is already a transaction, fn runs in
+// it and the caller remains responsible for committing.
+func withTx(ctx context.Context, q Querier, fn func(q Querier) error) error {
+	db, ok := q.(*sql.DB)
+	if !ok {
+		return fn(q)
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}